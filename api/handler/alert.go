@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/services/alert_srv"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AlertHandler struct {
+	alertService *alert_srv.AlertService
+
+	ctx context.Context
+}
+
+func NewAlertHandler(ctx context.Context, alertService *alert_srv.AlertService) *AlertHandler {
+	return &AlertHandler{
+		alertService: alertService,
+
+		ctx: ctx,
+	}
+}
+
+type AlertSettingsJSON struct {
+	Recipients []string `json:"recipients" validate:"dive,email"`
+	Mode       string   `json:"mode" validate:"required,oneof=instant digest off"`
+	Decisions  []string `json:"decisions" validate:"dive,oneof=decline review"`
+}
+
+func (ah *AlertHandler) GetSettings(c *fiber.Ctx) error {
+	settings, err := ah.alertService.GetSettings(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(settings)
+}
+
+func (ah *AlertHandler) UpdateSettings(c *fiber.Ctx) error {
+	var b AlertSettingsJSON
+
+	if err := c.BodyParser(&b); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(b); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	settings, err := ah.alertService.GetSettings(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	settings.Recipients = b.Recipients
+	settings.Mode = b.Mode
+	settings.Decisions = b.Decisions
+	if settings.Decisions == nil {
+		settings.Decisions = []string{domain.DecisionDecline, domain.DecisionReview}
+	}
+
+	if err = ah.alertService.SaveSettings(&settings); err != nil {
+		return err
+	}
+
+	return c.JSON(settings)
+}
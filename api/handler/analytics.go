@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"fraud-detect-system/domain"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+	_ "time/tzdata" // the Lambda runtime has no zoneinfo
+)
+
+// days in each preset range, today included
+var analyticsRanges = map[string]int{
+	"7d":  7,
+	"30d": 30,
+	"90d": 90,
+}
+
+const (
+	MetricCount     = "count"
+	MetricVolume    = "volume"
+	MetricBlockRate = "block_rate"
+	MetricAvgRisk   = "avg_risk"
+)
+
+// parseAnalyticsQuery reads the chart settings from the query string:
+//
+//	range        7d, 30d or 90d ending today, or custom with from and to; 30d by default
+//	from, to     yyyy-mm-dd days or RFC 3339 times for a custom range, to is included
+//	granularity  hour, day or week; day by default
+//	tz           an IANA time zone days and weeks are counted in; UTC by default
+func parseAnalyticsQuery(c *fiber.Ctx, merchant string, now time.Time) (domain.AnalyticsQuery, error) {
+	q := domain.AnalyticsQuery{
+		Merchant:    merchant,
+		Granularity: c.Query("granularity", domain.GranularityDay),
+	}
+
+	loc, err := time.LoadLocation(c.Query("tz", "UTC"))
+	if err != nil {
+		return q, fiber.NewError(fiber.StatusBadRequest, "tz must be an IANA time zone such as Africa/Lagos")
+	}
+	q.Location = loc
+
+	day := func(t time.Time) time.Time {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+
+	switch r := c.Query("range", "30d"); r {
+	case "custom":
+		from, fromErr := parseDay(c.Query("from"), loc)
+		to, toErr := parseDay(c.Query("to"), loc)
+		if fromErr != nil || toErr != nil {
+			return q, fiber.NewError(fiber.StatusBadRequest, "a custom range needs from and to as yyyy-mm-dd dates or RFC 3339 times")
+		}
+
+		q.From = from
+		q.To = to
+		if to.Equal(day(to)) {
+			q.To = to.AddDate(0, 0, 1)
+		}
+	default:
+		days, ok := analyticsRanges[r]
+		if !ok {
+			return q, fiber.NewError(fiber.StatusBadRequest, "range must be 7d, 30d, 90d or custom")
+		}
+
+		q.From = day(now).AddDate(0, 0, 1-days)
+		q.To = now
+	}
+
+	if err = q.Validate(); err != nil {
+		return q, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return q, nil
+}
+
+func parseDay(v string, loc *time.Location) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, errors.New("missing")
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, v, loc)
+}
+
+// chartData turns a series into the datasets the dashboard chart draws, one or two
+// per metric asked for in the comma separated metrics parameter. Only count, the
+// valid and fraudulent transactions per period, is drawn by default.
+func chartData(c *fiber.Ctx, q domain.AnalyticsQuery, series []domain.AnalyticsBucket) (ChartData, error) {
+	labels := make([]string, 0, len(series))
+	for _, b := range series {
+		labels = append(labels, periodLabel(q.Granularity, b.Start))
+	}
+
+	dataset := func(label string, value func(domain.AnalyticsBucket) float64) Dataset {
+		data := make([]float64, 0, len(series))
+		for _, b := range series {
+			data = append(data, value(b))
+		}
+
+		return Dataset{Label: label, Data: data}
+	}
+
+	chart := ChartData{Labels: labels, Datasets: []Dataset{}}
+
+	for _, metric := range strings.Split(c.Query("metrics", MetricCount), ",") {
+		switch strings.TrimSpace(metric) {
+		case MetricCount:
+			chart.Datasets = append(chart.Datasets,
+				dataset("Valid Transactions", func(b domain.AnalyticsBucket) float64 { return float64(b.Count - b.Blocked) }),
+				dataset("Fraudulent Transactions", func(b domain.AnalyticsBucket) float64 { return float64(b.Blocked) }),
+			)
+		case MetricVolume:
+			chart.Datasets = append(chart.Datasets,
+				dataset("Volume", func(b domain.AnalyticsBucket) float64 { return b.Volume }),
+				dataset("Volume Blocked", func(b domain.AnalyticsBucket) float64 { return b.VolumeBlocked }),
+			)
+		case MetricBlockRate:
+			chart.Datasets = append(chart.Datasets,
+				dataset("Block Rate", func(b domain.AnalyticsBucket) float64 { return b.BlockRate }),
+			)
+		case MetricAvgRisk:
+			chart.Datasets = append(chart.Datasets,
+				dataset("Average Risk", func(b domain.AnalyticsBucket) float64 { return b.AvgRisk }),
+			)
+		default:
+			return ChartData{}, fiber.NewError(fiber.StatusBadRequest, "metrics must be count, volume, block_rate or avg_risk")
+		}
+	}
+
+	return chart, nil
+}
+
+func periodLabel(granularity string, start time.Time) string {
+	switch granularity {
+	case domain.GranularityHour:
+		return start.Format("Jan 2 15:04")
+	case domain.GranularityWeek:
+		return "Week of " + start.Format("January 2")
+	default:
+		return start.Format("January 2")
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/services/api_key_srv"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+)
+
+type ApiKeyHandler struct {
+	apiKeyService *api_key_srv.ApiKeyService
+
+	ctx context.Context
+}
+
+func NewApiKeyHandler(ctx context.Context, apiKeyService *api_key_srv.ApiKeyService) *ApiKeyHandler {
+	return &ApiKeyHandler{
+		apiKeyService: apiKeyService,
+
+		ctx: ctx,
+	}
+}
+
+func (akh *ApiKeyHandler) GetKeys(c *fiber.Ctx) error {
+	keys, err := akh.apiKeyService.List(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(keys)
+}
+
+func (akh *ApiKeyHandler) CreateKey(c *fiber.Ctx) error {
+	b := &struct {
+		Name string `json:"name"`
+		Mode string `json:"mode"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	key, plain, err := akh.apiKeyService.Create(c.Params("id"), b.Name, b.Mode)
+	if err == domain.ErrInvalidApiKeyMode {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     key,
+		"api_key": plain,
+	})
+}
+
+func (akh *ApiKeyHandler) RotateKey(c *fiber.Ctx) error {
+	b := &struct {
+		GracePeriodHours int `json:"grace_period_hours"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil && len(c.Body()) > 0 {
+		return err
+	}
+
+	key, plain, err := akh.apiKeyService.Rotate(c.Params("id"), c.Params("keyId"), time.Duration(b.GracePeriodHours)*time.Hour)
+	if err == api_key_srv.ErrKeyRevoked {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "api key not found")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     key,
+		"api_key": plain,
+	})
+}
+
+func (akh *ApiKeyHandler) RevokeKey(c *fiber.Ctx) error {
+	key, err := akh.apiKeyService.Revoke(c.Params("id"), c.Params("keyId"))
+	if err == api_key_srv.ErrKeyRevoked {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "api key not found")
+	}
+
+	return c.JSON(key)
+}
+
+// RequireApiKey authenticates server to server calls made with a merchant's secret
+// key, sent as a bearer token, and leaves the key in c.Locals("apiKey").
+func (akh *ApiKeyHandler) RequireApiKey(c *fiber.Ctx) error {
+	plain := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+
+	key, err := akh.apiKeyService.Authenticate(plain)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	}
+
+	c.Locals("apiKey", key)
+
+	return c.Next()
+}
+
+func apiKeyFrom(c *fiber.Ctx) (domain.ApiKey, bool) {
+	key, ok := c.Locals("apiKey").(domain.ApiKey)
+	return key, ok
+}
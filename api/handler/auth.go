@@ -2,19 +2,40 @@ package handler
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"fraud-detect-system/domain"
 	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/services/api_key_srv"
+	"fraud-detect-system/services/login_guard_srv"
 	"fraud-detect-system/token"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
-	"math/rand"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// dummyPasswordHash is compared against when no merchant matches the email, at the
+// same bcrypt cost as real hashes.
+var dummyPasswordHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), 10)
+	return string(hash)
+}()
+
 type AuthHandler struct {
-	merchantRepo ports.MerchantRepository
-	store        map[string]token.Session
+	merchantRepo  ports.MerchantRepository
+	store         *token.SessionStore
+	mailer        ports.IMailer
+	loginGuard    *login_guard_srv.LoginGuardService
+	apiKeyService *api_key_srv.ApiKeyService
+	tokens        *token.Maker
+
+	appUrl         string
+	basicAuthUsers map[string]string
 
 	ctx context.Context
 }
@@ -29,104 +50,304 @@ func (h AuthHandler) Login(c *fiber.Ctx) error {
 		return err
 	}
 
-	merchant, err := h.merchantRepo.GetByEmail(b.Email)
+	attempt := domain.LoginAttempt{
+		Email:     b.Email,
+		Ip:        clientIp(c),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+
+	if wait, err := h.loginGuard.Check(c.UserContext(), attempt.Email, attempt.Ip); err != nil {
+		switch err {
+		case login_guard_srv.ErrTooManyAttempts:
+			attempt.Reason = domain.LoginFailedThrottle
+		case login_guard_srv.ErrLocked:
+			attempt.Reason = domain.LoginFailedLocked
+		default:
+			return err
+		}
+		h.recordRejectedLogin(c, attempt)
+
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	}
+
+	merchant, err := h.merchantRepo.GetByEmail(c.UserContext(), b.Email)
+	if err != nil {
+		// run bcrypt anyway so unknown emails take as long as wrong passwords
+		merchant = domain.Merchant{Password: dummyPasswordHash}
+	}
 
 	// check password match
 	if err = merchant.PasswordMatches(b.Password); err != nil {
+		if !merchant.ID.IsZero() {
+			attempt.Merchant = merchant.ID.Hex()
+		}
+		attempt.Reason = domain.LoginFailedPassword
+		if err := h.loginGuard.Fail(c.UserContext(), attempt); err != nil {
+			logging.FromContext(c.UserContext()).Error("cannot record failed login", "error", err)
+		}
+
 		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
 	}
 
-	// create access and refresh token
-	access, accessPayload, err := token.CreateAccessToken(b.Email, merchant.ID.Hex())
-	if err != nil {
-		return err
+	if !merchant.EmailVerified {
+		return fiber.NewError(fiber.StatusForbidden, "email not verified")
 	}
 
-	refresh, refreshPayload, err := token.CreateRefreshToken(b.Email, merchant.ID.Hex())
-	if err != nil {
-		return err
+	// with 2fa on, the password only earns a short-lived token for the second step, and
+	// the failures stay counted until that step passes too: otherwise logging in again
+	// between wrong codes would clear them
+	if merchant.TwoFactorEnabled {
+		mfaToken, mfaPayload, err := h.tokens.CreateMfaPendingToken(merchant.Email, merchant.ID.Hex())
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"mfa_required":         true,
+			"mfa_token":            mfaToken,
+			"mfa_token_expired_at": mfaPayload.ExpiredAt,
+		})
 	}
 
-	// create the session object to be stored
-	tokenHash := token.NewSession(c, refreshPayload, refresh)
+	if err := h.loginGuard.Succeed(c.UserContext(), b.Email); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot clear failed logins", "error", err)
+	}
 
-	// set the token hash struct to the session
-	key := token.SessionKey(refreshPayload.ID)
+	session, err := h.startSession(c, merchant)
+	if err != nil {
+		return err
+	}
 
-	h.store[key] = *tokenHash
+	return c.Status(fiber.StatusAccepted).JSON(session)
+}
 
-	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"access_token":             access,
-		"access_token_expired_at":  accessPayload.ExpiredAt,
-		"refresh_token":            refresh,
-		"refresh_token_expired_at": refreshPayload.ExpiredAt,
-		"merchant":                 merchant,
-		"session_id":               refreshPayload.ID,
-	})
+func (h AuthHandler) GetFailedLogins(c *fiber.Ctx) error {
+	attempts, err := h.loginGuard.GetFailedAttempts(c.Params("id"))
+	if err != nil {
+		return err
+	}
 
+	return c.JSON(attempts)
 }
 
 func (h AuthHandler) Signup(c *fiber.Ctx) error {
-	var newMerchant domain.Merchant
+	var b MerchantJSON
 
-	if err := c.BodyParser(&newMerchant); err != nil {
+	if err := c.BodyParser(&b); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
 	// validate email and confirm password
-	errors := validateMerchant(newMerchant)
+	errors := validateMerchant(b)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(errors)
 	}
 
-	if newMerchant.Password != newMerchant.ConfirmPassword {
+	if b.Password != b.ConfirmPassword {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "fail",
 			"message": fmt.Sprintf("password don't match"),
 		})
 	}
 
-	// generate secret
-	r := make([]byte, 32)
-	rand.Read(r)
-	newMerchant.Secret = hex.EncodeToString(r)
+	newMerchant := b.toMerchant()
 
-	// add merchant to database
-	m, err := h.merchantRepo.Add(&newMerchant)
+	// only key hashes are stored, so this response is the one chance to see them
+	m, keys, err := addMerchant(c.UserContext(), h.merchantRepo, h.apiKeyService, &newMerchant)
 	if err != nil {
 		return err
 	}
 
-	///////////SET TOKENS////////////
-	// create access and refresh token
-	access, accessPayload, err := token.CreateAccessToken(m.Email, m.ID.Hex())
-	if err != nil {
+	// the merchant stays inactive until the emailed link is followed
+	if err = h.sendVerificationEmail(m); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot send verification email", "merchant", m.ID.Hex(), "error", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":   "success",
+		"message":  "check your email to verify your account",
+		"merchant": m.Public(),
+		"api_keys": keys,
+	})
+}
+
+func (h AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	b := &struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
 		return err
 	}
 
-	refresh, refreshPayload, err := token.CreateRefreshToken(m.Email, m.ID.Hex())
+	payload, err := h.tokens.VerifyEmailVerificationToken(b.Token)
 	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), payload.Merchant)
+	if err != nil || merchant.Email != payload.Email {
+		return fiber.NewError(fiber.StatusBadRequest, token.ErrInvalidToken.Error())
+	}
+
+	// a link can only be used once: after the first use the merchant is already verified
+	if merchant.EmailVerified {
+		return fiber.NewError(fiber.StatusBadRequest, "email already verified")
+	}
+
+	merchant.VerifyEmail()
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
 		return err
 	}
 
-	// create the session object to be stored
-	tokenHash := token.NewSession(c, refreshPayload, refresh)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "email verified",
+	})
+}
 
-	// set the token hash struct to the session
-	key := token.SessionKey(refreshPayload.ID)
+func (h AuthHandler) ResendVerificationEmail(c *fiber.Ctx) error {
+	b := &struct {
+		Email string `json:"email"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
 
-	h.store[key] = *tokenHash
+	// always answer the same way so the endpoint can't be used to probe for accounts
+	merchant, err := h.merchantRepo.GetByEmail(c.UserContext(), b.Email)
+	if err == nil && !merchant.EmailVerified {
+		if err = h.sendVerificationEmail(merchant); err != nil {
+			logging.FromContext(c.UserContext()).Error("cannot resend verification email", "merchant", merchant.ID.Hex(), "error", err)
+		}
+	}
 
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"access_token":             access,
-		"access_token_expired_at":  accessPayload.ExpiredAt,
-		"refresh_token":            refresh,
-		"refresh_token_expired_at": refreshPayload.ExpiredAt,
-		"merchant":                 m,
-		"session_id":               refreshPayload.ID,
+		"status":  "success",
+		"message": "if the account exists and is unverified, a new link has been sent",
+	})
+}
+
+func (h AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	b := &struct {
+		Email string `json:"email"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	// always answer the same way so the endpoint can't be used to probe for accounts
+	merchant, err := h.merchantRepo.GetByEmail(c.UserContext(), b.Email)
+	if err == nil {
+		if err = h.sendPasswordResetEmail(merchant); err != nil {
+			logging.FromContext(c.UserContext()).Error("cannot send password reset email", "merchant", merchant.ID.Hex(), "error", err)
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "if the account exists, a password reset link has been sent",
+	})
+}
+
+func (h AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	b := &struct {
+		Token           string `json:"token"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	if b.Password == "" || b.Password != b.ConfirmPassword {
+		return fiber.NewError(fiber.StatusBadRequest, "password don't match")
+	}
+
+	payload, err := h.tokens.VerifyPasswordResetToken(b.Token)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), payload.Merchant)
+	if err != nil || merchant.Email != payload.Email {
+		return fiber.NewError(fiber.StatusBadRequest, token.ErrInvalidToken.Error())
+	}
+
+	// a reset link is single use: once the password changes every earlier link is stale
+	if merchant.IssuedBeforePasswordChange(payload.IssuedAt) {
+		return fiber.NewError(fiber.StatusBadRequest, token.ErrInvalidToken.Error())
+	}
+
+	if err = merchant.SetPassword(b.Password); err != nil {
+		return err
+	}
+
+	// following the link proves ownership of the inbox too
+	if !merchant.EmailVerified {
+		merchant.VerifyEmail()
+	}
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	h.store.BlockAll(merchant.Email)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "password updated, please log in again",
 	})
 }
 
+func (h AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	b := &struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	if b.Password == "" || b.Password != b.ConfirmPassword {
+		return fiber.NewError(fiber.StatusBadRequest, "password don't match")
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
+
+	if err = merchant.PasswordMatches(b.CurrentPassword); err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	}
+
+	if err = merchant.SetPassword(b.Password); err != nil {
+		return err
+	}
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	// revoke every other session, then hand the caller a fresh one
+	h.store.BlockAll(merchant.Email)
+
+	session, err := h.startSession(c, merchant)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(session)
+}
+
 func (h AuthHandler) RequireAuth(c *fiber.Ctx) error {
 
 	isAuth := c.Locals("isAuthenticated")
@@ -140,7 +361,7 @@ func (h AuthHandler) RequireAuth(c *fiber.Ctx) error {
 	accessToken := c.Get("access-token")
 
 	// verify token
-	payload, err := token.VerifyAccessToken(accessToken)
+	payload, err := h.tokens.VerifyAccessToken(accessToken)
 	switch err {
 	case token.ErrInvalidToken: //TODO
 		return err
@@ -155,15 +376,28 @@ func (h AuthHandler) RequireAuth(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("cannot access resource"))
 	}
 
+	// tokens issued before a password change or reset are revoked
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), merchantId)
+	if err != nil || merchant.IssuedBeforePasswordChange(payload.IssuedAt) {
+		return fiber.NewError(fiber.StatusUnauthorized, token.ErrInvalidToken.Error())
+	}
+
+	return c.Next()
+}
+
+// RequireBasicAuth lets through only requests that passed BasicAuth, for operator
+// endpoints that don't belong to any one merchant.
+func (h AuthHandler) RequireBasicAuth(c *fiber.Ctx) error {
+	if _, failed := c.Locals("isAuthenticated").(bool); failed {
+		return fiber.NewError(fiber.StatusUnauthorized, "basic auth required")
+	}
+
 	return c.Next()
 }
 
 func (h AuthHandler) BasicAuth() fiber.Handler {
 	return basicauth.New(basicauth.Config{
-		Users: map[string]string{
-			"kofi":  "password",
-			"admin": "123456",
-		},
+		Users: h.basicAuthUsers,
 		Unauthorized: func(c *fiber.Ctx) error {
 			c.Locals("isAuthenticated", false)
 			return c.Next()
@@ -175,7 +409,7 @@ func (h AuthHandler) RenewAccessToken(c *fiber.Ctx) error {
 	b := c.Query("refresh")
 
 	// verify the refresh token is of type refresh and has a valid payload
-	refreshPayload, err := token.VerifyRefreshToken(b)
+	refreshPayload, err := h.tokens.VerifyRefreshToken(b)
 	if err != nil {
 		return err
 	}
@@ -183,7 +417,7 @@ func (h AuthHandler) RenewAccessToken(c *fiber.Ctx) error {
 	key := token.SessionKey(refreshPayload.ID)
 
 	// get the refresh token session to run extra checks: blocked, ip, useragent, etc...
-	tokenHash := h.store[key]
+	tokenHash, _ := h.store.Get(key)
 
 	if tokenHash.IsBlocked {
 		return fiber.NewError(fiber.StatusBadRequest, "blocked session")
@@ -194,7 +428,7 @@ func (h AuthHandler) RenewAccessToken(c *fiber.Ctx) error {
 	}
 
 	// create new access token
-	access, accessPayload, err := token.CreateAccessToken(refreshPayload.Email, refreshPayload.Merchant)
+	access, accessPayload, err := h.tokens.CreateAccessToken(refreshPayload.Email, refreshPayload.Merchant)
 	if err != nil {
 		return err
 	}
@@ -205,10 +439,100 @@ func (h AuthHandler) RenewAccessToken(c *fiber.Ctx) error {
 	})
 }
 
-func NewAuthHandler(ctx context.Context, repo1 ports.MerchantRepository) *AuthHandler {
+func (h AuthHandler) recordRejectedLogin(c *fiber.Ctx, attempt domain.LoginAttempt) {
+	if merchant, err := h.merchantRepo.GetByEmail(c.UserContext(), attempt.Email); err == nil {
+		attempt.Merchant = merchant.ID.Hex()
+	}
+
+	if err := h.loginGuard.Reject(attempt); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot record rejected login", "error", err)
+	}
+}
+
+// startSession issues an access and refresh token pair for merchant and records the refresh session.
+func (h AuthHandler) startSession(c *fiber.Ctx, merchant domain.Merchant) (fiber.Map, error) {
+	// create access and refresh token
+	access, accessPayload, err := h.tokens.CreateAccessToken(merchant.Email, merchant.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, refreshPayload, err := h.tokens.CreateRefreshToken(merchant.Email, merchant.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	// create the session object to be stored
+	tokenHash := token.NewSession(c, refreshPayload, refresh, clientIp(c))
+
+	// set the token hash struct to the session
+	key := token.SessionKey(refreshPayload.ID)
+
+	h.store.Set(key, *tokenHash)
+
+	merchant.LastLoggedIn = time.Now().UTC()
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot record login time", "merchant", merchant.ID.Hex(), "error", err)
+	}
+
+	return fiber.Map{
+		"access_token":             access,
+		"access_token_expired_at":  accessPayload.ExpiredAt,
+		"refresh_token":            refresh,
+		"refresh_token_expired_at": refreshPayload.ExpiredAt,
+		"merchant":                 merchant.Public(),
+		"session_id":               refreshPayload.ID,
+	}, nil
+}
+
+func (h AuthHandler) sendVerificationEmail(merchant domain.Merchant) error {
+	verificationToken, _, err := h.tokens.CreateEmailVerificationToken(merchant.Email, merchant.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	link := h.appLink("/verify-email", verificationToken)
+
+	return h.mailer.Send(domain.Mail{
+		To:      merchant.Email,
+		Subject: "Verify your email address",
+		Message: fmt.Sprintf("Hi %s,\n\nConfirm your email address to activate your account:\n\n%s\n\nThe link expires in %s and can only be used once.\n",
+			merchant.Name, link, token.EmailVerificationTokenDuration),
+	})
+}
+
+func (h AuthHandler) sendPasswordResetEmail(merchant domain.Merchant) error {
+	resetToken, _, err := h.tokens.CreatePasswordResetToken(merchant.Email, merchant.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	link := h.appLink("/reset-password", resetToken)
+
+	return h.mailer.Send(domain.Mail{
+		To:      merchant.Email,
+		Subject: "Reset your password",
+		Message: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password on your account. If it was you, choose a new one here:\n\n%s\n\nThe link expires in %s and can only be used once. If it wasn't you, you can ignore this email.\n",
+			merchant.Name, link, token.PasswordResetTokenDuration),
+	})
+}
+
+// appLink builds a dashboard link carrying a signed token.
+func (h AuthHandler) appLink(path string, signedToken string) string {
+	return strings.TrimSuffix(h.appUrl, "/") + path + "?token=" + url.QueryEscape(signedToken)
+}
+
+func NewAuthHandler(ctx context.Context, repo1 ports.MerchantRepository, mailer ports.IMailer, loginGuard *login_guard_srv.LoginGuardService, apiKeyService *api_key_srv.ApiKeyService, tokens *token.Maker, appUrl string, basicAuthUsers map[string]string) *AuthHandler {
 	return &AuthHandler{
-		merchantRepo: repo1,
-		store:        make(map[string]token.Session),
+		merchantRepo:  repo1,
+		store:         token.NewSessionStore(),
+		mailer:        mailer,
+		loginGuard:    loginGuard,
+		apiKeyService: apiKeyService,
+		tokens:        tokens,
+
+		appUrl:         appUrl,
+		basicAuthUsers: basicAuthUsers,
 
 		ctx: ctx,
 	}
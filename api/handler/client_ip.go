@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"strings"
+)
+
+// ResolveClientIp works out the address each request came from and leaves it in
+// c.Locals("clientIp") for the login throttle, the audit log and sessions.
+//
+// X-Forwarded-For is only believed when the request comes from one of the trusted
+// proxies (addresses or cidr ranges), or through the lambda adapter, which has no
+// peer address and is always behind API Gateway. Proxies append the address they saw,
+// so the client is the right-most entry not added by another trusted proxy: anything
+// further left was sent by the client itself and can be anything.
+func ResolveClientIp(trustedProxies []string) (fiber.Handler, error) {
+	trusted := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an ip address or cidr range", proxy)
+		}
+		trusted = append(trusted, ipNet)
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, ipNet := range trusted {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *fiber.Ctx) error {
+		peer := c.Context().RemoteIP()
+		ip := ""
+		if peer != nil {
+			ip = peer.String()
+		}
+
+		if peer == nil || isTrusted(peer) {
+			forwarded := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+				if hop == nil {
+					break
+				}
+
+				ip = hop.String()
+				if !isTrusted(hop) {
+					break
+				}
+			}
+		}
+
+		c.Locals("clientIp", ip)
+		return c.Next()
+	}, nil
+}
+
+// clientIp is the address ResolveClientIp found for the request.
+func clientIp(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("clientIp").(string); ok {
+		return ip
+	}
+
+	return c.IP()
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/services/api_key_srv"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// how long a retry waits for the request it repeats to finish before getting a 409
+	idempotencyWait = 10 * time.Second
+	idempotencyPoll = 100 * time.Millisecond
+)
+
+type IdempotencyHandler struct {
+	requests ports.IdempotencyRepository
+
+	// how long a response is replayed, and how long a running request holds its key,
+	// after which a request that never finished, say the server died, gives it up
+	retention time.Duration
+	lock      time.Duration
+
+	ctx context.Context
+}
+
+func NewIdempotencyHandler(ctx context.Context, requests ports.IdempotencyRepository, retention time.Duration, lock time.Duration) *IdempotencyHandler {
+	return &IdempotencyHandler{
+		requests:  requests,
+		retention: retention,
+		lock:      lock,
+		ctx:       ctx,
+	}
+}
+
+// Idempotent runs a request sent with an Idempotency-Key once per merchant, mode and
+// key. Retries get the first response back, with an Idempotent-Replayed header,
+// instead of running again. A retry while the first is still running waits for it
+// for a while, then gets a 409. Reusing a key for a different request is a 422.
+//
+// Failed requests, errors, 5xx, panics and empty responses, aren't kept, their retries
+// run again. It goes after RequireApiKey.
+func (ih *IdempotencyHandler) Idempotent(c *fiber.Ctx) error {
+	key := c.Get(HeaderIdempotencyKey)
+	if key == "" {
+		return c.Next()
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return fiber.NewError(fiber.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+	}
+
+	apiKey, ok := apiKeyFrom(c)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, api_key_srv.ErrInvalidApiKey.Error())
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	hash.Write(c.Body())
+
+	request := domain.IdempotentRequest{
+		Merchant:    apiKey.Merchant,
+		Livemode:    apiKey.Livemode(),
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	ctx := c.UserContext()
+	giveUp := time.Now().Add(idempotencyWait)
+
+	for {
+		request.ExpiresAt = time.Now().Add(ih.lock)
+
+		stored, started, err := ih.requests.Begin(ctx, request)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case started:
+			return ih.run(c, stored)
+		case stored.RequestHash != request.RequestHash:
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		case stored.Done:
+			c.Set(HeaderIdempotentReplayed, "true")
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.Status).Send(stored.Body)
+		}
+
+		if time.Now().After(giveUp) {
+			return fiber.NewError(fiber.StatusConflict, "a request with this Idempotency-Key is in progress")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fiber.NewError(fiber.StatusConflict, "a request with this Idempotency-Key is in progress")
+		case <-time.After(idempotencyPoll):
+		}
+	}
+}
+
+// run runs the request that holds the key and keeps its response.
+func (ih *IdempotencyHandler) run(c *fiber.Ctx, request domain.IdempotentRequest) error {
+	// the request's context may be done by the time it has to let go of the key
+	ctx := context.WithoutCancel(c.UserContext())
+	logger := logging.FromContext(ctx).With("idempotency_key", request.Key)
+
+	release := func() {
+		if err := ih.requests.Release(ctx, request); err != nil {
+			logger.Error("cannot release idempotency key", "error", err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			release()
+			panic(r)
+		}
+	}()
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil || status >= fiber.StatusInternalServerError || len(c.Response().Body()) == 0 {
+		release()
+		return err
+	}
+
+	request.Status = status
+	request.ContentType = string(c.Response().Header.ContentType())
+	request.Body = append([]byte(nil), c.Response().Body()...)
+	request.ExpiresAt = time.Now().Add(ih.retention)
+
+	// the response has been made either way, its retries just run again once the
+	// lock expires
+	if err := ih.requests.Complete(ctx, &request); err != nil {
+		logger.Error("cannot keep idempotent response", "error", err)
+	}
+
+	return nil
+}
@@ -2,91 +2,153 @@ package handler
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"fraud-detect-system/domain"
 	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/services/api_key_srv"
+	"fraud-detect-system/services/notification_srv"
+	"fraud-detect-system/services/webhook_srv"
+	"fraud-detect-system/vault"
 	"github.com/go-playground/validator"
 	"github.com/gofiber/fiber/v2"
-	"math"
-	"math/rand"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
+// MerchantJSON is what a merchant signs up with. It never leaves the API.
+type MerchantJSON struct {
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required"`
+	ConfirmPassword string `json:"confirm_password" validate:"required"`
+	WebsiteUrl      string `json:"website_url" validate:"required"`
+	Theme           string `json:"theme"`
+	SiteInformation string `json:"site_information"`
+}
+
+func (b MerchantJSON) toMerchant() domain.Merchant {
+	return domain.Merchant{
+		Name:            b.Name,
+		Email:           b.Email,
+		Password:        b.Password,
+		WebsiteUrl:      b.WebsiteUrl,
+		Theme:           b.Theme,
+		SiteInformation: b.SiteInformation,
+	}
+}
+
 type MerchantHandler struct {
 	merchantRepo    ports.MerchantRepository
 	transactionRepo ports.TransactionRepository
+	analyticsRepo   ports.AnalyticsRepository
+
+	apiKeyService       *api_key_srv.ApiKeyService
+	webhookService      *webhook_srv.WebhookService
+	notificationService *notification_srv.NotificationService
+
+	cards *vault.Vault
 
 	ctx context.Context
 }
 
-func NewMerchantHandler(ctx context.Context, repo1 ports.MerchantRepository, repo2 ports.TransactionRepository) *MerchantHandler {
+// how many of the latest transactions the overview shows
+const recentTransactions = 6
+
+func NewMerchantHandler(ctx context.Context, repo1 ports.MerchantRepository, repo2 ports.TransactionRepository, repo3 ports.AnalyticsRepository, apiKeyService *api_key_srv.ApiKeyService, webhookService *webhook_srv.WebhookService, notificationService *notification_srv.NotificationService, cards *vault.Vault) *MerchantHandler {
 	return &MerchantHandler{
 		merchantRepo:    repo1,
 		transactionRepo: repo2,
+		analyticsRepo:   repo3,
+
+		apiKeyService:       apiKeyService,
+		webhookService:      webhookService,
+		notificationService: notificationService,
+
+		cards: cards,
 
 		ctx: ctx,
 	}
 }
 
 func (mh *MerchantHandler) Add(c *fiber.Ctx) error {
-	var newMerchant domain.Merchant
+	var b MerchantJSON
 
-	if err := c.BodyParser(&newMerchant); err != nil {
+	if err := c.BodyParser(&b); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
 	// validate email and confirm password
-	errors := validateMerchant(newMerchant)
+	errors := validateMerchant(b)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(errors)
 	}
 
-	if newMerchant.Password != newMerchant.ConfirmPassword {
+	if b.Password != b.ConfirmPassword {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "fail",
 			"message": fmt.Sprintf("password don't match"),
 		})
 	}
 
-	// generate secret
-	r := make([]byte, 32)
-	rand.Read(r)
-	newMerchant.Secret = hex.EncodeToString(r)
+	newMerchant := b.toMerchant()
 
-	// add merchant to database
-	m, err := mh.merchantRepo.Add(&newMerchant)
+	// only key hashes are stored, so this response is the one chance to see them
+	m, keys, err := addMerchant(c.UserContext(), mh.merchantRepo, mh.apiKeyService, &newMerchant)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(m)
+	return c.JSON(fiber.Map{
+		"merchant": m.Public(),
+		"api_keys": keys,
+	})
 }
 
+// GetMerchantTransactions lists the merchant's live transactions a page at a time,
+// see parseTransactionQuery for the filters.
 func (mh *MerchantHandler) GetMerchantTransactions(c *fiber.Ctx) error {
-	merchantId := c.Params("id")
+	return findTransactions(c, mh.transactionRepo, c.Params("id"), mh.cards)
+}
 
-	transactions, err := mh.transactionRepo.GetAllWhereMerchantIs(merchantId)
-	if err != nil {
+// LabelTransaction records the merchant's own verdict on a transaction after the fact,
+// for example once a chargeback confirms fraud, and pushes it to their webhooks.
+func (mh *MerchantHandler) LabelTransaction(c *fiber.Ctx) error {
+	b := &struct {
+		IsFraud *bool `json:"is_fraud"`
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
 		return err
 	}
 
-	return c.JSON(transactions)
-}
+	if b.IsFraud == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "is_fraud is required")
+	}
 
-func (mh *MerchantHandler) GetRelatedPayments(c *fiber.Ctx) error {
-	// get keyword (either ip-address or state or transaction ip or date, or )
-	prefix := c.Query("prefix")
 	merchantId := c.Params("id")
 
-	// store them in an array then send
-	relatedTransactions, err := mh.transactionRepo.GetRelatedPayments(merchantId, prefix)
-
+	transaction, err := mh.transactionRepo.GetById(c.UserContext(), merchantId, c.Params("transactionId"))
 	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "transaction not found")
+	}
+
+	transaction.IsFraud = *b.IsFraud
+
+	event := domain.EventFraudDismissed
+	if transaction.IsFraud {
+		event = domain.EventFraudConfirmed
+	}
+
+	if err = mh.transactionRepo.Save(c.UserContext(), &transaction); err != nil {
 		return err
 	}
 
-	return c.JSON(relatedTransactions)
+	if err = mh.webhookService.Publish(merchantId, event, true, webhook_srv.NewTransactionData(transaction)); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot queue webhooks", "transaction", transaction.ID.Hex(), "event", event, "error", err)
+	}
+
+	return c.JSON(transaction)
 }
 
 type MerchantOverview struct {
@@ -94,7 +156,9 @@ type MerchantOverview struct {
 	NotificationCount int                  `json:"notification_count"`
 	Block             int                  `json:"block"`
 	BlockRate         float64              `json:"block_rate"`
+	Volume            float64              `json:"volume"`
 	VolumeBlocked     float64              `json:"volume_blocked"`
+	AvgRisk           float64              `json:"avg_risk"`
 	RecentTransaction []domain.Transaction `json:"recent_transactions"`
 	ChartData         ChartData            `json:"chart_data"`
 }
@@ -109,133 +173,97 @@ type Dataset struct {
 	Data  []float64 `json:"data"`
 }
 
+// GetOverview sums up the merchant's live transactions over a range and charts them,
+// see parseAnalyticsQuery and chartData for the parameters. The recent transactions
+// and unread notification count don't depend on the range.
 func (mh *MerchantHandler) GetOverview(c *fiber.Ctx) error {
-	// attempted --> number of transactions
-	// block --> number of transactions where is_fraud is true
-	// block_rate --> block / attempted * 100
-	// volume_blocked --> sum total amt field of transactions where is_fraud is true
-	// chart_data --> {labels: string[], datasets}
-
+	ctx := c.UserContext()
 	merchantId := c.Params("id")
 
-	// TODO: ADD FILTER BY DURATION
-
-	transactions, err := mh.transactionRepo.GetAllWhereMerchantIs(merchantId)
-
-	merchant, err := mh.merchantRepo.GetById(merchantId)
+	if _, err := mh.merchantRepo.GetById(ctx, merchantId); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
 
+	query, err := parseAnalyticsQuery(c, merchantId, time.Now())
 	if err != nil {
-		return nil
+		return err
 	}
 
-	attempted := len(transactions)
-	block := 0
-	volumeBlocked := 0.0
-
-	for _, transaction := range transactions {
-		if transaction.IsFraud {
-			block++
-			volumeBlocked += transaction.Amt
-		}
+	buckets, err := mh.analyticsRepo.Aggregate(ctx, query)
+	if err != nil {
+		return err
 	}
+	analytics := domain.NewAnalytics(query, buckets)
 
-	blockRate := float64(block) / float64(attempted) * 100
-
-	if math.IsNaN(blockRate) {
-		blockRate = 0
+	chart, err := chartData(c, query, analytics.Series)
+	if err != nil {
+		return err
 	}
 
-	// recent transactions
-	var recentTransactions []domain.Transaction
-	limit := 6
-	count := 0
-
-	for i := len(transactions) - 1; i >= 0; i-- {
-		if count >= limit {
-			break
-		}
-		recentTransactions = append(recentTransactions, transactions[i])
-		count++
+	recent, err := mh.transactionRepo.Find(ctx, domain.TransactionQuery{Merchant: merchantId, Desc: true, Limit: recentTransactions})
+	if err != nil {
+		return err
 	}
 
-	// chart data
-	chartData := mh._getChartData(merchant)
-
-	notificationCount := mh.transactionRepo.GetNewTransactions(merchantId, merchant.LastLoggedIn)
+	unread, err := mh.notificationService.CountUnread(ctx, merchantId)
+	if err != nil {
+		return err
+	}
 
 	overview := MerchantOverview{
-		Attempted:         attempted,
-		Block:             block,
-		BlockRate:         blockRate,
-		VolumeBlocked:     volumeBlocked,
-		NotificationCount: notificationCount,
-		RecentTransaction: recentTransactions,
-		ChartData:         chartData,
+		Attempted:         analytics.Summary.Count,
+		Block:             analytics.Summary.Blocked,
+		BlockRate:         analytics.Summary.BlockRate,
+		Volume:            analytics.Summary.Volume,
+		VolumeBlocked:     analytics.Summary.VolumeBlocked,
+		AvgRisk:           analytics.Summary.AvgRisk,
+		NotificationCount: unread,
+		RecentTransaction: recent.Transactions,
+		ChartData:         chart,
 	}
 
-	fmt.Println(overview)
+	return c.Status(fiber.StatusOK).JSON(overview)
+}
 
-	merchant.LastLoggedIn = time.Now().UTC()
-	err = mh.merchantRepo.Save(&merchant)
+// GetAnalytics returns the totals and the full series behind the overview chart, for
+// charts the overview doesn't draw.
+func (mh *MerchantHandler) GetAnalytics(c *fiber.Ctx) error {
+	query, err := parseAnalyticsQuery(c, c.Params("id"), time.Now())
 	if err != nil {
 		return err
 	}
 
-	return c.Status(fiber.StatusOK).JSON(overview)
-}
+	buckets, err := mh.analyticsRepo.Aggregate(c.UserContext(), query)
+	if err != nil {
+		return err
+	}
 
-func (mh *MerchantHandler) _getChartData(merchant domain.Merchant) ChartData {
-	// Get the last 20 days starting from today
-	today := time.Now()
-	labels := make([]string, 0)
-	dataFraudulent := make([]float64, 0)
-	dataNonFraudulent := make([]float64, 0)
-
-	for i := 19; i >= 0; i-- {
-		// Calculate the date for each day
-		date := today.AddDate(0, 0, -i)
-		label := date.Format("January 2") // Format the date as "Month Day"
-		labels = append(labels, label)
-
-		// Fetch the total fraudulent and non-fraudulent transactions for that day
-		totalFraudulent := mh._fetchTotalFraudulentTransactions(date, merchant.ID.Hex())
-		totalNonFraudulent := mh._fetchTotalNonFraudulentTransactions(date, merchant.ID.Hex())
-
-		dataFraudulent = append(dataFraudulent, totalFraudulent)
-		dataNonFraudulent = append(dataNonFraudulent, totalNonFraudulent)
-	}
-
-	// Create the chart data struct
-	chartData := ChartData{
-		Labels: labels,
-		Datasets: []Dataset{
-			{
-				Label: "Valid Transactions",
-				Data:  dataNonFraudulent,
-			},
-			{
-				Label: "Fraudulent Transactions",
-				Data:  dataFraudulent,
-			},
-		},
-	}
-
-	return chartData
+	return c.JSON(domain.NewAnalytics(query, buckets))
 }
 
-func (mh *MerchantHandler) _fetchTotalNonFraudulentTransactions(date time.Time, merchant string) float64 {
-	nonFraudulentTransactions, _ := mh.transactionRepo.FetchTransactions(date, false, merchant)
+// addMerchant stores a new merchant with its default api keys. The keys are created
+// first, under the id the merchant is about to get, so there is never a merchant
+// without keys; if the merchant then can't be stored, its keys are revoked.
+func addMerchant(ctx context.Context, merchantRepo ports.MerchantRepository, apiKeyService *api_key_srv.ApiKeyService, newMerchant *domain.Merchant) (domain.Merchant, map[string]string, error) {
+	newMerchant.ID = primitive.NewObjectID()
 
-	return float64(len(nonFraudulentTransactions))
-}
+	keys, err := apiKeyService.CreateDefaults(newMerchant.ID.Hex())
+	if err != nil {
+		return domain.Merchant{}, nil, err
+	}
 
-func (mh *MerchantHandler) _fetchTotalFraudulentTransactions(date time.Time, merchant string) float64 {
-	fraudulentTransactions, _ := mh.transactionRepo.FetchTransactions(date, true, merchant)
+	m, err := merchantRepo.Add(ctx, newMerchant)
+	if err != nil {
+		if err := apiKeyService.RevokeAll(newMerchant.ID.Hex()); err != nil {
+			logging.FromContext(ctx).Error("cannot revoke keys of merchant that failed to save", "merchant", newMerchant.ID.Hex(), "error", err)
+		}
+		return domain.Merchant{}, nil, err
+	}
 
-	return float64(len(fraudulentTransactions))
+	return m, keys, nil
 }
 
-func validateMerchant(merchant domain.Merchant) []*ErrorResponse {
+func validateMerchant(merchant MerchantJSON) []*ErrorResponse {
 	var errors []*ErrorResponse
 	err := validate.Struct(merchant)
 	if err != nil {
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/services/notification_srv"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"time"
+)
+
+// how often an idle stream sends a comment, so proxies don't close it
+const streamHeartbeat = 25 * time.Second
+
+type NotificationHandler struct {
+	notificationService *notification_srv.NotificationService
+
+	ctx context.Context
+}
+
+func NewNotificationHandler(ctx context.Context, notificationService *notification_srv.NotificationService) *NotificationHandler {
+	return &NotificationHandler{
+		notificationService: notificationService,
+
+		ctx: ctx,
+	}
+}
+
+// GetNotifications lists the merchant's inbox newest first. ?unread=true leaves out
+// the read ones and ?before=<id> continues from the last one of the previous page.
+func (nh *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
+	before := c.Query("before")
+	if before != "" && !primitive.IsValidObjectID(before) {
+		return fiber.NewError(fiber.StatusBadRequest, "before must be a notification id")
+	}
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return fiber.NewError(fiber.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+
+	notifications, err := nh.notificationService.List(c.UserContext(), c.Params("id"), c.Query("unread") == "true", before, limit)
+	if err != nil {
+		return err
+	}
+
+	unread, err := nh.notificationService.CountUnread(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   notifications,
+		"unread": unread,
+	})
+}
+
+// MarkRead marks the notifications in the body's ids read, or every one when ids is
+// left out.
+func (nh *NotificationHandler) MarkRead(c *fiber.Ctx) error {
+	b := &struct {
+		Ids []string `json:"ids"`
+	}{}
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(b); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	for _, id := range b.Ids {
+		if !primitive.IsValidObjectID(id) {
+			return fiber.NewError(fiber.StatusBadRequest, "ids must be notification ids")
+		}
+	}
+
+	if err := nh.notificationService.MarkRead(c.UserContext(), c.Params("id"), b.Ids); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Stream pushes the merchant's new notifications as server-sent events until the
+// client goes away or the service is closed on shutdown. Only notifications made by
+// this process are pushed, see the route in router.
+func (nh *NotificationHandler) Stream(c *fiber.Ctx) error {
+	merchantId := c.Params("id")
+	logger := logging.FromContext(c.UserContext())
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	notifications, cancel := nh.notificationService.Subscribe(merchantId)
+
+	// the writer outlives the handler, so nothing in it may touch c
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		// tell the client the stream is open before anything happens
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case notification, ok := <-notifications:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(notification)
+				if err != nil {
+					logger.Error("cannot encode notification", "notification", notification.ID.Hex(), "error", err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", notification.ID.Hex(), notification.Type, data)
+			}
+
+			// a failed flush is the only sign the client disconnected
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
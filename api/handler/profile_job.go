@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/services/profile_srv"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+)
+
+type ProfileJobHandler struct {
+	profileService *profile_srv.ProfileService
+
+	ctx context.Context
+}
+
+func NewProfileJobHandler(ctx context.Context, profileService *profile_srv.ProfileService) *ProfileJobHandler {
+	return &ProfileJobHandler{
+		profileService: profileService,
+
+		ctx: ctx,
+	}
+}
+
+// GetJobs lists account profile jobs newest first, ?status= only those with it, and
+// how many there are of each status.
+func (pjh *ProfileJobHandler) GetJobs(c *fiber.Ctx) error {
+	status := c.Query("status")
+	switch status {
+	case "", domain.ProfileJobPending, domain.ProfileJobRunning, domain.ProfileJobRetry, domain.ProfileJobDone, domain.ProfileJobFailed:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "status must be one of pending, running, retry, done and failed")
+	}
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return fiber.NewError(fiber.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+
+	jobs, counts, err := pjh.profileService.Jobs(c.UserContext(), status, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   jobs,
+		"counts": counts,
+	})
+}
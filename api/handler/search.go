@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fraud-detect-system/domain"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// SearchResult is a page of search results. Linked is what an expanded search went
+// looking for.
+type SearchResult struct {
+	domain.TransactionPage
+	Linked *domain.Linked `json:"linked,omitempty"`
+}
+
+// Search finds the merchant's live transactions matching q, see domain.ParseSearch,
+// on top of the listing filters and paging of parseTransactionQuery.
+//
+// With expand=ip,email,card (any of them, or all) it returns instead everything that
+// shares one of those entities with the most recent matches, for example
+// q=id:<transaction>&expand=all for every transaction linked to one of them.
+func (mh *MerchantHandler) Search(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+
+	query, err := parseTransactionQuery(c, c.Params("id"), mh.cards)
+	if err != nil {
+		return err
+	}
+
+	if err = domain.ParseSearch(c.Query("q"), &query, mh.cards.Fingerprint); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	kinds, err := parseExpand(c.Query("expand"))
+	if err != nil {
+		return err
+	}
+
+	if len(kinds) == 0 {
+		page, err := mh.transactionRepo.Find(ctx, query)
+		if err != nil {
+			return findError(err)
+		}
+
+		return c.JSON(SearchResult{TransactionPage: page})
+	}
+
+	// expand from one full page of the newest matches, the cursor belongs to the
+	// expanded listing
+	seeds := query
+	seeds.Sort, seeds.Desc, seeds.Limit, seeds.After = domain.SortCreatedAt, true, domain.MaxPageSize, ""
+
+	found, err := mh.transactionRepo.Find(ctx, seeds)
+	if err != nil {
+		return findError(err)
+	}
+
+	linked := domain.LinkedTo(found.Transactions, kinds...)
+
+	page, err := mh.transactionRepo.Find(ctx, domain.TransactionQuery{
+		Merchant: query.Merchant,
+		Linked:   &linked,
+		Sort:     query.Sort,
+		Desc:     query.Desc,
+		Limit:    query.Limit,
+		After:    query.After,
+	})
+	if err != nil {
+		return findError(err)
+	}
+
+	return c.JSON(SearchResult{TransactionPage: page, Linked: &linked})
+}
+
+func parseExpand(expand string) ([]string, error) {
+	if expand == "" {
+		return nil, nil
+	}
+	if expand == "all" {
+		return []string{domain.LinkIp, domain.LinkEmail, domain.LinkCard}, nil
+	}
+
+	kinds := strings.Split(expand, ",")
+	for _, kind := range kinds {
+		switch kind {
+		case domain.LinkIp, domain.LinkEmail, domain.LinkCard:
+		default:
+			return nil, fiber.NewError(fiber.StatusBadRequest, "expand must be all or any of ip, email and card")
+		}
+	}
+
+	return kinds, nil
+}
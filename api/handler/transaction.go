@@ -2,26 +2,41 @@ package handler
 
 import (
 	"context"
-	"fmt"
+	"fraud-detect-system/cardbin"
+	"fraud-detect-system/config"
 	"fraud-detect-system/domain"
 	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/metrics"
+	"fraud-detect-system/services/alert_srv"
+	"fraud-detect-system/services/api_key_srv"
 	"fraud-detect-system/services/feature_extraction_srv"
 	"fraud-detect-system/services/fraud_detector_srv"
+	"fraud-detect-system/services/notification_srv"
+	"fraud-detect-system/services/profile_srv"
 	"fraud-detect-system/services/transaction_srv"
+	"fraud-detect-system/services/webhook_srv"
+	"fraud-detect-system/vault"
 	"github.com/go-playground/validator"
 	"github.com/gofiber/fiber/v2"
+	"math"
+	"strings"
+	"time"
 )
 
+// PaymentJSON is a payment to score. The card number goes straight to the vault and
+// the cvc isn't read at all, scoring doesn't need it. Country, where the payment comes
+// from, e.g. the billing address's, is checked against the card's issuing country.
 type PaymentJSON struct {
 	Amount      float64 `json:"amount" validate:"required,number,min=0"`
 	CardHolder  string  `json:"card_holder" validate:"required"`
 	ExpiryMonth int     `json:"expiry_month" validate:"required"`
 	ExpiryYear  int     `json:"expiry_year" validate:"required"`
-	Cvc         string  `json:"cvc" validate:"required"`
 	CreditCard  string  `json:"credit_card" validate:"required"`
 	IPAddress   string  `json:"ip_address"`
+	Country     string  `json:"country" validate:"omitempty,len=2,alpha"` // ISO 3166-1 alpha-2
 	domain.User
-	Merchant string `json:"merchant" validate:"required"`
+	Merchant string `json:"merchant"` // optional, must match the api key's merchant when sent
 }
 
 type ErrorResponse struct {
@@ -30,14 +45,30 @@ type ErrorResponse struct {
 	Value       string
 }
 
+// Partition is where a transaction is read from and written to. Live keys use the
+// real collections; test keys use a sandbox that the models' history, training
+// and the dashboard never read.
+type Partition struct {
+	TransactionRepo ports.TransactionRepository
+	AccountRepo     ports.AccountRepository
+
+	TransactionsService  transaction_srv.TransactionService
+	FraudDetectorService fraud_detector_srv.FraudDetectorService
+}
+
 type TransactionHandler struct {
-	transactionRepo ports.TransactionRepository
-	accountRepo     ports.AccountRepository
+	live    Partition
+	sandbox Partition
 
-	transactionsService  transaction_srv.TransactionService
-	fraudDetectorService fraud_detector_srv.FraudDetectorService
+	alertService        *alert_srv.AlertService
+	webhookService      *webhook_srv.WebhookService
+	notificationService *notification_srv.NotificationService
+	profileService      *profile_srv.ProfileService
 
-	mailer ports.IMailer
+	cards   *vault.Vault
+	bins    cardbin.Table
+	scoring config.Scoring
+	metrics *metrics.Metrics
 
 	ctx context.Context
 }
@@ -59,29 +90,34 @@ func validatePaymentJSON(payment PaymentJSON) []*ErrorResponse {
 	return errors
 }
 
-func NewTransactionHandler(ctx context.Context, repo1 ports.TransactionRepository, repo2 ports.AccountRepository, transactionsService transaction_srv.TransactionService, fraudDetectorService fraud_detector_srv.FraudDetectorService, mailer ports.IMailer) *TransactionHandler {
+func NewTransactionHandler(ctx context.Context, live Partition, sandbox Partition, alertService *alert_srv.AlertService, webhookService *webhook_srv.WebhookService, notificationService *notification_srv.NotificationService, profileService *profile_srv.ProfileService, cards *vault.Vault, bins cardbin.Table, scoring config.Scoring, metrics *metrics.Metrics) *TransactionHandler {
 	return &TransactionHandler{
-		transactionRepo: repo1,
-		accountRepo:     repo2,
+		live:    live,
+		sandbox: sandbox,
 
-		transactionsService:  transactionsService,
-		fraudDetectorService: fraudDetectorService,
+		alertService:        alertService,
+		webhookService:      webhookService,
+		notificationService: notificationService,
+		profileService:      profileService,
 
-		mailer: mailer,
+		cards:   cards,
+		bins:    bins,
+		scoring: scoring,
+		metrics: metrics,
 
 		ctx: ctx,
 	}
 }
 
-func (th *TransactionHandler) AddTransaction(c *fiber.Ctx) error {
+func (th *TransactionHandler) AddTransaction(c *fiber.Ctx) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println("Recovered. Error:\n", r)
+			logging.FromContext(c.UserContext()).Error("recovered from panic while scoring", "panic", r)
+			// a 500, so it isn't kept as the answer to an Idempotency-Key
+			err = fiber.NewError(fiber.StatusInternalServerError, "cannot score transaction")
 		}
 	}()
 
-	fmt.Println(c.GetReqHeaders()["allow"])
-
 	var payment PaymentJSON
 	if err := c.BodyParser(&payment); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
@@ -92,7 +128,35 @@ func (th *TransactionHandler) AddTransaction(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(errors)
 	}
 
-	account, err := th.transactionsService.GetAccountByCreditCard(payment.CreditCard)
+	key, ok := apiKeyFrom(c)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, api_key_srv.ErrInvalidApiKey.Error())
+	}
+
+	if payment.Merchant != "" && payment.Merchant != key.Merchant {
+		return fiber.NewError(fiber.StatusForbidden, "api key does not belong to merchant")
+	}
+
+	p := th.partition(key)
+	ctx := c.UserContext()
+	defer th.metrics.ObserveScoring(key.Livemode(), time.Now())
+
+	if err := vault.Validate(payment.CreditCard); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := domain.CheckExpiry(payment.ExpiryMonth, payment.ExpiryYear, time.Now()); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	card, err := th.cards.Protect(payment.CreditCard)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	info, _ := th.bins.Lookup(card.Bin)
+	card.Scheme, card.Country, card.Issuer = info.Scheme, info.Country, info.Issuer
+
+	account, err := p.TransactionsService.GetAccountByCard(ctx, card.Fingerprint)
 
 	// creates an account if it doesn't exist
 	if err != nil {
@@ -100,10 +164,10 @@ func (th *TransactionHandler) AddTransaction(c *fiber.Ctx) error {
 			// create the account
 			newAccount := domain.Account{
 				CardHolder: payment.CardHolder,
-				CardNum:    payment.CreditCard,
+				Card:       card,
 			}
 
-			newAccount, _ = th.transactionsService.CreateAccount(newAccount)
+			newAccount, _ = p.TransactionsService.CreateAccount(ctx, newAccount)
 
 			account = newAccount
 		} else {
@@ -112,103 +176,164 @@ func (th *TransactionHandler) AddTransaction(c *fiber.Ctx) error {
 	}
 
 	// add the transaction
-	//TODO: verify merchant
 
 	newTransaction := domain.Transaction{
-		Amt:        payment.Amount,
-		CreditCard: payment.CreditCard,
-		//Ip:         strings.Split(c.GetReqHeaders()["X-Forwarded-For"], ",")[0],
-		Ip:        c.GetReqHeaders()["X-Forwarded-For"],
-		UserAgent: c.GetReqHeaders()["User-Agent"],
-		//Ip:        c.IP(),
-		//UserAgent: string(c.Context().UserAgent()),
-		Merchant: payment.Merchant,
+		Amt:       payment.Amount,
+		Card:      card.WithoutNumber(),
+		Ip:        clientIp(c),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Merchant:  key.Merchant,
 		User: domain.User{
 			Email: payment.Email,
 		},
+		CardType: info.Type,
+		Country:  strings.ToUpper(payment.Country),
 	}
+	newTransaction.CountryMismatch = newTransaction.Country != "" && card.Country != "" && newTransaction.Country != card.Country
 
-	newTransaction, err = th.transactionsService.Create(newTransaction)
+	newTransaction, err = p.TransactionsService.Create(ctx, newTransaction)
 	if err != nil {
 		return err
 	}
 
-	transactions, err := th.transactionsService.GetAllValidByCreditCard(payment.CreditCard)
+	transactions, err := p.TransactionsService.GetAllValidByCard(ctx, card.Fingerprint)
 	if err != nil {
 		return err
 	}
 
-	features := th.extractFeatures(newTransaction)
-	xgbPred, err := th.fraudDetectorService.DetectXGB(features)
+	features := th.extractFeatures(ctx, p, newTransaction)
+	xgbPred, err := p.FraudDetectorService.DetectXGB(ctx, features)
 	if err != nil {
+		if key.Livemode() {
+			if err := th.notificationService.ModelDegraded(ctx, key.Merchant, err); err != nil {
+				logging.FromContext(ctx).Error("cannot notify merchant", "error", err)
+			}
+		}
 		return err
 	}
 
-	if len(transactions) < 20 {
-		if xgbPred.Probability[1] >= 0.2 {
-			newTransaction.IsFraud = true
-		}
+	// scoring only reads the account's profile, the profile jobs keep it up to date
+	if err := th.profileService.TransactionAdded(ctx, key.Livemode(), account, len(transactions)); err != nil {
+		logging.FromContext(ctx).Error("cannot queue profile job", "error", err)
+	}
 
-		newTransaction.RiskScore = xgbPred.Probability[1]
+	if len(transactions) >= th.scoring.MinHmmHistory {
+		hmmPred, err := p.FraudDetectorService.DetectHMM(ctx, &account)
+		if err == nil {
+			finalPred := p.FraudDetectorService.Ensemble(hmmPred, xgbPred)
+			newTransaction.RiskScore = th.withCardRisk(newTransaction, finalPred.Prob)
 
-		th.transactionRepo.Save(&newTransaction)
-		return c.JSON(xgbPred)
-	}
+			if finalPred.Likelihood >= th.scoring.LikelihoodThreshold || newTransaction.RiskScore >= th.scoring.DeclineThreshold || finalPred.IsHmmFraud {
+				// probably fraud
 
-	hmmPred, err := th.fraudDetectorService.DetectHMM(&account)
+				newTransaction.IsFraud = true
 
-	finalPred := th.fraudDetectorService.Ensemble(hmmPred, xgbPred)
+			}
+
+			th.decide(ctx, p, key, &newTransaction)
 
-	if finalPred.Likelihood >= 0.7 || finalPred.Prob >= 0.2 || finalPred.IsHmmFraud {
-		// probably fraud
+			return c.Status(200).JSON(finalPred)
+		}
 
+		// until the account is profiled the xgb model does on its own
+		if err != fraud_detector_srv.ErrNoProfile {
+			logging.FromContext(ctx).Error("cannot score with the hmm", "error", err)
+		}
+	}
+
+	newTransaction.RiskScore = th.withCardRisk(newTransaction, xgbPred.Probability[1])
+
+	if newTransaction.RiskScore >= th.scoring.DeclineThreshold {
 		newTransaction.IsFraud = true
+	}
+
+	th.decide(ctx, p, key, &newTransaction)
+	return c.JSON(xgbPred)
+}
 
+// withCardRisk adds the weights of the card's risk signals to the models' fraud
+// probability. The xgb model wasn't trained on them, so they are weighed here rather
+// than sent along with its features.
+func (th *TransactionHandler) withCardRisk(transaction domain.Transaction, prob float64) float64 {
+	if transaction.CardType == domain.CardTypePrepaid {
+		prob += th.scoring.PrepaidWeight
+	}
+	if transaction.CountryMismatch {
+		prob += th.scoring.CountryMismatchWeight
 	}
 
-	newTransaction.RiskScore = finalPred.Prob
+	return math.Min(prob, 1)
+}
+
+// decide settles the decision from the fraud flag and risk score, saves it and tells the
+// merchant's webhooks.
+func (th *TransactionHandler) decide(ctx context.Context, p Partition, key domain.ApiKey, transaction *domain.Transaction) {
+	logger := logging.FromContext(ctx).With("transaction", transaction.ID.Hex())
+	event := domain.EventTransactionApproved
+
+	switch {
+	case transaction.IsFraud:
+		transaction.Decision = domain.DecisionDecline
+		event = domain.EventTransactionDeclined
+	case transaction.RiskScore >= th.scoring.ReviewThreshold:
+		transaction.Decision = domain.DecisionReview
+		event = domain.EventTransactionReview
+	default:
+		transaction.Decision = domain.DecisionApprove
+	}
 
-	th.transactionRepo.Save(&newTransaction)
+	th.metrics.RecordDecision(key.Merchant, transaction.Decision, key.Livemode(), transaction.RiskScore)
+	logger.Info("transaction scored", "merchant", transaction.Merchant, "decision", transaction.Decision, "risk_score", transaction.RiskScore, "livemode", key.Livemode())
 
-	//	_ = th.mailer.Send(domain.Mail{
-	//		To:      "akporkofi11@gmail.com",
-	//		Subject: "answer me",
-	//		Message: `To: kate.doe@example.com
-	//
-	//From: john.doe@your.domain
-	//
-	//Subject: Why aren’t you using Mailtrap yet?
-	//
-	//Here’s the space for your great sales pitch`,
-	//	})
-	//if err != nil {
-	//	return err
-	//}
+	if err := p.TransactionRepo.Save(ctx, transaction); err != nil {
+		logger.Error("cannot save decision", "error", err)
+	}
 
-	return c.Status(200).JSON(th.fraudDetectorService.Ensemble(hmmPred, xgbPred))
+	if err := th.webhookService.Publish(key.Merchant, event, key.Livemode(), webhook_srv.NewTransactionData(*transaction)); err != nil {
+		logger.Error("cannot queue webhooks", "event", event, "error", err)
+	}
+
+	// test keys never email anyone
+	if key.Livemode() && transaction.Decision != domain.DecisionApprove {
+		if err := th.alertService.Notify(ctx, *transaction); err != nil {
+			logger.Error("cannot send fraud alert", "error", err)
+		}
+
+		if err := th.notificationService.TransactionFlagged(ctx, *transaction); err != nil {
+			logger.Error("cannot notify merchant", "error", err)
+		}
+	}
 }
 
-func (th *TransactionHandler) GetAll(c *fiber.Ctx) error {
-	fmt.Println("ip address")
-	fmt.Println(c.IP() + " with ctx")
-	fmt.Println(c.GetReqHeaders()["X-Forwarded-For"])
+// ListTransactions lists the api key's merchant's transactions a page at a time, from
+// the sandbox for test keys. See parseTransactionQuery for the filters.
+func (th *TransactionHandler) ListTransactions(c *fiber.Ctx) error {
+	key, ok := apiKeyFrom(c)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, api_key_srv.ErrInvalidApiKey.Error())
+	}
 
-	transactions, err := th.transactionRepo.GetAll()
-	if err != nil {
-		return fiber.NewError(0, err.Error())
+	return findTransactions(c, th.partition(key).TransactionRepo, key.Merchant, th.cards)
+}
+
+func (th *TransactionHandler) partition(key domain.ApiKey) Partition {
+	if key.Livemode() {
+		return th.live
 	}
 
-	return c.JSON(transactions)
+	return th.sandbox
 }
 
-func (th *TransactionHandler) extractFeatures(currentTransaction domain.Transaction) fraud_detector_srv.XGBFeatures {
+func (th *TransactionHandler) extractFeatures(ctx context.Context, p Partition, currentTransaction domain.Transaction) fraud_detector_srv.XGBFeatures {
+	defer th.metrics.ObserveScorer(metrics.ScorerFeatures, time.Now())
+
 	var xgb fraud_detector_srv.XGBFeatures
 
-	extractionService := feature_extraction_srv.New(th.transactionRepo)
+	extractionService := feature_extraction_srv.New(p.TransactionRepo)
 
-	xgb.Hour = extractionService.ExtractHour(currentTransaction)
-	xgb.TravelSpeed = extractionService.ExtractTravelSpeed(currentTransaction)
-	xgb.LastDayTransactionCount, xgb.LastDayFraudTransactionCount = extractionService.ExtractLast24HourCount(currentTransaction)
+	xgb.Hour = extractionService.ExtractHour(ctx, currentTransaction)
+	xgb.TravelSpeed = extractionService.ExtractTravelSpeed(ctx, currentTransaction)
+	xgb.LastDayTransactionCount, xgb.LastDayFraudTransactionCount = extractionService.ExtractLast24HourCount(ctx, currentTransaction)
 	xgb.Amount = currentTransaction.Amt
 	xgb.CategoryIndex = 0
 
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/vault"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sort parameter -> field, a leading "-" sorts descending
+var transactionSorts = map[string]string{
+	"created_at": domain.SortCreatedAt,
+	"amount":     domain.SortAmount,
+	"risk_score": domain.SortRiskScore,
+}
+
+// parseTransactionQuery reads the listing filters from the query string:
+//
+//	from, to                 RFC 3339 times or yyyy-mm-dd days, to is exclusive
+//	min_amount, max_amount   inclusive
+//	min_risk, max_risk       inclusive
+//	decision                 approve, review or decline
+//	is_fraud                 true or false
+//	card                     the last 4 digits or the whole card
+//	bin                      the first 6 digits
+//	email, ip
+//	sort                     created_at, amount or risk_score, "-" first for descending; -created_at by default
+//	limit                    page size, up to 200
+//	cursor                   next_cursor of the previous page
+func parseTransactionQuery(c *fiber.Ctx, merchant string, cards *vault.Vault) (domain.TransactionQuery, error) {
+	q := domain.TransactionQuery{
+		Merchant: merchant,
+		CardBin:  c.Query("bin"),
+		Decision: c.Query("decision"),
+		Email:    c.Query("email"),
+		Ip:       c.Query("ip"),
+		After:    c.Query("cursor"),
+	}
+
+	var errs []string
+	fail := func(param string, expected string) {
+		errs = append(errs, param+" must be "+expected)
+	}
+
+	date := func(param string, endOfDay bool) time.Time {
+		v := c.Query(param)
+		if v == "" {
+			return time.Time{}
+		}
+
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t
+		}
+
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			fail(param, "an RFC 3339 time or a yyyy-mm-dd date")
+			return time.Time{}
+		}
+
+		// a day given as the end of the range is included in it
+		if endOfDay {
+			t = t.Add(24 * time.Hour)
+		}
+
+		return t
+	}
+
+	number := func(param string) *float64 {
+		v := c.Query(param)
+		if v == "" {
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			fail(param, "a number")
+			return nil
+		}
+
+		return &f
+	}
+
+	q.From = date("from", false)
+	q.To = date("to", true)
+
+	q.MinAmount, q.MaxAmount = number("min_amount"), number("max_amount")
+	q.MinRisk, q.MaxRisk = number("min_risk"), number("max_risk")
+
+	switch q.Decision {
+	case "", domain.DecisionApprove, domain.DecisionReview, domain.DecisionDecline:
+	default:
+		fail("decision", "approve, review or decline")
+	}
+
+	if v := c.Query("is_fraud"); v != "" {
+		isFraud, err := strconv.ParseBool(v)
+		if err != nil {
+			fail("is_fraud", "true or false")
+		}
+		q.IsFraud = &isFraud
+	}
+
+	if card := c.Query("card"); len(card) == 4 {
+		q.CardLast4 = card
+	} else if card != "" {
+		q.CardFingerprint = cards.Fingerprint(card)
+	}
+
+	sort := c.Query("sort", "-created_at")
+	q.Desc = strings.HasPrefix(sort, "-")
+	if field, ok := transactionSorts[strings.TrimPrefix(sort, "-")]; ok {
+		q.Sort = field
+	} else {
+		fail("sort", "created_at, amount or risk_score")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > domain.MaxPageSize {
+			fail("limit", "between 1 and "+strconv.Itoa(domain.MaxPageSize))
+		}
+		q.Limit = limit
+	}
+
+	if len(errs) > 0 {
+		return domain.TransactionQuery{}, fiber.NewError(fiber.StatusBadRequest, strings.Join(errs, "; "))
+	}
+
+	return q, nil
+}
+
+// findTransactions answers with one page of the merchant's transactions in repo.
+func findTransactions(c *fiber.Ctx, repo ports.TransactionRepository, merchant string, cards *vault.Vault) error {
+	query, err := parseTransactionQuery(c, merchant, cards)
+	if err != nil {
+		return err
+	}
+
+	page, err := repo.Find(c.UserContext(), query)
+	if err != nil {
+		return findError(err)
+	}
+
+	return c.JSON(page)
+}
+
+// findError makes the query mistakes Find reports into bad requests.
+func findError(err error) error {
+	if errors.Is(err, domain.ErrInvalidCursor) || errors.Is(err, domain.ErrInvalidSort) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return err
+}
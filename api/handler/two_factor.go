@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"fraud-detect-system/domain"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/services/login_guard_srv"
+	"fraud-detect-system/token"
+	"github.com/gofiber/fiber/v2"
+	"math"
+	"strconv"
+	"time"
+)
+
+const totpIssuer = "Fraudis"
+
+type secondFactorJSON struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginTwoFactor exchanges the mfa pending token from Login plus a TOTP or recovery code for a full session.
+func (h AuthHandler) LoginTwoFactor(c *fiber.Ctx) error {
+	b := &struct {
+		MfaToken string `json:"mfa_token"`
+		secondFactorJSON
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	payload, err := h.tokens.VerifyMfaPendingToken(b.MfaToken)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	}
+
+	attempt := domain.LoginAttempt{
+		Merchant:  payload.Merchant,
+		Email:     payload.Email,
+		Ip:        clientIp(c),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+
+	// codes are only six digits, so they get the same throttling as passwords
+	if wait, err := h.loginGuard.Check(c.UserContext(), attempt.Email, attempt.Ip); err != nil {
+		switch err {
+		case login_guard_srv.ErrTooManyAttempts:
+			attempt.Reason = domain.LoginFailedThrottle
+		case login_guard_srv.ErrLocked:
+			attempt.Reason = domain.LoginFailedLocked
+		default:
+			return err
+		}
+		if err := h.loginGuard.Reject(attempt); err != nil {
+			logging.FromContext(c.UserContext()).Error("cannot record rejected 2fa login", "error", err)
+		}
+
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), payload.Merchant)
+	if err != nil || !merchant.TwoFactorEnabled || merchant.IssuedBeforePasswordChange(payload.IssuedAt) {
+		return fiber.NewError(fiber.StatusUnauthorized, token.ErrInvalidToken.Error())
+	}
+
+	if !verifySecondFactor(&merchant, b.secondFactorJSON) {
+		attempt.Reason = domain.LoginFailedTwoFactor
+		if err := h.loginGuard.Fail(c.UserContext(), attempt); err != nil {
+			logging.FromContext(c.UserContext()).Error("cannot record failed 2fa login", "error", err)
+		}
+
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid authentication code")
+	}
+
+	// persist the consumed step or recovery code so neither can be replayed
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	if err := h.loginGuard.Succeed(c.UserContext(), merchant.Email); err != nil {
+		logging.FromContext(c.UserContext()).Error("cannot clear failed logins", "error", err)
+	}
+
+	session, err := h.startSession(c, merchant)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(session)
+}
+
+// SetupTwoFactor starts enrollment: the secret is held as pending until a code from it is confirmed.
+func (h AuthHandler) SetupTwoFactor(c *fiber.Ctx) error {
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
+
+	if merchant.TwoFactorEnabled {
+		return fiber.NewError(fiber.StatusBadRequest, "two factor authentication already enabled")
+	}
+
+	secret, err := token.GenerateTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	merchant.PendingTwoFactorSecret = secret
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"secret":       secret,
+		"otpauth_uri":  token.TOTPProvisioningURI(totpIssuer, merchant.Email, secret),
+		"digits":       token.TOTPDigits,
+		"period":       int(token.TOTPPeriod.Seconds()),
+		"instructions": "scan the otpauth uri as a QR code, then confirm with a code from your app",
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a code from the pending secret and returns the recovery codes, once.
+func (h AuthHandler) EnableTwoFactor(c *fiber.Ctx) error {
+	b := &secondFactorJSON{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
+
+	if merchant.PendingTwoFactorSecret == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "two factor setup not started")
+	}
+
+	step, ok := token.ValidateTOTP(merchant.PendingTwoFactorSecret, b.Code, time.Now())
+	if !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid authentication code")
+	}
+
+	codes, hashes, err := token.GenerateRecoveryCodes(token.RecoveryCodeCount)
+	if err != nil {
+		return err
+	}
+
+	merchant.TwoFactorEnabled = true
+	merchant.TwoFactorSecret = merchant.PendingTwoFactorSecret
+	merchant.PendingTwoFactorSecret = ""
+	merchant.TwoFactorLastStep = step
+	merchant.RecoveryCodes = hashes
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":         "success",
+		"recovery_codes": codes,
+	})
+}
+
+func (h AuthHandler) DisableTwoFactor(c *fiber.Ctx) error {
+	b := &struct {
+		Password string `json:"password"`
+		secondFactorJSON
+	}{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
+
+	if !merchant.TwoFactorEnabled {
+		return fiber.NewError(fiber.StatusBadRequest, "two factor authentication not enabled")
+	}
+
+	if err = merchant.PasswordMatches(b.Password); err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	}
+
+	if !verifySecondFactor(&merchant, b.secondFactorJSON) {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid authentication code")
+	}
+
+	merchant.DisableTwoFactor()
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+// RegenerateRecoveryCodes replaces every unused recovery code with a fresh set.
+func (h AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	b := &secondFactorJSON{}
+
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	merchant, err := h.merchantRepo.GetById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "merchant not found")
+	}
+
+	if !merchant.TwoFactorEnabled {
+		return fiber.NewError(fiber.StatusBadRequest, "two factor authentication not enabled")
+	}
+
+	// a recovery code can't be used to mint new ones
+	if !verifySecondFactor(&merchant, secondFactorJSON{Code: b.Code}) {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid authentication code")
+	}
+
+	codes, hashes, err := token.GenerateRecoveryCodes(token.RecoveryCodeCount)
+	if err != nil {
+		return err
+	}
+
+	merchant.RecoveryCodes = hashes
+
+	if err = h.merchantRepo.Save(c.UserContext(), &merchant); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":         "success",
+		"recovery_codes": codes,
+	})
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last one used, or an unused
+// recovery code. Either way the merchant is updated and must be saved.
+func verifySecondFactor(merchant *domain.Merchant, b secondFactorJSON) bool {
+	if b.Code != "" {
+		step, ok := token.ValidateTOTP(merchant.TwoFactorSecret, b.Code, time.Now())
+		if !ok || step <= merchant.TwoFactorLastStep {
+			return false
+		}
+
+		merchant.TwoFactorLastStep = step
+		return true
+	}
+
+	if b.RecoveryCode != "" {
+		return merchant.UseRecoveryCode(token.HashRecoveryCode(b.RecoveryCode))
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"fraud-detect-system/services/webhook_srv"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookHandler struct {
+	webhookService *webhook_srv.WebhookService
+
+	ctx context.Context
+}
+
+func NewWebhookHandler(ctx context.Context, webhookService *webhook_srv.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+
+		ctx: ctx,
+	}
+}
+
+type WebhookEndpointJSON struct {
+	Url         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	Disabled    bool     `json:"disabled"`
+}
+
+func (wh *WebhookHandler) GetEndpoints(c *fiber.Ctx) error {
+	endpoints, err := wh.webhookService.ListEndpoints(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(endpoints)
+}
+
+func (wh *WebhookHandler) CreateEndpoint(c *fiber.Ctx) error {
+	var b WebhookEndpointJSON
+
+	if err := c.BodyParser(&b); err != nil {
+		return err
+	}
+
+	endpoint, secret, err := wh.webhookService.CreateEndpoint(c.Params("id"), b.Url, b.Description, b.Events)
+	if err != nil {
+		return webhookError(err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"endpoint": endpoint,
+		"secret":   secret,
+	})
+}
+
+func (wh *WebhookHandler) UpdateEndpoint(c *fiber.Ctx) error {
+	var b WebhookEndpointJSON
+
+	if err := c.BodyParser(&b); err != nil {
+		return err
+	}
+
+	endpoint, err := wh.webhookService.UpdateEndpoint(c.Params("id"), c.Params("endpointId"), b.Url, b.Description, b.Events, b.Disabled)
+	if err != nil {
+		return webhookError(err)
+	}
+
+	return c.JSON(endpoint)
+}
+
+func (wh *WebhookHandler) DeleteEndpoint(c *fiber.Ctx) error {
+	if err := wh.webhookService.DeleteEndpoint(c.Params("id"), c.Params("endpointId")); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "webhook endpoint not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (wh *WebhookHandler) GetDeliveries(c *fiber.Ctx) error {
+	deliveries, err := wh.webhookService.ListDeliveries(c.Params("id"), c.Params("endpointId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(deliveries)
+}
+
+func (wh *WebhookHandler) Redeliver(c *fiber.Ctx) error {
+	delivery, err := wh.webhookService.Redeliver(c.Params("id"), c.Params("deliveryId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "webhook delivery not found")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+func webhookError(err error) error {
+	switch err {
+	case webhook_srv.ErrInvalidUrl, webhook_srv.ErrInsecureUrl, webhook_srv.ErrPrivateDestination,
+		webhook_srv.ErrInvalidEvent, webhook_srv.ErrNoEvents:
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return fiber.NewError(fiber.StatusNotFound, "webhook endpoint not found")
+}
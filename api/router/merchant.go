@@ -1,34 +1,82 @@
 package router
 
 import (
-	"context"
 	"fraud-detect-system/api/handler"
-	"fraud-detect-system/storage"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
-func NewMerchantRouter(ctx context.Context, router fiber.Router) {
-	merchantRepo := storage.NewMerchantStorage(ctx, "merchants")
-	transactionRepo := storage.NewTransactionStorage(ctx, "transactions")
+// Handlers are built once by the app and shared by every router.
+type Handlers struct {
+	Auth         *handler.AuthHandler
+	Merchant     *handler.MerchantHandler
+	Transaction  *handler.TransactionHandler
+	ApiKey       *handler.ApiKeyHandler
+	Webhook      *handler.WebhookHandler
+	Alert        *handler.AlertHandler
+	Notification *handler.NotificationHandler
+	Idempotency  *handler.IdempotencyHandler
+	ProfileJob   *handler.ProfileJobHandler
+}
 
-	mh := handler.NewMerchantHandler(ctx, merchantRepo, transactionRepo)
-	ah := handler.NewAuthHandler(ctx, merchantRepo)
+func NewMerchantRouter(router fiber.Router, h Handlers) {
+	mh, ah, akh, wh, alh, nh, pjh := h.Merchant, h.Auth, h.ApiKey, h.Webhook, h.Alert, h.Notification, h.ProfileJob
 
 	router.Use(cors.New())
 
 	authRoute := router.Group("/")
 
 	authRoute.Post("/login", ah.Login)
+	authRoute.Post("/login/2fa", ah.LoginTwoFactor)
 
 	authRoute.Get("/renew-access-token", ah.RenewAccessToken)
 	authRoute.Post("/signup", ah.Signup)
+	authRoute.Post("/verify-email", ah.VerifyEmail)
+	authRoute.Post("/verify-email/resend", ah.ResendVerificationEmail)
+	authRoute.Post("/forgot-password", ah.ForgotPassword)
+	authRoute.Post("/reset-password", ah.ResetPassword)
 
 	router.Post("/merchants", mh.Add)
 
 	router.Get("/merchants/:id/transactions", ah.BasicAuth(), ah.RequireAuth, mh.GetMerchantTransactions)
 
+	router.Put("/merchants/:id/transactions/:transactionId/label", ah.BasicAuth(), ah.RequireAuth, mh.LabelTransaction)
+
 	router.Get("/merchants/:id/overview", ah.BasicAuth(), ah.RequireAuth, mh.GetOverview)
+	router.Get("/merchants/:id/analytics", ah.BasicAuth(), ah.RequireAuth, mh.GetAnalytics)
+
+	router.Get("/merchants/:id/search", ah.BasicAuth(), ah.RequireAuth, mh.Search)
+
+	router.Patch("/merchants/:id/password", ah.BasicAuth(), ah.RequireAuth, ah.ChangePassword)
+
+	router.Get("/merchants/:id/login-attempts", ah.BasicAuth(), ah.RequireAuth, ah.GetFailedLogins)
+
+	router.Post("/merchants/:id/2fa/setup", ah.BasicAuth(), ah.RequireAuth, ah.SetupTwoFactor)
+	router.Post("/merchants/:id/2fa/enable", ah.BasicAuth(), ah.RequireAuth, ah.EnableTwoFactor)
+	router.Post("/merchants/:id/2fa/disable", ah.BasicAuth(), ah.RequireAuth, ah.DisableTwoFactor)
+	router.Post("/merchants/:id/2fa/recovery-codes", ah.BasicAuth(), ah.RequireAuth, ah.RegenerateRecoveryCodes)
+
+	router.Get("/merchants/:id/keys", ah.BasicAuth(), ah.RequireAuth, akh.GetKeys)
+	router.Post("/merchants/:id/keys", ah.BasicAuth(), ah.RequireAuth, akh.CreateKey)
+	router.Post("/merchants/:id/keys/:keyId/rotate", ah.BasicAuth(), ah.RequireAuth, akh.RotateKey)
+	router.Delete("/merchants/:id/keys/:keyId", ah.BasicAuth(), ah.RequireAuth, akh.RevokeKey)
+
+	router.Get("/merchants/:id/webhooks", ah.BasicAuth(), ah.RequireAuth, wh.GetEndpoints)
+	router.Post("/merchants/:id/webhooks", ah.BasicAuth(), ah.RequireAuth, wh.CreateEndpoint)
+	router.Put("/merchants/:id/webhooks/:endpointId", ah.BasicAuth(), ah.RequireAuth, wh.UpdateEndpoint)
+	router.Delete("/merchants/:id/webhooks/:endpointId", ah.BasicAuth(), ah.RequireAuth, wh.DeleteEndpoint)
+	router.Get("/merchants/:id/webhooks/:endpointId/deliveries", ah.BasicAuth(), ah.RequireAuth, wh.GetDeliveries)
+	router.Post("/merchants/:id/webhook-deliveries/:deliveryId/redeliver", ah.BasicAuth(), ah.RequireAuth, wh.Redeliver)
+
+	router.Get("/merchants/:id/alerts", ah.BasicAuth(), ah.RequireAuth, alh.GetSettings)
+	router.Put("/merchants/:id/alerts", ah.BasicAuth(), ah.RequireAuth, alh.UpdateSettings)
+
+	router.Get("/merchants/:id/notifications", ah.BasicAuth(), ah.RequireAuth, nh.GetNotifications)
+	router.Post("/merchants/:id/notifications/read", ah.BasicAuth(), ah.RequireAuth, nh.MarkRead)
+	// the stream only hears about notifications made by the process serving it, so it
+	// needs the long-running server (main.go) run as a single instance. Behind Lambda,
+	// or with several servers, dashboards have to poll the list above instead.
+	router.Get("/merchants/:id/notifications/stream", ah.BasicAuth(), ah.RequireAuth, nh.Stream)
 
-	router.Get("/merchants/:id/related", ah.BasicAuth(), ah.RequireAuth, mh.GetRelatedPayments)
+	router.Get("/profile-jobs", ah.BasicAuth(), ah.RequireBasicAuth, pjh.GetJobs)
 }
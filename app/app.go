@@ -0,0 +1,307 @@
+package app
+
+import (
+	"context"
+	"fraud-detect-system/api/handler"
+	"fraud-detect-system/api/router"
+	"fraud-detect-system/cardbin"
+	"fraud-detect-system/config"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/logging"
+	"fraud-detect-system/mailer"
+	"fraud-detect-system/metrics"
+	"fraud-detect-system/services/alert_srv"
+	"fraud-detect-system/services/api_key_srv"
+	"fraud-detect-system/services/fraud_detector_srv"
+	"fraud-detect-system/services/login_guard_srv"
+	"fraud-detect-system/services/notification_srv"
+	"fraud-detect-system/services/profile_srv"
+	"fraud-detect-system/services/transaction_srv"
+	"fraud-detect-system/services/webhook_srv"
+	"fraud-detect-system/token"
+	"fraud-detect-system/tracing"
+	"fraud-detect-system/vault"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// App is the whole api, wired once. The server, dev and Lambda entrypoints all run
+// the same App; only how it's served differs.
+type App struct {
+	Config  *config.Config
+	Server  *fiber.App
+	Logger  *slog.Logger
+	Metrics *metrics.Metrics
+
+	Mailer              *mailer.Outbox
+	WebhookService      *webhook_srv.WebhookService
+	AlertService        *alert_srv.AlertService
+	NotificationService *notification_srv.NotificationService
+	ProfileService      *profile_srv.ProfileService
+
+	tracing tracing.Provider
+
+	checks       []readinessCheck
+	shuttingDown atomic.Bool
+	disconnect   func(ctx context.Context) error
+
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
+}
+
+// New builds the app on top of repos. Mail is queued in repos.Outbox and handed to
+// transport by the outbox worker that Start runs.
+func New(ctx context.Context, cfg *config.Config, repos Repositories, transport ports.IMailer) (*App, error) {
+	tokens, err := token.NewMaker(cfg.Token.SymmetricKey, cfg.Token.AccessTokenDuration, cfg.Token.RefreshTokenDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	cards, err := vault.New(cfg.Cards.FingerprintKey, cfg.Cards.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bins, err := loadBinTable(cfg.Cards.BinTable)
+	if err != nil {
+		return nil, err
+	}
+
+	resolveClientIp, err := handler.ResolveClientIp(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	m := metrics.New()
+
+	outbox := mailer.NewOutbox(repos.Outbox, transport, logger)
+
+	loginGuardService := login_guard_srv.New(repos.LoginAttempts, repos.LoginCounters)
+	apiKeyService := api_key_srv.New(repos.ApiKeys, logger)
+	notificationService := notification_srv.New(repos.Notifications, logger)
+	webhookService := webhook_srv.New(repos.WebhookEndpoints, repos.WebhookDeliveries, notificationService, cfg.DevMode, logger)
+	alertService := alert_srv.New(repos.Alerts, repos.Merchants, outbox, logger)
+
+	live := newPartition(cfg, logger, m, repos.Live)
+	sandbox := newPartition(cfg, logger, m, repos.Sandbox)
+
+	profileService := profile_srv.New(repos.ProfileJobs,
+		profile_srv.Partition{Accounts: repos.Live.Accounts, Profiler: &live.FraudDetectorService},
+		profile_srv.Partition{Accounts: repos.Sandbox.Accounts, Profiler: &sandbox.FraudDetectorService},
+		cfg.Profiles, cfg.Scoring.MinHmmHistory, logger, m)
+
+	handlers := router.Handlers{
+		Auth:        handler.NewAuthHandler(ctx, repos.Merchants, outbox, loginGuardService, apiKeyService, tokens, cfg.AppUrl, cfg.BasicAuthUsers),
+		Merchant:    handler.NewMerchantHandler(ctx, repos.Merchants, repos.Live.Transactions, repos.Live.Analytics, apiKeyService, webhookService, notificationService, cards),
+		Transaction: handler.NewTransactionHandler(ctx, live, sandbox, alertService, webhookService, notificationService, profileService, cards, bins, cfg.Scoring, m),
+		ApiKey:      handler.NewApiKeyHandler(ctx, apiKeyService),
+		Webhook:     handler.NewWebhookHandler(ctx, webhookService),
+		Alert:       handler.NewAlertHandler(ctx, alertService),
+
+		Notification: handler.NewNotificationHandler(ctx, notificationService),
+		Idempotency:  handler.NewIdempotencyHandler(ctx, repos.Idempotency, cfg.IdempotencyRetention, cfg.RequestTimeout),
+		ProfileJob:   handler.NewProfileJobHandler(ctx, profileService),
+	}
+
+	a := &App{
+		Config:  cfg,
+		Server:  fiber.New(fiber.Config{DisableStartupMessage: !cfg.DevMode}),
+		Logger:  logger,
+		Metrics: m,
+
+		Mailer:              outbox,
+		WebhookService:      webhookService,
+		AlertService:        alertService,
+		NotificationService: notificationService,
+		ProfileService:      profileService,
+	}
+
+	// both partitions score against the same ml server
+	a.AddReadinessCheck("ml_server", live.FraudDetectorService.Ready)
+
+	a.Server.Use(tracing.Middleware)
+	a.Server.Use(resolveClientIp)
+	a.Server.Use(logging.RequestID(logger))
+	a.Server.Use(logging.AccessLog)
+	a.Server.Use(m.Middleware)
+	a.Server.Use(deadline(cfg.RequestTimeout))
+
+	a.Server.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("hello world")
+	})
+	a.Server.Get("/healthz", a.healthz)
+	a.Server.Get("/readyz", a.readyz)
+	// per-merchant decision counts are nobody else's business
+	a.Server.Get("/metrics", handlers.Auth.BasicAuth(), handlers.Auth.RequireBasicAuth, m.Handler())
+
+	api := a.Server.Group("/v1", compress.New())
+
+	router.NewTransactionRouter(api, handlers)
+	router.NewMerchantRouter(api, handlers)
+
+	return a, nil
+}
+
+// Bootstrap connects to Mongo and builds the app against it, sending mail over SMTP.
+func Bootstrap(ctx context.Context, cfg *config.Config) (*App, error) {
+	if err := Connect(cfg); err != nil {
+		return nil, err
+	}
+
+	smtp := mailer.New(&mailer.Config{
+		Host:     cfg.SMTP.Host,
+		Port:     cfg.SMTP.Port,
+		Username: cfg.SMTP.Username,
+		Password: cfg.SMTP.Password,
+		From:     cfg.SMTP.From,
+	})
+
+	tracingProvider, err := tracing.Setup(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := New(ctx, cfg, NewMongoRepositories(ctx), smtp)
+	if err != nil {
+		return nil, err
+	}
+	a.tracing = tracingProvider
+
+	// anything still using the log package goes through the same redacting handler
+	slog.SetDefault(a.Logger)
+
+	if cfg.SMTP.Host == "" {
+		a.Logger.Warn("SMTP_HOST is not set, mail will wait in the outbox")
+	}
+
+	_, client, _, err := mgm.DefaultConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	a.AddReadinessCheck("mongo", func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	})
+	a.disconnect = client.Disconnect
+
+	return a, nil
+}
+
+// Connect sets mgm's default connection, which every Mongo storage uses.
+func Connect(cfg *config.Config) error {
+	return mgm.SetDefaultConfig(nil, cfg.Mongo.Database, options.Client().ApplyURI(cfg.Mongo.Url))
+}
+
+// Start runs the background workers: webhook delivery, the mail outbox, hourly
+// fraud alert digests and account profile jobs. They stop when ctx is done or on Shutdown.
+func (a *App) Start(ctx context.Context) {
+	ctx, a.stopWorkers = context.WithCancel(ctx)
+
+	for _, run := range []func(context.Context){a.WebhookService.Run, a.Mailer.Run, a.AlertService.Run, a.ProfileService.Run} {
+		a.workers.Add(1)
+		go func(run func(context.Context)) {
+			defer a.workers.Done()
+			run(ctx)
+		}(run)
+	}
+}
+
+func (a *App) Listen() error {
+	return a.Server.Listen("0.0.0.0:" + a.Config.Port)
+}
+
+// Serve listens until SIGINT or SIGTERM, then shuts down gracefully.
+func (a *App) Serve() error {
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- a.Listen()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-listenErr:
+		return err
+	case sig := <-signals:
+		a.Logger.Info("shutting down", "signal", sig.String())
+	}
+
+	return a.Shutdown(a.Config.ShutdownTimeout)
+}
+
+// Shutdown ends notification streams, stops taking new connections and waits up to
+// timeout for in-flight requests, scoring included, to finish. It then stops the
+// workers, sends a last batch of the webhooks and mail that are due, flushes traces and
+// disconnects from Mongo.
+func (a *App) Shutdown(timeout time.Duration) error {
+	a.shuttingDown.Store(true)
+
+	// notification streams never finish on their own
+	a.NotificationService.Close()
+
+	err := a.Server.ShutdownWithTimeout(timeout)
+	if err != nil {
+		a.Logger.Warn("requests still in flight after shutdown timeout", "timeout", timeout.String(), "error", err)
+	}
+
+	if a.stopWorkers != nil {
+		a.stopWorkers()
+		a.workers.Wait()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	a.flushQueues(ctx)
+
+	if a.tracing != nil {
+		if err := a.tracing.Shutdown(ctx); err != nil {
+			a.Logger.Warn("cannot flush traces", "error", err)
+		}
+	}
+
+	if a.disconnect != nil {
+		if err := a.disconnect(ctx); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func newPartition(cfg *config.Config, logger *slog.Logger, m *metrics.Metrics, p Partition) handler.Partition {
+	return handler.Partition{
+		TransactionRepo:      p.Transactions,
+		AccountRepo:          p.Accounts,
+		TransactionsService:  *transaction_srv.New(p.Accounts, p.Transactions),
+		FraudDetectorService: *fraud_detector_srv.New(p.Accounts, p.Transactions, cfg.MLServer, logger, m),
+	}
+}
+
+// loadBinTable reads the bin table at path, or is the built in one if there's none.
+func loadBinTable(path string) (cardbin.Table, error) {
+	if path == "" {
+		return cardbin.Default, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return cardbin.Load(f)
+}
@@ -0,0 +1,307 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/mailer"
+	"fraud-detect-system/token"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outboxMail keeps what was queued instead of sending it
+type outboxMail struct {
+	emptyOutbox
+
+	mu    sync.Mutex
+	mails []domain.Mail
+}
+
+func (o *outboxMail) Add(mail domain.OutboxMail) (domain.OutboxMail, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.mails = append(o.mails, mail.Mail)
+	return mail, nil
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastToken is the token in the link of the last mail queued with subject.
+func (o *outboxMail) lastToken(t *testing.T, subject string) string {
+	t.Helper()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := len(o.mails) - 1; i >= 0; i-- {
+		if o.mails[i].Subject != subject {
+			continue
+		}
+
+		m := linkToken.FindStringSubmatch(o.mails[i].Message)
+		require.NotNil(t, m, "no link in %q", o.mails[i].Message)
+
+		token, err := url.QueryUnescape(m[1])
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Fatalf("no mail with subject %q", subject)
+	return ""
+}
+
+type loginAttempts struct {
+	mu       sync.Mutex
+	attempts []domain.LoginAttempt
+}
+
+func (r *loginAttempts) Add(attempt domain.LoginAttempt) (domain.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts = append(r.attempts, attempt)
+	return attempt, nil
+}
+
+func (r *loginAttempts) GetAllWhereMerchantIs(merchant string) ([]domain.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var attempts []domain.LoginAttempt
+	for _, attempt := range r.attempts {
+		if attempt.Merchant == merchant {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+var _ ports.LoginAttemptRepository = (*loginAttempts)(nil)
+
+func newAuthTestApp(t *testing.T) (*App, *outboxMail) {
+	mail := &outboxMail{}
+
+	repos := testRepositories()
+	repos.Outbox = mail
+	repos.LoginAttempts = &loginAttempts{}
+
+	return newTestAppWith(t, "", repos), mail
+}
+
+func request(t *testing.T, a *App, method string, path string, body interface{}, header map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+
+	var req *http.Request
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req = httptest.NewRequest(method, path, strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&decoded)
+
+	return res.StatusCode, decoded
+}
+
+func postJSON(t *testing.T, a *App, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	return request(t, a, http.MethodPost, path, body, nil)
+}
+
+func login(t *testing.T, a *App, email string, password string) (int, map[string]interface{}) {
+	t.Helper()
+	return postJSON(t, a, "/v1/login", map[string]string{"email": email, "password": password})
+}
+
+// signup creates a merchant and follows the verification link.
+func signup(t *testing.T, a *App, mail *outboxMail, email string) {
+	t.Helper()
+
+	status, body := postJSON(t, a, "/v1/signup", map[string]string{
+		"name": "Shop", "email": email, "password": "hunter22", "confirm_password": "hunter22", "website_url": "https://shop.example",
+	})
+	require.Equal(t, http.StatusCreated, status, body)
+
+	status, body = postJSON(t, a, "/v1/verify-email", map[string]string{"token": mail.lastToken(t, "Verify your email address")})
+	require.Equal(t, http.StatusOK, status, body)
+}
+
+func TestSignupNeedsEmailVerification(t *testing.T) {
+	a, mail := newAuthTestApp(t)
+
+	status, body := postJSON(t, a, "/v1/signup", map[string]string{
+		"name": "Shop", "email": "owner@shop.example", "password": "hunter22", "confirm_password": "hunter22", "website_url": "https://shop.example",
+	})
+	require.Equal(t, http.StatusCreated, status, body)
+	assert.Equal(t, false, body["merchant"].(map[string]interface{})["email_verified"])
+
+	status, _ = login(t, a, "owner@shop.example", "hunter22")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	verification := mail.lastToken(t, "Verify your email address")
+
+	status, _ = postJSON(t, a, "/v1/verify-email", map[string]string{"token": "not a token"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = postJSON(t, a, "/v1/verify-email", map[string]string{"token": verification})
+	require.Equal(t, http.StatusOK, status)
+
+	// links are single use
+	status, _ = postJSON(t, a, "/v1/verify-email", map[string]string{"token": verification})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body = login(t, a, "owner@shop.example", "hunter22")
+	require.Equal(t, http.StatusAccepted, status, body)
+	assert.NotEmpty(t, body["access_token"])
+}
+
+func TestResetPasswordIsSingleUseAndRevokesSessions(t *testing.T) {
+	a, mail := newAuthTestApp(t)
+	signup(t, a, mail, "owner@shop.example")
+
+	status, session := login(t, a, "owner@shop.example", "hunter22")
+	require.Equal(t, http.StatusAccepted, status)
+	merchant := session["merchant"].(map[string]interface{})["id"].(string)
+	attemptsPath := "/v1/merchants/" + merchant + "/login-attempts"
+
+	status, _ = request(t, a, http.MethodGet, attemptsPath, nil, map[string]string{"access-token": session["access_token"].(string)})
+	require.Equal(t, http.StatusOK, status)
+
+	// unknown emails get the same answer and no mail
+	status, _ = postJSON(t, a, "/v1/forgot-password", map[string]string{"email": "nobody@shop.example"})
+	assert.Equal(t, http.StatusAccepted, status)
+	status, _ = postJSON(t, a, "/v1/forgot-password", map[string]string{"email": "owner@shop.example"})
+	require.Equal(t, http.StatusAccepted, status)
+	reset := mail.lastToken(t, "Reset your password")
+
+	// stored times are cut to milliseconds, so the change has to land in a later one
+	// than the tokens it revokes
+	time.Sleep(2 * time.Millisecond)
+
+	status, _ = postJSON(t, a, "/v1/reset-password", map[string]string{"token": reset, "password": "new password", "confirm_password": "different"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := postJSON(t, a, "/v1/reset-password", map[string]string{"token": reset, "password": "new password", "confirm_password": "new password"})
+	require.Equal(t, http.StatusOK, status, body)
+
+	status, _ = postJSON(t, a, "/v1/reset-password", map[string]string{"token": reset, "password": "another one", "confirm_password": "another one"})
+	assert.Equal(t, http.StatusBadRequest, status, "a reset link works once")
+
+	// the session from before the reset is dead, access and refresh token alike
+	status, _ = request(t, a, http.MethodGet, attemptsPath, nil, map[string]string{"access-token": session["access_token"].(string)})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = request(t, a, http.MethodGet, "/v1/renew-access-token?refresh="+url.QueryEscape(session["refresh_token"].(string)), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = login(t, a, "owner@shop.example", "hunter22")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, fresh := login(t, a, "owner@shop.example", "new password")
+	require.Equal(t, http.StatusAccepted, status)
+	status, _ = request(t, a, http.MethodGet, attemptsPath, nil, map[string]string{"access-token": fresh["access_token"].(string)})
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	a, mail := newAuthTestApp(t)
+	signup(t, a, mail, "owner@shop.example")
+
+	_, other := login(t, a, "owner@shop.example", "hunter22")
+	_, current := login(t, a, "owner@shop.example", "hunter22")
+	merchant := current["merchant"].(map[string]interface{})["id"].(string)
+
+	// see TestResetPasswordIsSingleUseAndRevokesSessions
+	time.Sleep(2 * time.Millisecond)
+
+	status, changed := request(t, a, http.MethodPatch, "/v1/merchants/"+merchant+"/password",
+		map[string]string{"current_password": "hunter22", "password": "new password", "confirm_password": "new password"},
+		map[string]string{"access-token": current["access_token"].(string)})
+	require.Equal(t, http.StatusOK, status, changed)
+
+	attemptsPath := "/v1/merchants/" + merchant + "/login-attempts"
+	status, _ = request(t, a, http.MethodGet, attemptsPath, nil, map[string]string{"access-token": other["access_token"].(string)})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = request(t, a, http.MethodGet, "/v1/renew-access-token?refresh="+url.QueryEscape(other["refresh_token"].(string)), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// the caller carries on with the session it was handed
+	status, _ = request(t, a, http.MethodGet, attemptsPath, nil, map[string]string{"access-token": changed["access_token"].(string)})
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestPasswordLoginsDontClearFailedSecondFactors(t *testing.T) {
+	mail := &outboxMail{}
+	attempts := &loginAttempts{}
+
+	repos := testRepositories()
+	repos.Outbox, repos.LoginAttempts = mail, attempts
+
+	// every attempt comes from a new address, so only the per-email count stops them
+	cfg := testConfig("")
+	cfg.TrustedProxies = []string{"0.0.0.0"}
+	a, err := New(context.TODO(), cfg, repos, mailer.NewInMemory())
+	require.NoError(t, err)
+
+	ip := 0
+	from := func() map[string]string {
+		ip++
+		return map[string]string{fiber.HeaderXForwardedFor: fmt.Sprintf("203.0.113.%d", ip)}
+	}
+
+	signup(t, a, mail, "owner@shop.example")
+
+	merchant, err := repos.Merchants.GetByEmail(context.Background(), "owner@shop.example")
+	require.NoError(t, err)
+	merchant.TwoFactorEnabled, merchant.TwoFactorSecret = true, "JBSWY3DPEHPK3PXP"
+	require.NoError(t, repos.Merchants.Save(context.Background(), &merchant))
+
+	credentials := map[string]string{"email": "owner@shop.example", "password": "hunter22"}
+
+	// the free attempts go on wrong codes, each after a fresh password login
+	for i := 0; i < 3; i++ {
+		status, body := request(t, a, http.MethodPost, "/v1/login", credentials, from())
+		require.Equal(t, http.StatusAccepted, status, body)
+		require.Equal(t, true, body["mfa_required"])
+
+		status, _ = request(t, a, http.MethodPost, "/v1/login/2fa", map[string]string{"mfa_token": body["mfa_token"].(string), "code": "000000"}, from())
+		require.Equal(t, http.StatusUnauthorized, status)
+	}
+
+	// the failures were never cleared, so both steps now have to wait
+	status, _ := request(t, a, http.MethodPost, "/v1/login", credentials, from())
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	tokens, err := token.NewMaker(cfg.Token.SymmetricKey, time.Hour, time.Hour)
+	require.NoError(t, err)
+	mfaToken, _, err := tokens.CreateMfaPendingToken(merchant.Email, merchant.ID.Hex())
+	require.NoError(t, err)
+	status, _ = request(t, a, http.MethodPost, "/v1/login/2fa", map[string]string{"mfa_token": mfaToken, "code": "000000"}, from())
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	failed, err := attempts.GetAllWhereMerchantIs(merchant.ID.Hex())
+	require.NoError(t, err)
+	assert.Len(t, failed, 5)
+}
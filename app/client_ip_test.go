@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"fraud-detect-system/api/handler"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func clientIpOf(t *testing.T, trustedProxies []string, forwardedFor string) string {
+	resolve, err := handler.ResolveClientIp(trustedProxies)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(resolve)
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("clientIp").(string))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if forwardedFor != "" {
+		req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+	}
+
+	res, err := app.Test(req, -1)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestClientIpOnlyBelievesTrustedProxies(t *testing.T) {
+	// test requests come from 0.0.0.0
+	assert.Equal(t, "0.0.0.0", clientIpOf(t, nil, "203.0.113.7"), "a client can't pick its own address")
+	assert.Equal(t, "0.0.0.0", clientIpOf(t, []string{"10.0.0.0/8"}, "203.0.113.7"))
+
+	proxies := []string{"0.0.0.0", "10.0.0.0/8"}
+	assert.Equal(t, "203.0.113.7", clientIpOf(t, proxies, "203.0.113.7"))
+	assert.Equal(t, "203.0.113.7", clientIpOf(t, proxies, "198.51.100.1, 203.0.113.7, 10.1.2.3"), "entries left of the client are its own")
+	assert.Equal(t, "0.0.0.0", clientIpOf(t, proxies, ""))
+	assert.Equal(t, "10.1.2.3", clientIpOf(t, proxies, "not an ip, 10.1.2.3"))
+
+	_, err := handler.ResolveClientIp([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
+
+func TestClientIpBehindApiGateway(t *testing.T) {
+	a := newTestApp(t, "")
+	a.Server.Get("/v1/client-ip", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("clientIp").(string))
+	})
+
+	event, err := os.ReadFile("testdata/apigw_v1_hello.json")
+	require.NoError(t, err)
+
+	// api gateway appends the caller's address to whatever the caller sent
+	spoofed := strings.NewReplacer("/hello", "/client-ip", `"X-Forwarded-For": "203.0.113.7"`, `"X-Forwarded-For": "198.51.100.1, 203.0.113.7"`,
+		`"X-Forwarded-For": ["203.0.113.7"]`, `"X-Forwarded-For": ["198.51.100.1, 203.0.113.7"]`).Replace(string(event))
+
+	response, err := a.LambdaHandler()(context.TODO(), []byte(spoofed))
+	require.NoError(t, err)
+
+	res, ok := response.(events.APIGatewayProxyResponse)
+	require.True(t, ok)
+	assert.Equal(t, "203.0.113.7", res.Body)
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// deadline gives every request's user context a timeout, so a slow ml server or Mongo
+// query is cut off instead of holding the request open. Requests that run out of time
+// answer 504. A timeout of zero means no deadline.
+func deadline(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if err != nil && ctx.Err() == context.DeadlineExceeded && (errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)) {
+			return fiber.NewError(fiber.StatusGatewayTimeout, "request timed out")
+		}
+
+		return err
+	}
+}
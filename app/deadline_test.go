@@ -0,0 +1,33 @@
+package app
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeadlineCutsOffSlowRequests(t *testing.T) {
+	server := fiber.New()
+	server.Use(deadline(10 * time.Millisecond))
+	server.Get("/slow", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return c.UserContext().Err()
+	})
+	server.Get("/fast", func(c *fiber.Ctx) error {
+		_, ok := c.UserContext().Deadline()
+		assert.True(t, ok)
+		return c.SendStatus(http.StatusNoContent)
+	})
+
+	res, err := server.Test(httptest.NewRequest(http.MethodGet, "/slow", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+
+	res, err = server.Test(httptest.NewRequest(http.MethodGet, "/fast", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+}
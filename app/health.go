@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// readinessCheckTimeout bounds each dependency check so a hung dependency can't hang /readyz.
+	readinessCheckTimeout = 2 * time.Second
+
+	// how much of the queues flushQueues sends at most when shutting down, and for how long
+	flushBatch   = 20
+	flushTimeout = 5 * time.Second
+)
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// AddReadinessCheck makes /readyz fail while check returns an error.
+func (a *App) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	a.checks = append(a.checks, readinessCheck{name: name, check: check})
+}
+
+// healthz only says the process is up and serving.
+func (a *App) healthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// readyz says whether this instance should get traffic: not while shutting down, and
+// only while every dependency answers.
+func (a *App) readyz(c *fiber.Ctx) error {
+	if a.shuttingDown.Load() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "shutting down"})
+	}
+
+	results := make(map[string]string, len(a.checks))
+	ready := true
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, rc := range a.checks {
+		wg.Add(1)
+		go func(rc readinessCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.UserContext(), readinessCheckTimeout)
+			defer cancel()
+
+			result := "ok"
+			if err := rc.check(ctx); err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[rc.name] = result
+			if result != "ok" {
+				ready = false
+			}
+		}(rc)
+	}
+	wg.Wait()
+
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "unavailable", "checks": results})
+	}
+
+	return c.JSON(fiber.Map{"status": "ok", "checks": results})
+}
+
+// flushQueues sends up to flushBatch each of the webhooks and mail that are due. It
+// gives up after flushTimeout so a backlog can't hold up shutting down; what's left is
+// sent by another instance.
+func (a *App) flushQueues(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+
+	if _, err := a.WebhookService.ProcessDue(ctx, flushBatch); err != nil {
+		a.Logger.Error("cannot deliver due webhooks", "error", err)
+	}
+
+	if _, err := a.Mailer.Flush(ctx, flushBatch); err != nil {
+		a.Logger.Error("cannot flush mail outbox", "error", err)
+	}
+}
+
+// flushSpans exports the spans still buffered, before ctx is about to run out.
+func (a *App) flushSpans(ctx context.Context) {
+	if a.tracing == nil {
+		return
+	}
+
+	ctx, cancel := beforeDeadline(ctx)
+	defer cancel()
+
+	if err := a.tracing.ForceFlush(ctx); err != nil {
+		a.Logger.Warn("cannot flush traces", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fraud-detect-system/mailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readyz(t *testing.T, a *App) (int, map[string]interface{}) {
+	res, err := a.Server.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	require.NoError(t, err)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+
+	return res.StatusCode, body
+}
+
+func TestHealthzAlwaysOk(t *testing.T) {
+	res, err := newTestApp(t, "").Server.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestReadyzChecksMLServerModel(t *testing.T) {
+	loaded := true
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]bool{"model_loaded": loaded})
+	}))
+	defer ml.Close()
+
+	a := newTestApp(t, ml.URL)
+
+	status, _ := readyz(t, a)
+	assert.Equal(t, http.StatusOK, status)
+
+	loaded = false
+	status, body := readyz(t, a)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "ml server has no model loaded", body["checks"].(map[string]interface{})["ml_server"])
+}
+
+func TestReadyzFailsWhenAnyCheckFails(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ml.Close()
+
+	a := newTestApp(t, ml.URL)
+	a.AddReadinessCheck("mongo", func(ctx context.Context) error {
+		return errors.New("server selection timeout")
+	})
+
+	status, body := readyz(t, a)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "ok", body["checks"].(map[string]interface{})["ml_server"])
+}
+
+func TestReadyzFailsDuringShutdown(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ml.Close()
+
+	a := newTestApp(t, ml.URL)
+	a.Start(context.Background())
+
+	disconnected := false
+	a.disconnect = func(ctx context.Context) error {
+		disconnected = true
+		return nil
+	}
+
+	require.NoError(t, a.Shutdown(time.Second))
+	assert.True(t, disconnected)
+
+	status, _ := readyz(t, a)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func TestMetricsNeedBasicAuth(t *testing.T) {
+	cfg := testConfig("")
+	cfg.BasicAuthUsers = map[string]string{"ops": "s3cret"}
+	a, err := New(context.TODO(), cfg, testRepositories(), mailer.NewInMemory())
+	require.NoError(t, err)
+
+	res, err := a.Server.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("ops", "wrong")
+	res, err = a.Server.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("ops", "s3cret")
+	res, err = a.Server.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
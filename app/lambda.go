@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	fiberadapter "github.com/awslabs/aws-lambda-go-api-proxy/fiber"
+	"time"
+)
+
+// deadlineMargin is what's left of an invocation once it stops taking on more work.
+const deadlineMargin = 3 * time.Second
+
+var ErrUnsupportedEvent = errors.New("lambda: not an api gateway proxy or scheduled event")
+
+// LambdaHandler serves API Gateway REST (payload v1) and HTTP API (payload v2) events
+// through the fiber app. It's meant for lambda.Start; build the App once outside it so
+// the Mongo client survives warm invocations.
+//
+// Lambda freezes the process between invocations, so the background workers can't be
+// relied on to make progress. The webhooks, mail and profile jobs a request queues are
+// worked through when an EventBridge schedule invokes the function, see runScheduled,
+// so none of them adds to the response time. Only the spans are flushed before each
+// invocation returns, they would otherwise sit in a frozen exporter.
+func (a *App) LambdaHandler() func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	adapter := fiberadapter.New(a.Server)
+
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		defer a.flushSpans(ctx)
+
+		if isScheduledEvent(event) {
+			return nil, a.runScheduled(ctx)
+		}
+
+		switch payloadVersion(event) {
+		case "2.0":
+			var req events.APIGatewayV2HTTPRequest
+			if err := json.Unmarshal(event, &req); err != nil {
+				return nil, err
+			}
+			return adapter.ProxyWithContextV2(ctx, req)
+		case "1.0":
+			var req events.APIGatewayProxyRequest
+			if err := json.Unmarshal(event, &req); err != nil {
+				return nil, err
+			}
+			return adapter.ProxyWithContext(ctx, req)
+		default:
+			return nil, ErrUnsupportedEvent
+		}
+	}
+}
+
+// runScheduled queues the stale profiles, runs the due profile jobs and then sends the
+// webhooks and mail requests left behind. Schedule it every minute or so; whatever is
+// still due when the invocation is about to time out waits for the next one.
+func (a *App) runScheduled(ctx context.Context) error {
+	ctx, cancel := beforeDeadline(ctx)
+	defer cancel()
+
+	var errs []error
+
+	if _, err := a.ProfileService.ScheduleStale(ctx); err != nil {
+		a.Logger.Error("cannot queue stale profiles", "error", err)
+		errs = append(errs, err)
+	}
+
+	if _, err := a.ProfileService.ProcessDue(ctx); err != nil {
+		a.Logger.Error("cannot run due profile jobs", "error", err)
+		errs = append(errs, err)
+	}
+
+	if _, err := a.WebhookService.ProcessDue(ctx, 0); err != nil {
+		a.Logger.Error("cannot deliver due webhooks", "error", err)
+		errs = append(errs, err)
+	}
+
+	if _, err := a.Mailer.Flush(ctx, 0); err != nil {
+		a.Logger.Error("cannot flush mail outbox", "error", err)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// beforeDeadline ends ctx deadlineMargin before the invocation times out, leaving time
+// to save what was being worked on and to flush the queues.
+func beforeDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// isScheduledEvent tells the events of an EventBridge schedule from everything else.
+func isScheduledEvent(event json.RawMessage) bool {
+	probe := struct {
+		Source     string `json:"source"`
+		DetailType string `json:"detail-type"`
+	}{}
+
+	if err := json.Unmarshal(event, &probe); err != nil {
+		return false
+	}
+
+	return probe.Source == "aws.events" && probe.DetailType == "Scheduled Event"
+}
+
+// payloadVersion tells v1 and v2 events apart. v2 always carries "version": "2.0"; v1
+// carries "1.0" from HTTP APIs and nothing at all from REST APIs, so an httpMethod is
+// enough.
+func payloadVersion(event json.RawMessage) string {
+	probe := struct {
+		Version    string `json:"version"`
+		HttpMethod string `json:"httpMethod"`
+	}{}
+
+	if err := json.Unmarshal(event, &probe); err != nil {
+		return ""
+	}
+
+	if probe.Version == "2.0" {
+		return "2.0"
+	}
+	if probe.Version == "1.0" || probe.HttpMethod != "" {
+		return "1.0"
+	}
+
+	return ""
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"fraud-detect-system/config"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/mailer"
+	"fraud-detect-system/storage/memory"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the lambda path only ever asks the queues for due work
+type emptyOutbox struct{ ports.OutboxRepository }
+
+func (emptyOutbox) ClaimDue(time.Time, time.Duration) (domain.OutboxMail, bool, error) {
+	return domain.OutboxMail{}, false, nil
+}
+
+type emptyDeliveries struct {
+	ports.WebhookDeliveryRepository
+}
+
+func (emptyDeliveries) ClaimDue(time.Time, time.Duration) (domain.WebhookDelivery, bool, error) {
+	return domain.WebhookDelivery{}, false, nil
+}
+
+func testPartition() Partition {
+	transactions := memory.NewTransactionStorage()
+
+	return Partition{
+		Transactions: transactions,
+		Accounts:     memory.NewAccountStorage(),
+		Analytics:    memory.NewAnalyticsStorage(transactions),
+	}
+}
+
+func testRepositories() Repositories {
+	return Repositories{
+		Merchants:         memory.NewMerchantStorage(),
+		Live:              testPartition(),
+		Sandbox:           testPartition(),
+		LoginCounters:     memory.NewLoginCounterStorage(),
+		ApiKeys:           memory.NewApiKeyStorage(),
+		Outbox:            emptyOutbox{},
+		WebhookDeliveries: emptyDeliveries{},
+		Notifications:     memory.NewNotificationStorage(),
+		Idempotency:       memory.NewIdempotencyStorage(),
+		ProfileJobs:       memory.NewProfileJobStorage(),
+	}
+}
+
+func newTestApp(t *testing.T, mlServerUrl string) *App {
+	return newTestAppWith(t, mlServerUrl, testRepositories())
+}
+
+func newTestAppWith(t *testing.T, mlServerUrl string, repos Repositories) *App {
+	a, err := New(context.TODO(), testConfig(mlServerUrl), repos, mailer.NewInMemory())
+	require.NoError(t, err)
+
+	return a
+}
+
+func testConfig(mlServerUrl string) *config.Config {
+	return &config.Config{
+		Env:      config.EnvDevelopment,
+		Port:     "3000",
+		AppUrl:   "http://localhost:5173",
+		Log:      config.Log{Level: "error", Format: "text"},
+		MLServer: config.MLServer{Url: mlServerUrl, Timeout: time.Second},
+		Token: config.Token{
+			SymmetricKey:         "0123456789abcdef0123456789abcdef",
+			AccessTokenDuration:  time.Hour,
+			RefreshTokenDuration: time.Hour,
+		},
+		Cards: config.Cards{
+			FingerprintKey: "fingerprint key, exactly 32 long",
+			EncryptionKey:  "encryption key,  exactly 32 long",
+		},
+		Scoring: config.Scoring{
+			DeclineThreshold:    0.2,
+			ReviewThreshold:     0.1,
+			LikelihoodThreshold: 0.7,
+			MinHmmHistory:       20,
+		},
+		Profiles:             config.Profiles{RetrainAfter: 10, MaxAge: 24 * time.Hour, Workers: 2},
+		IdempotencyRetention: time.Hour,
+		RequestTimeout:       5 * time.Second,
+	}
+}
+
+func invokeRecorded(t *testing.T, a *App, file string) interface{} {
+	event, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	response, err := a.LambdaHandler()(context.TODO(), event)
+	require.NoError(t, err)
+
+	return response
+}
+
+func TestLambdaHandlerServesRestApiEvents(t *testing.T) {
+	response := invokeRecorded(t, newTestApp(t, ""), "testdata/apigw_v1_hello.json")
+
+	res, ok := response.(events.APIGatewayProxyResponse)
+	require.True(t, ok)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "hello", res.Body)
+}
+
+func TestLambdaHandlerServesHttpApiEvents(t *testing.T) {
+	response := invokeRecorded(t, newTestApp(t, ""), "testdata/apigw_v2_hello.json")
+
+	res, ok := response.(events.APIGatewayV2HTTPResponse)
+	require.True(t, ok)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "hello", res.Body)
+}
+
+func TestLambdaHandlerRejectsOtherEvents(t *testing.T) {
+	_, err := newTestApp(t, "").LambdaHandler()(context.TODO(), []byte(`{"Records": []}`))
+	assert.ErrorIs(t, err, ErrUnsupportedEvent)
+}
+
+func TestLambdaHandlerRunsProfileJobsOnlyOnSchedule(t *testing.T) {
+	repos := testRepositories()
+	a := newTestAppWith(t, "", repos)
+
+	ctx := context.TODO()
+	_, err := a.ProfileService.Enqueue(ctx, true, "card-fingerprint", domain.ProfileReasonSchedule)
+	require.NoError(t, err)
+
+	pending := func() int {
+		jobs, err := repos.ProfileJobs.Find(ctx, domain.ProfileJobPending, 10)
+		require.NoError(t, err)
+		return len(jobs)
+	}
+
+	invokeRecorded(t, a, "testdata/apigw_v2_hello.json")
+	assert.Equal(t, 1, pending(), "requests leave profile jobs alone")
+
+	response := invokeRecorded(t, a, "testdata/eventbridge_scheduled.json")
+	assert.Nil(t, response)
+	assert.Zero(t, pending())
+}
+
+// backlogOutbox always has more mail due
+type backlogOutbox struct {
+	ports.OutboxRepository
+	claimed atomic.Int64
+}
+
+func (o *backlogOutbox) ClaimDue(time.Time, time.Duration) (domain.OutboxMail, bool, error) {
+	o.claimed.Add(1)
+	return domain.OutboxMail{Mail: domain.Mail{To: "owner@shop.example"}, Status: domain.OutboxPending}, true, nil
+}
+
+func (o *backlogOutbox) Save(*domain.OutboxMail) error {
+	return nil
+}
+
+func TestLambdaHandlerLeavesTheQueuesToTheSchedule(t *testing.T) {
+	repos := testRepositories()
+	outbox := &backlogOutbox{}
+	repos.Outbox = outbox
+	a := newTestAppWith(t, "", repos)
+
+	invokeRecorded(t, a, "testdata/apigw_v2_hello.json")
+	assert.Zero(t, outbox.claimed.Load(), "requests don't wait on the outbox")
+
+	event, err := os.ReadFile("testdata/eventbridge_scheduled.json")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin+200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = a.LambdaHandler()(ctx, event)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), deadlineMargin, "the scheduled drain stops before the invocation times out")
+	assert.Greater(t, outbox.claimed.Load(), int64(flushBatch))
+}
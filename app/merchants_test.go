@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingMerchants can't store merchants, but remembers the id it was asked to store
+type failingMerchants struct {
+	ports.MerchantRepository
+	id string
+}
+
+func (r *failingMerchants) Add(ctx context.Context, merchant *domain.Merchant) (domain.Merchant, error) {
+	r.id = merchant.ID.Hex()
+	return domain.Merchant{}, errors.New("no primary")
+}
+
+const newMerchantJSON = `{"name":"Shop","email":"owner@shop.example","password":"hunter22","confirm_password":"hunter22","website_url":"https://shop.example"}`
+
+func TestAddMerchantReturnsWorkingKeys(t *testing.T) {
+	repos := testRepositories()
+	a := newTestAppWith(t, "", repos)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/merchants", strings.NewReader(newMerchantJSON))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var body struct {
+		Merchant domain.PublicMerchant `json:"merchant"`
+		ApiKeys  map[string]string     `json:"api_keys"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+
+	for mode, plain := range body.ApiKeys {
+		key, err := repos.ApiKeys.GetByHash(domain.HashSecret(plain))
+		require.NoError(t, err, mode)
+		assert.Equal(t, body.Merchant.ID, key.Merchant)
+	}
+	assert.Len(t, body.ApiKeys, 2)
+}
+
+func TestAddMerchantRevokesKeysWhenTheMerchantIsNotStored(t *testing.T) {
+	merchants := &failingMerchants{}
+	keys := memory.NewApiKeyStorage()
+
+	repos := testRepositories()
+	repos.Merchants, repos.ApiKeys = merchants, keys
+	a := newTestAppWith(t, "", repos)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/merchants", strings.NewReader(newMerchantJSON))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+
+	created, err := keys.GetAllWhereMerchantIs(merchants.id)
+	require.NoError(t, err)
+	require.Len(t, created, 2, "keys are created before the merchant")
+	for _, key := range created {
+		assert.False(t, key.RevokedAt.IsZero(), key.Mode)
+	}
+}
+
+func TestCreateKeyNeedsAMode(t *testing.T) {
+	a, mail := newAuthTestApp(t)
+	signup(t, a, mail, "owner@shop.example")
+
+	status, session := login(t, a, "owner@shop.example", "hunter22")
+	require.Equal(t, http.StatusAccepted, status)
+	keysPath := "/v1/merchants/" + session["merchant"].(map[string]interface{})["id"].(string) + "/keys"
+	auth := map[string]string{"access-token": session["access_token"].(string)}
+
+	for _, body := range []interface{}{map[string]string{}, map[string]string{"name": ""}, map[string]string{"mode": "prod"}} {
+		status, _ = request(t, a, http.MethodPost, keysPath, body, auth)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	}
+
+	status, created := request(t, a, http.MethodPost, keysPath, map[string]string{"mode": domain.ApiKeyModeTest}, auth)
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "Test key", created["key"].(map[string]interface{})["name"])
+}
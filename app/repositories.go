@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/storage"
+)
+
+// Partition holds one set of transaction and account collections. Live api keys
+// write to the live partition, test keys to the sandbox.
+type Partition struct {
+	Transactions ports.TransactionRepository
+	Accounts     ports.AccountRepository
+	Analytics    ports.AnalyticsRepository
+}
+
+// Repositories is every store the app reads or writes. Each one is created once and
+// shared by all services and handlers that need it.
+type Repositories struct {
+	Merchants ports.MerchantRepository
+
+	Live    Partition
+	Sandbox Partition
+
+	LoginAttempts     ports.LoginAttemptRepository
+	LoginCounters     ports.LoginCounterRepository
+	ApiKeys           ports.ApiKeyRepository
+	WebhookEndpoints  ports.WebhookEndpointRepository
+	WebhookDeliveries ports.WebhookDeliveryRepository
+	Alerts            ports.AlertRepository
+	Outbox            ports.OutboxRepository
+	Notifications     ports.NotificationRepository
+	Idempotency       ports.IdempotencyRepository
+	ProfileJobs       ports.ProfileJobRepository
+}
+
+// NewMongoRepositories uses mgm's default connection, see Connect.
+func NewMongoRepositories(ctx context.Context) Repositories {
+	return Repositories{
+		Merchants: storage.NewMerchantStorage(ctx, "merchants"),
+
+		Live: Partition{
+			Transactions: storage.NewTransactionStorage(ctx, "transactions"),
+			Accounts:     storage.NewAccountStorage(ctx, "accounts"),
+			Analytics:    storage.NewAnalyticsStorage("transactions"),
+		},
+		Sandbox: Partition{
+			Transactions: storage.NewTransactionStorage(ctx, "sandbox_transactions"),
+			Accounts:     storage.NewAccountStorage(ctx, "sandbox_accounts"),
+			Analytics:    storage.NewAnalyticsStorage("sandbox_transactions"),
+		},
+
+		LoginAttempts:     storage.NewLoginAttemptStorage(ctx, "login_attempts"),
+		LoginCounters:     storage.NewLoginCounterStorage(ctx, "login_counters"),
+		ApiKeys:           storage.NewApiKeyStorage(ctx, "api_keys", "merchants"),
+		WebhookEndpoints:  storage.NewWebhookEndpointStorage(ctx, "webhook_endpoints"),
+		WebhookDeliveries: storage.NewWebhookDeliveryStorage(ctx, "webhook_deliveries"),
+		Alerts:            storage.NewAlertStorage(ctx, "alert_settings", "pending_alerts"),
+		Outbox:            storage.NewOutboxStorage(ctx, "mail_outbox"),
+		Notifications:     storage.NewNotificationStorage(ctx, "notifications"),
+		Idempotency:       storage.NewIdempotencyStorage(ctx, "idempotency_keys"),
+		ProfileJobs:       storage.NewProfileJobStorage(ctx, "profile_jobs"),
+	}
+}
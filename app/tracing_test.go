@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fraud-detect-system/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScoringIsOneTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"prediction": 0, "probability": [0.97, 0.03]}`))
+	}))
+	defer ml.Close()
+
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+	repos.WebhookEndpoints = noEndpoints{}
+
+	a := newTestAppWith(t, ml.URL+"/", repos)
+
+	payment := `{"amount": 25, "card_holder": "Ada", "credit_card": "4242 4242 4242 4242", "expiry_month": 12, "expiry_year": 2099, "email": "ada@example.com"}`
+	payment = strings.Replace(payment, "2099", strconv.Itoa(time.Now().Year()+1), 1)
+
+	res, body := submitPayment(t, a, "sk_test_m1", "", payment)
+	require.Equal(t, http.StatusOK, res.StatusCode, body)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	ids := map[trace.SpanID]bool{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		ids[span.SpanContext().SpanID()] = true
+	}
+
+	root, ok := spans["POST /v1/transactions"]
+	require.True(t, ok, "server span")
+	assert.Equal(t, trace.SpanKindServer, root.SpanKind())
+	assert.False(t, root.Parent().IsValid())
+
+	for _, name := range []string{
+		"FeatureExtractionService.ExtractHour",
+		"FeatureExtractionService.ExtractTravelSpeed",
+		"FeatureExtractionService.ExtractLast24HourCount",
+		"FraudDetectorService.DetectXGB",
+	} {
+		span, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID(), name)
+			assert.True(t, ids[span.Parent().SpanID()], "%s has a parent in the trace", name)
+		}
+	}
+
+	var client sdktrace.ReadOnlySpan
+	for name, span := range spans {
+		if span.SpanKind() == trace.SpanKindClient && strings.HasPrefix(name, "POST ") {
+			client = span
+		}
+	}
+	require.NotNil(t, client, "ml client span")
+	assert.Equal(t, spans["FraudDetectorService.DetectXGB"].SpanContext().SpanID(), client.Parent().SpanID())
+
+	// the ml server continues the trace under the client span
+	assert.Equal(t, "00-"+root.SpanContext().TraceID().String()+"-"+client.SpanContext().SpanID().String()+"-01", traceparent)
+}
@@ -0,0 +1,319 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// apiKeys knows the keys it was made with, by their plain text
+type apiKeys struct {
+	ports.ApiKeyRepository
+	keys map[string]domain.ApiKey
+}
+
+func (r apiKeys) GetByHash(keyHash string) (domain.ApiKey, error) {
+	for plain, key := range r.keys {
+		if domain.HashSecret(plain) == keyHash {
+			return key, nil
+		}
+	}
+
+	return domain.ApiKey{}, mongo.ErrNoDocuments
+}
+
+func (r apiKeys) TouchLastUsed(string, time.Time) error {
+	return nil
+}
+
+type noEndpoints struct {
+	ports.WebhookEndpointRepository
+}
+
+func (noEndpoints) GetAllWhereMerchantIs(string) ([]domain.WebhookEndpoint, error) {
+	return nil, nil
+}
+
+func submitPayment(t *testing.T, a *App, key string, idempotencyKey string, payment string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/transactions", strings.NewReader(payment))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
+
+func listTransactions(t *testing.T, a *App, key string, query string) (int, domain.TransactionPage) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/transactions"+query, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+
+	var page domain.TransactionPage
+	if res.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&page))
+	}
+
+	return res.StatusCode, page
+}
+
+func TestListTransactions(t *testing.T) {
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_live_m1": {Merchant: "m1", Mode: domain.ApiKeyModeLive},
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+
+	ctx := context.TODO()
+	for _, tx := range []domain.Transaction{
+		{Merchant: "m1", Amt: 10},
+		{Merchant: "m1", Amt: 250, Decision: domain.DecisionDecline},
+		{Merchant: "m1", Amt: 40},
+		{Merchant: "m2", Amt: 99},
+	} {
+		_, err := repos.Live.Transactions.Add(ctx, tx)
+		require.NoError(t, err)
+	}
+	_, err := repos.Sandbox.Transactions.Add(ctx, domain.Transaction{Merchant: "m1", Amt: 1})
+	require.NoError(t, err)
+
+	a := newTestAppWith(t, "", repos)
+
+	status, _ := listTransactions(t, a, "", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, page := listTransactions(t, a, "sk_live_m1", "?sort=amount&limit=2")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Transactions, 2)
+	assert.Equal(t, []float64{10, 40}, []float64{page.Transactions[0].Amt, page.Transactions[1].Amt})
+	assert.True(t, page.HasMore)
+
+	status, page = listTransactions(t, a, "sk_live_m1", "?sort=amount&limit=2&cursor="+page.NextCursor)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, 250.0, page.Transactions[0].Amt)
+	assert.False(t, page.HasMore)
+
+	status, page = listTransactions(t, a, "sk_live_m1", "?decision=decline&min_amount=100")
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, page.Transactions, 1)
+
+	// test keys only see the sandbox
+	status, page = listTransactions(t, a, "sk_test_m1", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, 1.0, page.Transactions[0].Amt)
+
+	for _, query := range []string{"?sort=email", "?limit=1000", "?from=yesterday", "?decision=maybe", "?cursor=" + primitive.NewObjectID().Hex()} {
+		status, _ = listTransactions(t, a, "sk_live_m1", query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func TestSubmitTransactionIdempotently(t *testing.T) {
+	var scored atomic.Int32
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scored.Add(1)
+		w.Write([]byte(`{"prediction": 0, "probability": [0.97, 0.03]}`))
+	}))
+	defer ml.Close()
+
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+	repos.WebhookEndpoints = noEndpoints{}
+
+	a := newTestAppWith(t, ml.URL+"/", repos)
+
+	payment := `{"amount": 25, "card_holder": "Ada", "credit_card": "4242 4242 4242 4242", "expiry_month": 12, "expiry_year": 2099, "email": "ada@example.com"}`
+	payment = strings.Replace(payment, "2099", strconv.Itoa(time.Now().Year()+1), 1)
+
+	first, firstBody := submitPayment(t, a, "sk_test_m1", "order-1", payment)
+	require.Equal(t, http.StatusOK, first.StatusCode, firstBody)
+	assert.Empty(t, first.Header.Get("Idempotent-Replayed"))
+
+	retry, retryBody := submitPayment(t, a, "sk_test_m1", "order-1", payment)
+	require.Equal(t, http.StatusOK, retry.StatusCode)
+	assert.Equal(t, "true", retry.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, firstBody, retryBody)
+	assert.Equal(t, int32(1), scored.Load())
+
+	page, err := repos.Sandbox.Transactions.Find(context.TODO(), domain.TransactionQuery{Merchant: "m1", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, "visa", page.Transactions[0].Card.Scheme)
+	assert.Equal(t, domain.CardTypeCredit, page.Transactions[0].CardType)
+
+	other, _ := submitPayment(t, a, "sk_test_m1", "order-1", strings.Replace(payment, `"amount": 25`, `"amount": 26`, 1))
+	assert.Equal(t, http.StatusUnprocessableEntity, other.StatusCode)
+
+	// without a key every request is scored
+	res, _ := submitPayment(t, a, "sk_test_m1", "", payment)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int32(2), scored.Load())
+
+	// bad cards are turned away before scoring
+	for _, bad := range []string{
+		strings.Replace(payment, "4242 4242 4242 4242", "4242 4242 4242 4241", 1),
+		strings.Replace(payment, strconv.Itoa(time.Now().Year()+1), strconv.Itoa(time.Now().Year()-1), 1),
+	} {
+		res, body := submitPayment(t, a, "sk_test_m1", "", bad)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, body)
+	}
+	assert.Equal(t, int32(2), scored.Load())
+}
+
+func TestSubmitTransactionKeepsTheResolvedClientIp(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prediction": 0, "probability": [0.97, 0.03]}`))
+	}))
+	defer ml.Close()
+
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+	repos.WebhookEndpoints = noEndpoints{}
+
+	a := newTestAppWith(t, ml.URL+"/", repos)
+
+	payment := `{"amount": 25, "card_holder": "Ada", "credit_card": "4242 4242 4242 4242", "expiry_month": 12, "expiry_year": 2099, "email": "ada@example.com"}`
+	payment = strings.Replace(payment, "2099", strconv.Itoa(time.Now().Year()+1), 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/transactions", strings.NewReader(payment))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk_test_m1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("User-Agent", "checkout/1.0")
+
+	res, err := a.Server.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// test requests come from 0.0.0.0, which isn't a trusted proxy
+	page, err := repos.Sandbox.Transactions.Find(context.TODO(), domain.TransactionQuery{Merchant: "m1", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, "0.0.0.0", page.Transactions[0].Ip)
+	assert.Equal(t, "checkout/1.0", page.Transactions[0].UserAgent)
+}
+
+func TestSubmitTransactionIdempotentlyWhileTheFirstIsScoring(t *testing.T) {
+	var scored atomic.Int32
+	scoring := make(chan struct{})
+	unblock := make(chan struct{})
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scored.Add(1) == 1 {
+			close(scoring)
+		}
+		<-unblock
+		w.Write([]byte(`{"prediction": 0, "probability": [0.97, 0.03]}`))
+	}))
+	defer ml.Close()
+
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+	repos.WebhookEndpoints = noEndpoints{}
+
+	a := newTestAppWith(t, ml.URL+"/", repos)
+
+	payment := `{"amount": 25, "card_holder": "Ada", "credit_card": "4242 4242 4242 4242", "expiry_month": 12, "expiry_year": 2099, "email": "ada@example.com"}`
+	payment = strings.Replace(payment, "2099", strconv.Itoa(time.Now().Year()+1), 1)
+
+	type result struct {
+		res  *http.Response
+		body string
+	}
+	submit := func(results chan<- result) {
+		res, body := submitPayment(t, a, "sk_test_m1", "order-1", payment)
+		results <- result{res, body}
+	}
+
+	first := make(chan result, 1)
+	go submit(first)
+	<-scoring
+
+	second := make(chan result, 1)
+	go submit(second)
+
+	// the second is waiting on the first, not scoring on its own
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(1), scored.Load())
+	close(unblock)
+
+	one, two := <-first, <-second
+	require.Equal(t, http.StatusOK, one.res.StatusCode, one.body)
+	require.Equal(t, http.StatusOK, two.res.StatusCode, two.body)
+	assert.Equal(t, "true", two.res.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, one.body, two.body)
+	assert.Equal(t, int32(1), scored.Load())
+}
+
+// flakyAccounts fails the first lookup the way a dropped connection would
+type flakyAccounts struct {
+	ports.AccountRepository
+	failed atomic.Bool
+}
+
+func (r *flakyAccounts) Get(ctx context.Context, fingerprint string) (domain.Account, error) {
+	if r.failed.CompareAndSwap(false, true) {
+		return domain.Account{}, errors.New("connection reset by peer")
+	}
+
+	return r.AccountRepository.Get(ctx, fingerprint)
+}
+
+func TestSubmitTransactionIdempotentlyDoesNotKeepPanics(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prediction": 0, "probability": [0.97, 0.03]}`))
+	}))
+	defer ml.Close()
+
+	repos := testRepositories()
+	repos.ApiKeys = apiKeys{keys: map[string]domain.ApiKey{
+		"sk_test_m1": {Merchant: "m1", Mode: domain.ApiKeyModeTest},
+	}}
+	repos.WebhookEndpoints = noEndpoints{}
+	repos.Sandbox.Accounts = &flakyAccounts{AccountRepository: repos.Sandbox.Accounts}
+
+	a := newTestAppWith(t, ml.URL+"/", repos)
+
+	payment := `{"amount": 25, "card_holder": "Ada", "credit_card": "4242 4242 4242 4242", "expiry_month": 12, "expiry_year": 2099, "email": "ada@example.com"}`
+	payment = strings.Replace(payment, "2099", strconv.Itoa(time.Now().Year()+1), 1)
+
+	first, _ := submitPayment(t, a, "sk_test_m1", "order-1", payment)
+	assert.Equal(t, http.StatusInternalServerError, first.StatusCode)
+
+	retry, body := submitPayment(t, a, "sk_test_m1", "order-1", payment)
+	require.Equal(t, http.StatusOK, retry.StatusCode, body)
+	assert.Empty(t, retry.Header.Get("Idempotent-Replayed"))
+	assert.Contains(t, body, "probability")
+}
@@ -0,0 +1,121 @@
+// Package cardbin looks up what a card's bank identification number, its first 6 digits,
+// says about the card: its scheme, whether it is credit, debit or prepaid, and who
+// issued it where.
+package cardbin
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"fraud-detect-system/domain"
+	"io"
+	"strings"
+)
+
+// Info is what a range says about its cards. Anything it doesn't know is left empty.
+type Info struct {
+	Scheme  string
+	Type    int    // one of the domain.CardType constants
+	Country string // ISO 3166-1 alpha-2
+	Issuer  string
+}
+
+// Range is the prefixes from Low to High, inclusive, which are digit strings of the
+// same length, 1 to 6 digits.
+type Range struct {
+	Low  string
+	High string
+	Info
+}
+
+// Table is a list of ranges. Where ranges overlap the one with the longest prefixes
+// wins, so a scheme's range can be refined by its issuers'.
+type Table []Range
+
+// Lookup finds the most specific range number's bin is in. Only the first 6 digits of
+// number are looked at, so a stored bin does as well as the full number.
+func (t Table) Lookup(number string) (Info, bool) {
+	var found *Range
+	for i := range t {
+		r := &t[i]
+		if len(number) < len(r.Low) {
+			continue
+		}
+
+		prefix := number[:len(r.Low)]
+		if prefix >= r.Low && prefix <= r.High && (found == nil || len(r.Low) > len(found.Low)) {
+			found = r
+		}
+	}
+
+	if found == nil {
+		return Info{}, false
+	}
+
+	return found.Info, true
+}
+
+var cardTypes = map[string]int{
+	"":        domain.CardTypeUnknown,
+	"credit":  domain.CardTypeCredit,
+	"debit":   domain.CardTypeDebit,
+	"prepaid": domain.CardTypePrepaid,
+}
+
+// Load reads a table from csv rows of low,high,scheme,type,country,issuer, type being
+// credit, debit, prepaid or empty. A first row starting with "low" is a header.
+func Load(r io.Reader) (Table, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+
+	var table Table
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return table, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && record[0] == "low" {
+			continue
+		}
+
+		cardType, ok := cardTypes[strings.ToLower(record[3])]
+		if !ok {
+			return nil, fmt.Errorf("bin table line %d: unknown card type %q", line, record[3])
+		}
+
+		rng := Range{
+			Low:  record[0],
+			High: record[1],
+			Info: Info{
+				Scheme:  record[2],
+				Type:    cardType,
+				Country: strings.ToUpper(record[4]),
+				Issuer:  record[5],
+			},
+		}
+		if !validPrefixes(rng.Low, rng.High) {
+			return nil, fmt.Errorf("bin table line %d: %s-%s is not a range of 1 to 6 digit prefixes", line, rng.Low, rng.High)
+		}
+
+		table = append(table, rng)
+	}
+}
+
+func validPrefixes(low string, high string) bool {
+	if len(low) == 0 || len(low) > 6 || len(low) != len(high) || low > high {
+		return false
+	}
+
+	for _, r := range low + high {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,52 @@
+package cardbin
+
+import (
+	"fraud-detect-system/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestLookupPrefersLongestPrefix(t *testing.T) {
+	info, ok := Default.Lookup("5105105105105100")
+	require.True(t, ok)
+	assert.Equal(t, Info{Scheme: "mastercard", Type: domain.CardTypePrepaid, Country: "US", Issuer: "Test Bank"}, info)
+
+	info, ok = Default.Lookup("530000")
+	require.True(t, ok)
+	assert.Equal(t, Info{Scheme: "mastercard"}, info)
+
+	info, ok = Default.Lookup("2720990000000000")
+	require.True(t, ok)
+	assert.Equal(t, "mastercard", info.Scheme)
+
+	info, ok = Default.Lookup("506100")
+	require.True(t, ok)
+	assert.Equal(t, Info{Scheme: "verve", Type: domain.CardTypeDebit, Country: "NG"}, info)
+
+	_, ok = Default.Lookup("9999999999999999")
+	assert.False(t, ok)
+}
+
+func TestLoad(t *testing.T) {
+	table, err := Load(strings.NewReader("low,high,scheme,type,country,issuer\n" +
+		"4,4,visa,,,\n" +
+		"411111,411112,visa,Prepaid,gb,Some Bank\n"))
+	require.NoError(t, err)
+
+	info, ok := table.Lookup("411112")
+	require.True(t, ok)
+	assert.Equal(t, Info{Scheme: "visa", Type: domain.CardTypePrepaid, Country: "GB", Issuer: "Some Bank"}, info)
+
+	for _, bad := range []string{
+		"4,4,visa,gold,,\n",
+		"41,4,visa,,,\n",
+		"42,41,visa,,,\n",
+		"4x,4x,visa,,,\n",
+		"4,4,visa\n",
+	} {
+		_, err := Load(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,31 @@
+package cardbin
+
+import "fraud-detect-system/domain"
+
+// Default knows the schemes' ranges and the card processors' published test cards,
+// enough to tell schemes apart and to exercise the card features. Issuer and country
+// data needs a full table from a bin data provider, see Load.
+var Default = Table{
+	{Low: "4", High: "4", Info: Info{Scheme: "visa"}},
+	{Low: "51", High: "55", Info: Info{Scheme: "mastercard"}},
+	{Low: "2221", High: "2720", Info: Info{Scheme: "mastercard"}},
+	{Low: "34", High: "34", Info: Info{Scheme: "amex", Type: domain.CardTypeCredit}},
+	{Low: "37", High: "37", Info: Info{Scheme: "amex", Type: domain.CardTypeCredit}},
+	{Low: "300", High: "305", Info: Info{Scheme: "diners"}},
+	{Low: "36", High: "36", Info: Info{Scheme: "diners"}},
+	{Low: "6011", High: "6011", Info: Info{Scheme: "discover"}},
+	{Low: "644", High: "649", Info: Info{Scheme: "discover"}},
+	{Low: "65", High: "65", Info: Info{Scheme: "discover"}},
+	{Low: "3528", High: "3589", Info: Info{Scheme: "jcb"}},
+	{Low: "62", High: "62", Info: Info{Scheme: "unionpay"}},
+	{Low: "506099", High: "506198", Info: Info{Scheme: "verve", Type: domain.CardTypeDebit, Country: "NG"}},
+	{Low: "650002", High: "650027", Info: Info{Scheme: "verve", Type: domain.CardTypeDebit, Country: "NG"}},
+
+	// test cards
+	{Low: "424242", High: "424242", Info: Info{Scheme: "visa", Type: domain.CardTypeCredit, Country: "US", Issuer: "Test Bank"}},
+	{Low: "400005", High: "400005", Info: Info{Scheme: "visa", Type: domain.CardTypeDebit, Country: "US", Issuer: "Test Bank"}},
+	{Low: "555555", High: "555555", Info: Info{Scheme: "mastercard", Type: domain.CardTypeCredit, Country: "US", Issuer: "Test Bank"}},
+	{Low: "520082", High: "520082", Info: Info{Scheme: "mastercard", Type: domain.CardTypeDebit, Country: "US", Issuer: "Test Bank"}},
+	{Low: "510510", High: "510510", Info: Info{Scheme: "mastercard", Type: domain.CardTypePrepaid, Country: "US", Issuer: "Test Bank"}},
+	{Low: "400000", High: "400000", Info: Info{Scheme: "visa", Type: domain.CardTypeCredit, Country: "US", Issuer: "Test Bank"}},
+}
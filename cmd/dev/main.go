@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fraud-detect-system/app"
+	"fraud-detect-system/config"
+	"log"
+	"os"
+)
+
+// dev runs the api against a local mongo (docker on 27018) and ml server without
+// needing a .env file. Anything already set in the environment wins.
+func main() {
+	setDefault("ENV", config.EnvDevelopment)
+	setDefault("MONGO_URL", "mongodb://localhost:27018")
+	setDefault("ML_SERVER_URL", "http://localhost:5000/")
+	setDefault("APP_URL", "http://localhost:5173")
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := app.Bootstrap(context.TODO(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a.Start(context.Background())
+
+	if err = a.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setDefault(key string, value string) {
+	if _, ok := os.LookupEnv(key); !ok {
+		os.Setenv(key, value)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"fraud-detect-system/app"
+	"fraud-detect-system/config"
+	"github.com/aws/aws-lambda-go/lambda"
+	"log"
+	"os"
+)
+
+// lambda serves the api behind API Gateway, and runs the profile jobs when an
+// EventBridge schedule invokes it. Run it with -event <file> to feed it one recorded
+// event locally and print the response instead.
+func main() {
+	eventFile := flag.String("event", "", "invoke once with the API Gateway or EventBridge event in this json file")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// built once per container, warm invocations reuse the app and its mongo client
+	a, err := app.Bootstrap(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := a.LambdaHandler()
+
+	if *eventFile == "" {
+		a.Start(context.Background())
+		lambda.Start(handler)
+		return
+	}
+
+	event, err := os.ReadFile(*eventFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	response, err := handler(context.Background(), event)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, _ := json.MarshalIndent(response, "", "  ")
+	fmt.Println(string(out))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fraud-detect-system/app"
+	"fraud-detect-system/config"
+	"fraud-detect-system/storage"
+	"fraud-detect-system/vault"
+	"log"
+)
+
+// migrate-cards moves the transactions and accounts stored before the card vault off
+// plain card numbers. Run it with the production card keys right after deploying, and
+// again if anything was written by an older version in between. Cards that paid since
+// the deploy already have a new account, which the one from before is merged into.
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = app.Connect(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	cards, err := vault.New(cfg.Cards.FingerprintKey, cfg.Cards.EncryptionKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for collName, keepNumber := range map[string]bool{
+		"accounts":             true,
+		"sandbox_accounts":     true,
+		"transactions":         false,
+		"sandbox_transactions": false,
+	} {
+		migrated, err := storage.MigrateCards(ctx, collName, cards, keepNumber)
+		if err != nil {
+			log.Fatalf("%s: %v", collName, err)
+		}
+
+		log.Printf("%s: %d documents migrated", collName, migrated)
+	}
+}
@@ -0,0 +1,470 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// The dev keys, database and app url are only ever used when ENV is development and
+// none is configured.
+const (
+	devSymmetricKey       = "YELLOW SUBMARINE, BLACK WIZARDRY"
+	devCardFingerprintKey = "development card fingerprint key"
+	devCardEncryptionKey  = "development card encryption key!"
+	devMongoDatabase      = "fraudis_dev"
+	devAppUrl             = "http://localhost:5173"
+)
+
+type Config struct {
+	Env     string `yaml:"env"`
+	Port    string `yaml:"port"`
+	AppUrl  string `yaml:"app_url"` // dashboard links in emails point here
+	DevMode bool   `yaml:"-"`
+
+	// how long in-flight requests get to finish after SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// how long a request may spend, scoring and Mongo included, before it's cut off
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// how long a response is kept to replay to retries with the same Idempotency-Key
+	IdempotencyRetention time.Duration `yaml:"idempotency_retention"`
+
+	Log      Log      `yaml:"log"`
+	Mongo    Mongo    `yaml:"mongo"`
+	MLServer MLServer `yaml:"ml_server"`
+	SMTP     SMTP     `yaml:"smtp"`
+	Token    Token    `yaml:"token"`
+	Cards    Cards    `yaml:"cards"`
+	Scoring  Scoring  `yaml:"scoring"`
+	Profiles Profiles `yaml:"profiles"`
+	Tracing  Tracing  `yaml:"tracing"`
+
+	// dashboard admin users allowed in with basic auth, and the only ones who can
+	// scrape /metrics, user -> password
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	// addresses or cidr ranges of the proxies in front of the api, the only ones whose
+	// X-Forwarded-For is believed
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type Log struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // json or text, text by default in development
+}
+
+type Mongo struct {
+	Url      string `yaml:"url"`
+	Database string `yaml:"database"`
+}
+
+type MLServer struct {
+	Url     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"` // extra attempts when the server is down or answers 5xx
+}
+
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type Token struct {
+	SymmetricKey         string        `yaml:"symmetric_key"`
+	AccessTokenDuration  time.Duration `yaml:"access_token_duration"`
+	RefreshTokenDuration time.Duration `yaml:"refresh_token_duration"`
+}
+
+// Cards are the card vault's keys. Changing the fingerprint key orphans every stored
+// fingerprint, changing the encryption key every stored number.
+type Cards struct {
+	FingerprintKey string `yaml:"fingerprint_key"`
+	EncryptionKey  string `yaml:"encryption_key"`
+	BinTable       string `yaml:"bin_table"` // csv of bin ranges, see cardbin.Load; the built in table when empty
+}
+
+type Tracing struct {
+	Exporter     string  `yaml:"exporter"`      // none, stdout or otlp
+	OTLPEndpoint string  `yaml:"otlp_endpoint"` // host:port of an OTLP/HTTP collector
+	OTLPInsecure bool    `yaml:"otlp_insecure"` // plain http to the collector, for local runs
+	SampleRatio  float64 `yaml:"sample_ratio"`  // share of new traces recorded, callers' sampling decisions are kept
+	ServiceName  string  `yaml:"service_name"`
+}
+
+type Scoring struct {
+	DeclineThreshold    float64 `yaml:"decline_threshold"`    // xgb fraud probability at which a transaction is declined
+	ReviewThreshold     float64 `yaml:"review_threshold"`     // probability at which it is held for review instead
+	LikelihoodThreshold float64 `yaml:"likelihood_threshold"` // hmm average likelihood at which it is declined
+	MinHmmHistory       int     `yaml:"min_hmm_history"`      // valid transactions a card needs before the hmm is used
+
+	PrepaidWeight         float64 `yaml:"prepaid_weight"`          // added to the probability for prepaid cards
+	CountryMismatchWeight float64 `yaml:"country_mismatch_weight"` // added when the card is from another country than the payment
+}
+
+// Profiles is when the background jobs recompute an account's spending habits and hmm.
+type Profiles struct {
+	RetrainAfter int           `yaml:"retrain_after"` // new valid transactions since the last profile
+	MaxAge       time.Duration `yaml:"max_age"`       // or, failing that, how old the profile may get
+	Workers      int           `yaml:"workers"`       // jobs run at once by each instance
+}
+
+func defaults() *Config {
+	return &Config{
+		Env:                  EnvProduction,
+		Port:                 "3000",
+		ShutdownTimeout:      20 * time.Second,
+		RequestTimeout:       30 * time.Second,
+		IdempotencyRetention: 24 * time.Hour,
+		Log: Log{
+			Level: "info",
+		},
+		MLServer: MLServer{
+			Timeout: 10 * time.Second,
+			Retries: 2,
+		},
+		Token: Token{
+			AccessTokenDuration:  20000 * time.Minute,
+			RefreshTokenDuration: 168 * time.Hour,
+		},
+		Tracing: Tracing{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4318",
+			SampleRatio:  1,
+			ServiceName:  "fraudis-api",
+		},
+		Scoring: Scoring{
+			DeclineThreshold:    0.2,
+			ReviewThreshold:     0.1,
+			LikelihoodThreshold: 0.7,
+			MinHmmHistory:       20,
+
+			PrepaidWeight:         0.05,
+			CountryMismatchWeight: 0.05,
+		},
+		Profiles: Profiles{
+			RetrainAfter: 10,
+			MaxAge:       24 * time.Hour,
+			Workers:      4,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing precedence: defaults, the YAML
+// file named by CONFIG_FILE (if any), and the environment, which .env files in the
+// working directory add to without overriding. It fails if the result isn't usable.
+func Load() (*Config, error) {
+	// .env is optional, production sets real environment variables
+	_ = godotenv.Load()
+
+	config := defaults()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := config.loadYAML(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	config.DevMode = config.Env == EnvDevelopment
+	if config.Log.Format == "" {
+		config.Log.Format = "json"
+		if config.DevMode {
+			config.Log.Format = "text"
+		}
+	}
+	if config.AppUrl == "" && config.DevMode {
+		config.AppUrl = devAppUrl
+	}
+	if config.Mongo.Database == "" && config.DevMode {
+		config.Mongo.Database = devMongoDatabase
+	}
+	if config.Token.SymmetricKey == "" && config.DevMode {
+		config.Token.SymmetricKey = devSymmetricKey
+	}
+	if config.Cards.FingerprintKey == "" && config.DevMode {
+		config.Cards.FingerprintKey = devCardFingerprintKey
+	}
+	if config.Cards.EncryptionKey == "" && config.DevMode {
+		config.Cards.EncryptionKey = devCardEncryptionKey
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) loadYAML(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if err = yaml.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+
+	var errs []string
+	parse := func(key string, dst interface{}) {
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+
+		var err error
+		switch d := dst.(type) {
+		case *float64:
+			*d, err = strconv.ParseFloat(v, 64)
+		case *int:
+			*d, err = strconv.Atoi(v)
+		case *time.Duration:
+			*d, err = time.ParseDuration(v)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+
+	str("ENV", &c.Env)
+	str("PORT", &c.Port)
+	str("APP_URL", &c.AppUrl)
+	parse("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	parse("REQUEST_TIMEOUT", &c.RequestTimeout)
+	parse("IDEMPOTENCY_RETENTION", &c.IdempotencyRetention)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+
+	str("MONGO_URL", &c.Mongo.Url)
+	str("MONGO_DATABASE", &c.Mongo.Database)
+
+	str("ML_SERVER_URL_DEV", &c.MLServer.Url) // the name older deployments use
+	str("ML_SERVER_URL", &c.MLServer.Url)
+	parse("ML_SERVER_TIMEOUT", &c.MLServer.Timeout)
+	parse("ML_SERVER_RETRIES", &c.MLServer.Retries)
+
+	str("SMTP_HOST", &c.SMTP.Host)
+	str("SMTP_PORT", &c.SMTP.Port)
+	str("SMTP_USERNAME", &c.SMTP.Username)
+	str("SMTP_PASSWORD", &c.SMTP.Password)
+	str("SMTP_FROM", &c.SMTP.From)
+
+	str("TOKEN_SYMMETRIC_KEY", &c.Token.SymmetricKey)
+	parse("ACCESS_TOKEN_DURATION", &c.Token.AccessTokenDuration)
+	parse("REFRESH_TOKEN_DURATION", &c.Token.RefreshTokenDuration)
+
+	str("CARD_FINGERPRINT_KEY", &c.Cards.FingerprintKey)
+	str("CARD_ENCRYPTION_KEY", &c.Cards.EncryptionKey)
+	str("BIN_TABLE", &c.Cards.BinTable)
+
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("OTLP_ENDPOINT", &c.Tracing.OTLPEndpoint)
+	parse("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+	str("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	if v, ok := os.LookupEnv("OTLP_INSECURE"); ok {
+		c.Tracing.OTLPInsecure, _ = strconv.ParseBool(v)
+	}
+
+	parse("DECLINE_THRESHOLD", &c.Scoring.DeclineThreshold)
+	parse("REVIEW_THRESHOLD", &c.Scoring.ReviewThreshold)
+	parse("LIKELIHOOD_THRESHOLD", &c.Scoring.LikelihoodThreshold)
+	parse("MIN_HMM_HISTORY", &c.Scoring.MinHmmHistory)
+	parse("PREPAID_WEIGHT", &c.Scoring.PrepaidWeight)
+	parse("COUNTRY_MISMATCH_WEIGHT", &c.Scoring.CountryMismatchWeight)
+
+	parse("PROFILE_RETRAIN_AFTER", &c.Profiles.RetrainAfter)
+	parse("PROFILE_MAX_AGE", &c.Profiles.MaxAge)
+	parse("PROFILE_WORKERS", &c.Profiles.Workers)
+
+	if v, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = nil
+		for _, proxy := range strings.Split(v, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.TrustedProxies = append(c.TrustedProxies, proxy)
+			}
+		}
+	}
+
+	// user:password pairs separated by commas
+	if v, ok := os.LookupEnv("BASIC_AUTH_USERS"); ok {
+		c.BasicAuthUsers = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			user, password, found := strings.Cut(strings.TrimSpace(pair), ":")
+			if !found || user == "" || password == "" {
+				errs = append(errs, "BASIC_AUTH_USERS: expected user:password pairs")
+				break
+			}
+			c.BasicAuthUsers[user] = password
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		fail("ENV must be %q or %q", EnvDevelopment, EnvProduction)
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		fail("PORT must be a tcp port number")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		fail("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.RequestTimeout <= 0 {
+		fail("REQUEST_TIMEOUT must be positive")
+	}
+	if c.IdempotencyRetention <= 0 {
+		fail("IDEMPOTENCY_RETENTION must be positive")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				fail("TRUSTED_PROXIES: %q is not an ip address or cidr range", proxy)
+			}
+		}
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("LOG_LEVEL must be debug, info, warn or error")
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		fail("LOG_FORMAT must be json or text")
+	}
+
+	if c.Mongo.Url == "" {
+		fail("MONGO_URL is required")
+	}
+	if c.Mongo.Database == "" {
+		fail("MONGO_DATABASE is required")
+	}
+
+	if u, err := url.Parse(c.MLServer.Url); c.MLServer.Url == "" || err != nil || u.Host == "" {
+		fail("ML_SERVER_URL must be an absolute url")
+	}
+	if c.MLServer.Timeout <= 0 {
+		fail("ML_SERVER_TIMEOUT must be positive")
+	}
+	if c.MLServer.Retries < 0 {
+		fail("ML_SERVER_RETRIES must not be negative")
+	}
+
+	// links in emails have to work outside the dashboard
+	if u, err := url.Parse(c.AppUrl); c.AppUrl == "" || err != nil || u.Host == "" {
+		fail("APP_URL must be an absolute url")
+	}
+
+	// paseto v2 local tokens need a 32 byte key
+	if len(c.Token.SymmetricKey) != 32 {
+		fail("TOKEN_SYMMETRIC_KEY must be exactly 32 bytes")
+	} else if c.Token.SymmetricKey == devSymmetricKey && !c.DevMode {
+		fail("TOKEN_SYMMETRIC_KEY must not be the development key outside development")
+	}
+	if c.Token.AccessTokenDuration <= 0 || c.Token.RefreshTokenDuration <= 0 {
+		fail("token durations must be positive")
+	}
+
+	// hmac-sha256 and aes-256 keys
+	for name, key := range map[string]string{"CARD_FINGERPRINT_KEY": c.Cards.FingerprintKey, "CARD_ENCRYPTION_KEY": c.Cards.EncryptionKey} {
+		if len(key) != 32 {
+			fail("%s must be exactly 32 bytes", name)
+		} else if (key == devCardFingerprintKey || key == devCardEncryptionKey) && !c.DevMode {
+			fail("%s must not be the development key outside development", name)
+		}
+	}
+	if c.Cards.FingerprintKey != "" && c.Cards.FingerprintKey == c.Cards.EncryptionKey {
+		fail("CARD_FINGERPRINT_KEY and CARD_ENCRYPTION_KEY must differ")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.OTLPEndpoint == "" {
+			fail("OTLP_ENDPOINT is required with the otlp exporter")
+		}
+	default:
+		fail("TRACING_EXPORTER must be none, stdout or otlp")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("TRACING_SAMPLE_RATIO must be in [0, 1]")
+	}
+
+	s := c.Scoring
+	if s.DeclineThreshold <= 0 || s.DeclineThreshold > 1 {
+		fail("DECLINE_THRESHOLD must be in (0, 1]")
+	}
+	if s.ReviewThreshold <= 0 || s.ReviewThreshold > s.DeclineThreshold {
+		fail("REVIEW_THRESHOLD must be in (0, DECLINE_THRESHOLD]")
+	}
+	if s.LikelihoodThreshold <= 0 || s.LikelihoodThreshold > 1 {
+		fail("LIKELIHOOD_THRESHOLD must be in (0, 1]")
+	}
+	if s.MinHmmHistory < 1 {
+		fail("MIN_HMM_HISTORY must be at least 1")
+	}
+	if s.PrepaidWeight < 0 || s.PrepaidWeight > 1 {
+		fail("PREPAID_WEIGHT must be in [0, 1]")
+	}
+	if s.CountryMismatchWeight < 0 || s.CountryMismatchWeight > 1 {
+		fail("COUNTRY_MISMATCH_WEIGHT must be in [0, 1]")
+	}
+
+	if c.Profiles.RetrainAfter < 1 {
+		fail("PROFILE_RETRAIN_AFTER must be at least 1")
+	}
+	if c.Profiles.MaxAge <= 0 {
+		fail("PROFILE_MAX_AGE must be positive")
+	}
+	if c.Profiles.Workers < 1 {
+		fail("PROFILE_WORKERS must be at least 1")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+
+	return nil
+}
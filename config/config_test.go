@@ -0,0 +1,120 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setRequired(t *testing.T) {
+	t.Setenv("ENV", EnvProduction)
+	t.Setenv("MONGO_URL", "mongodb://localhost:27017")
+	t.Setenv("MONGO_DATABASE", "fraudis")
+	t.Setenv("APP_URL", "https://dashboard.example.com")
+	t.Setenv("ML_SERVER_URL", "http://localhost:5000/")
+	t.Setenv("TOKEN_SYMMETRIC_KEY", "0123456789abcdef0123456789abcdef")
+	t.Setenv("CARD_FINGERPRINT_KEY", "fingerprint key, exactly 32 long")
+	t.Setenv("CARD_ENCRYPTION_KEY", "encryption key,  exactly 32 long")
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setRequired(t)
+
+	config, err := Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, "3000", config.Port)
+	assert.Equal(t, "fraudis", config.Mongo.Database)
+	assert.Equal(t, 0.2, config.Scoring.DeclineThreshold)
+	assert.Equal(t, 20, config.Scoring.MinHmmHistory)
+	assert.False(t, config.DevMode)
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	setRequired(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("port: \"4000\"\nscoring:\n  decline_threshold: 0.5\n  review_threshold: 0.3\n"), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("REVIEW_THRESHOLD", "0.25")
+	t.Setenv("ML_SERVER_TIMEOUT", "3s")
+	t.Setenv("BASIC_AUTH_USERS", "ops:secret, admin:hunter2")
+
+	config, err := Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, "4000", config.Port)
+	assert.Equal(t, 0.5, config.Scoring.DeclineThreshold)
+	assert.Equal(t, 0.25, config.Scoring.ReviewThreshold)
+	assert.Equal(t, 3*time.Second, config.MLServer.Timeout)
+	assert.Equal(t, map[string]string{"ops": "secret", "admin": "hunter2"}, config.BasicAuthUsers)
+}
+
+func TestLoadRejectsBadSettings(t *testing.T) {
+	setRequired(t)
+	t.Setenv("TOKEN_SYMMETRIC_KEY", "too short")
+	t.Setenv("REVIEW_THRESHOLD", "0.9")
+	t.Setenv("MONGO_URL", "")
+	t.Setenv("PREPAID_WEIGHT", "-0.1")
+	t.Setenv("PROFILE_WORKERS", "0")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, proxy.internal")
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TOKEN_SYMMETRIC_KEY")
+	assert.Contains(t, err.Error(), "REVIEW_THRESHOLD")
+	assert.Contains(t, err.Error(), "MONGO_URL")
+	assert.Contains(t, err.Error(), "PREPAID_WEIGHT")
+	assert.Contains(t, err.Error(), "PROFILE_WORKERS")
+	assert.Contains(t, err.Error(), `TRUSTED_PROXIES: "proxy.internal"`)
+	assert.NotContains(t, err.Error(), "10.0.0.0/8")
+}
+
+func TestDevelopmentDefaultsOnlyInDevelopment(t *testing.T) {
+	setRequired(t)
+	t.Setenv("TOKEN_SYMMETRIC_KEY", "")
+	t.Setenv("CARD_FINGERPRINT_KEY", "")
+	t.Setenv("CARD_ENCRYPTION_KEY", "")
+	t.Setenv("MONGO_DATABASE", "")
+	t.Setenv("APP_URL", "")
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MONGO_DATABASE is required")
+	assert.Contains(t, err.Error(), "APP_URL must be an absolute url")
+
+	t.Setenv("ENV", EnvDevelopment)
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, devMongoDatabase, config.Mongo.Database)
+	assert.Equal(t, devAppUrl, config.AppUrl)
+	assert.Len(t, config.Token.SymmetricKey, 32)
+	assert.Len(t, config.Cards.FingerprintKey, 32)
+	assert.Len(t, config.Cards.EncryptionKey, 32)
+
+	t.Setenv("ENV", EnvProduction)
+	t.Setenv("TOKEN_SYMMETRIC_KEY", "0123456789abcdef0123456789abcdef")
+	t.Setenv("CARD_ENCRYPTION_KEY", devCardEncryptionKey)
+	_, err = Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "CARD_ENCRYPTION_KEY")
+}
+
+func TestAppUrlMustBeAbsolute(t *testing.T) {
+	setRequired(t)
+
+	for _, appUrl := range []string{"", "/dashboard", "dashboard.example.com"} {
+		t.Setenv("APP_URL", appUrl)
+		_, err := Load()
+		require.Error(t, err, appUrl)
+		assert.Contains(t, err.Error(), "APP_URL must be an absolute url")
+	}
+
+	t.Setenv("APP_URL", "https://dashboard.example.com/")
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "https://dashboard.example.com/", config.AppUrl)
+}
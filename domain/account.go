@@ -3,17 +3,29 @@ package domain
 import (
 	"fraud-detect-system/domain/markov"
 	"github.com/kamva/mgm/v3"
+	"time"
 )
 
 type Account struct {
 	mgm.DefaultModel `bson:",inline"`
-	CardNum          string `bson:"credit_card" json:"credit_card"` // TODO: make unique
+	Card             Card   `bson:"card" json:"card"` // one account per card fingerprint
 	CardHolder       string `bson:"card_holder" json:"card_holder"`
 
+	// the spending habits and hmm the profile jobs trained, see Profile
 	markov.HMM `bson:"markov_._hmm" json:"markov.HMM"`
+	Profile    Profile `bson:"profile" json:"profile"`
 }
 
-// ClassifyTransaction TODO: REMOVE THIS
+// Profile says what the account's HMM and spending habits were trained on. Scoring
+// only uses them once Trained, which takes enough valid transactions.
+type Profile struct {
+	Trained      bool      `bson:"trained" json:"trained"`
+	Transactions int       `bson:"transactions" json:"transactions"` // valid transactions it was worked out from
+	ProfiledAt   time.Time `bson:"profiled_at,omitempty" json:"profiled_at,omitempty"`
+}
+
+// ClassifyTransaction is the hmm symbol of amount: which of the spending habits, low,
+// average or high, it falls in.
 func (a *Account) ClassifyTransaction(amount float64) int {
 	switch {
 	case amount >= a.SpendingHabits[2]:
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	AlertModeInstant = "instant" // one email per declined or reviewed transaction
+	AlertModeDigest  = "digest"  // one hourly summary
+	AlertModeOff     = "off"
+)
+
+// AlertSettings says who hears about a merchant's risky transactions, and how often.
+type AlertSettings struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string   `bson:"merchant" json:"merchant"`
+	Recipients       []string `bson:"recipients" json:"recipients"`
+	Mode             string   `bson:"mode" json:"mode"`
+	Decisions        []string `bson:"decisions" json:"decisions"` // which of decline and review to alert on
+}
+
+func (a *AlertSettings) AlertsOn(decision string) bool {
+	if a.Mode == AlertModeOff {
+		return false
+	}
+
+	for _, d := range a.Decisions {
+		if d == decision {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PendingAlert is a transaction waiting to go out in a merchant's next digest.
+type PendingAlert struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string    `bson:"merchant" json:"merchant"`
+	Transaction      string    `bson:"transaction" json:"transaction"`
+	Decision         string    `bson:"decision" json:"decision"`
+	Amount           float64   `bson:"amount" json:"amount"`
+	Email            string    `bson:"email" json:"email"`
+	Ip               string    `bson:"ip_address" json:"ip_address"`
+	RiskScore        float64   `bson:"risk_score" json:"risk_score"`
+	OccurredAt       time.Time `bson:"occurred_at" json:"occurred_at"`
+}
+
+func NewPendingAlert(transaction Transaction) PendingAlert {
+	return PendingAlert{
+		Merchant:    transaction.Merchant,
+		Transaction: transaction.ID.Hex(),
+		Decision:    transaction.Decision,
+		Amount:      transaction.Amt,
+		Email:       transaction.Email,
+		Ip:          transaction.Ip,
+		RiskScore:   transaction.RiskScore,
+		OccurredAt:  transaction.CreatedAt,
+	}
+}
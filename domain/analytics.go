@@ -0,0 +1,180 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+	GranularityWeek = "week"
+)
+
+// a chart with more points than this isn't readable anyway
+const MaxAnalyticsBuckets = 1000
+
+var (
+	ErrInvalidGranularity = errors.New("granularity must be hour, day or week")
+	ErrInvalidRange       = errors.New("range must end after it starts")
+	ErrTooManyBuckets     = errors.New("range is too long for the granularity")
+)
+
+// AnalyticsQuery selects a merchant's transactions created in [From, To) and groups
+// them by the hour, day or week they fall in, in Location. Weeks start on Monday.
+type AnalyticsQuery struct {
+	Merchant    string
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Location    *time.Location
+}
+
+// AnalyticsBucket is the totals for one period. Repositories only fill in the sums;
+// the averages and rates come from NewAnalytics.
+type AnalyticsBucket struct {
+	Start         time.Time `json:"start"`
+	Count         int       `json:"count"`
+	Blocked       int       `json:"blocked"`
+	Volume        float64   `json:"volume"`
+	VolumeBlocked float64   `json:"volume_blocked"`
+	BlockRate     float64   `json:"block_rate"` // percent of count
+	AvgRisk       float64   `json:"avg_risk"`
+
+	RiskScoreTotal float64 `json:"-"`
+}
+
+// Analytics is a range's totals and its series, one bucket per period with the empty
+// ones filled in.
+type Analytics struct {
+	Summary AnalyticsBucket   `json:"summary"`
+	Series  []AnalyticsBucket `json:"series"`
+}
+
+// Validate checks the range will make a sensible chart.
+func (q *AnalyticsQuery) Validate() error {
+	switch q.Granularity {
+	case GranularityHour, GranularityDay, GranularityWeek:
+	default:
+		return ErrInvalidGranularity
+	}
+
+	if !q.To.After(q.From) {
+		return ErrInvalidRange
+	}
+
+	if q.Periods() > MaxAnalyticsBuckets {
+		return ErrTooManyBuckets
+	}
+
+	return nil
+}
+
+// Zone is Location, UTC when it isn't set.
+func (q *AnalyticsQuery) Zone() *time.Location {
+	if q.Location == nil {
+		return time.UTC
+	}
+
+	return q.Location
+}
+
+// Truncate is the start of the period t falls in.
+func (q *AnalyticsQuery) Truncate(t time.Time) time.Time {
+	loc := q.Zone()
+	t = t.In(loc)
+
+	switch q.Granularity {
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case GranularityWeek:
+		monday := t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+		return time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// Next is the start of the period after the one starting at start.
+func (q *AnalyticsQuery) Next(start time.Time) time.Time {
+	switch q.Granularity {
+	case GranularityHour:
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Periods counts the periods the range touches.
+func (q *AnalyticsQuery) Periods() int {
+	var span time.Duration
+	switch q.Granularity {
+	case GranularityHour:
+		span = time.Hour
+	case GranularityWeek:
+		span = 7 * 24 * time.Hour
+	default:
+		span = 24 * time.Hour
+	}
+
+	// DST can make a period an hour short, round up rather than walk the range
+	return int(q.To.Sub(q.Truncate(q.From))/span) + 1
+}
+
+// Add counts one transaction in the bucket.
+func (b *AnalyticsBucket) Add(tx Transaction) {
+	b.Count++
+	b.Volume += tx.Amt
+	b.RiskScoreTotal += tx.RiskScore
+
+	if tx.IsFraud {
+		b.Blocked++
+		b.VolumeBlocked += tx.Amt
+	}
+}
+
+func (b *AnalyticsBucket) merge(other AnalyticsBucket) {
+	b.Count += other.Count
+	b.Blocked += other.Blocked
+	b.Volume += other.Volume
+	b.VolumeBlocked += other.VolumeBlocked
+	b.RiskScoreTotal += other.RiskScoreTotal
+}
+
+func (b *AnalyticsBucket) finish() {
+	if b.Count == 0 {
+		return
+	}
+
+	b.BlockRate = float64(b.Blocked) / float64(b.Count) * 100
+	b.AvgRisk = b.RiskScoreTotal / float64(b.Count)
+}
+
+// NewAnalytics lays the buckets a repository found out over every period of the range
+// and totals them.
+func NewAnalytics(q AnalyticsQuery, found []AnalyticsBucket) Analytics {
+	byStart := make(map[int64]AnalyticsBucket, len(found))
+	for _, b := range found {
+		byStart[b.Start.Unix()] = b
+	}
+
+	a := Analytics{
+		Summary: AnalyticsBucket{Start: q.From},
+		Series:  make([]AnalyticsBucket, 0, q.Periods()),
+	}
+
+	for start := q.Truncate(q.From); start.Before(q.To); start = q.Next(start) {
+		b := byStart[start.Unix()]
+		b.Start = start
+		b.finish()
+
+		a.Summary.merge(b)
+		a.Series = append(a.Series, b)
+	}
+
+	a.Summary.finish()
+
+	return a
+}
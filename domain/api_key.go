@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	ApiKeyModeTest = "test"
+	ApiKeyModeLive = "live"
+)
+
+var ErrInvalidApiKeyMode = errors.New("api key mode must be test or live")
+
+// ApiKey is a secret a merchant's servers authenticate with. Only the hash is
+// stored; the full key is returned once when it is created.
+type ApiKey struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string    `bson:"merchant" json:"merchant"`
+	Name             string    `bson:"name" json:"name"`
+	Mode             string    `bson:"mode" json:"mode"`
+	Prefix           string    `bson:"prefix" json:"prefix"` // enough of the key to recognise it in a list
+	KeyHash          string    `bson:"key_hash" json:"-"`
+	LastUsedAt       time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt        time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	ExpiresAt        time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // set while a rotated key is in its grace period
+	RotatedTo        string    `bson:"rotated_to,omitempty" json:"rotated_to,omitempty"`
+}
+
+// NewApiKey generates a key like sk_live_<64 hex chars> and returns it alongside the
+// record to store.
+func NewApiKey(merchant, name, mode string) (ApiKey, string, error) {
+	if mode != ApiKeyModeTest && mode != ApiKeyModeLive {
+		return ApiKey{}, "", ErrInvalidApiKeyMode
+	}
+
+	r := make([]byte, 32)
+	if _, err := rand.Read(r); err != nil {
+		return ApiKey{}, "", err
+	}
+
+	key := "sk_" + mode + "_" + hex.EncodeToString(r)
+
+	return ApiKey{
+		Merchant: merchant,
+		Name:     name,
+		Mode:     mode,
+		Prefix:   key[:len("sk_"+mode+"_")+6],
+		KeyHash:  HashSecret(key),
+	}, key, nil
+}
+
+// HashSecret is a plain sha256: api keys are 256 random bits, so unlike passwords
+// they need no salt or work factor, and the hash can be looked up directly.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (k *ApiKey) Active(now time.Time) bool {
+	if !k.RevokedAt.IsZero() {
+		return false
+	}
+
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+func (k *ApiKey) Livemode() bool {
+	return k.Mode == ApiKeyModeLive
+}
@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// Card types, as kept in Transaction.CardType.
+const (
+	CardTypeUnknown = iota
+	CardTypeCredit
+	CardTypeDebit
+	CardTypePrepaid
+)
+
+var (
+	ErrInvalidExpiry = errors.New("expiry month must be 1 to 12 and the year at most 20 years away")
+	ErrCardExpired   = errors.New("card has expired")
+)
+
+// Card is what is kept of a card number. Fingerprint is a keyed hash of the number,
+// the same for every use of the card, so transactions and accounts can be looked up
+// by card without storing it. Only accounts keep the number, encrypted; the api only
+// ever shows the bin and the last 4 digits.
+//
+// Scheme, Country and Issuer are what the bin says about the card, empty when it is
+// not in the bin table.
+type Card struct {
+	Fingerprint string `bson:"fingerprint" json:"-"`
+	Bin         string `bson:"bin" json:"bin"`
+	Last4       string `bson:"last4" json:"last4"`
+	Encrypted   string `bson:"encrypted,omitempty" json:"-"`
+
+	Scheme  string `bson:"scheme,omitempty" json:"scheme,omitempty"`
+	Country string `bson:"country,omitempty" json:"country,omitempty"` // ISO 3166-1 alpha-2
+	Issuer  string `bson:"issuer,omitempty" json:"issuer,omitempty"`
+}
+
+// WithoutNumber is the card minus its encrypted number, for records that only need
+// to refer to it.
+func (c Card) WithoutNumber() Card {
+	c.Encrypted = ""
+	return c
+}
+
+// CheckExpiry checks a card expiring at the end of month/year is still valid at now.
+// Two digit years are taken to be this century's.
+func CheckExpiry(month int, year int, now time.Time) error {
+	if year < 100 {
+		year += 2000
+	}
+
+	now = now.UTC()
+	if month < 1 || month > 12 || year > now.Year()+20 {
+		return ErrInvalidExpiry
+	}
+
+	// valid up to and including the last day of the month
+	if !now.Before(time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)) {
+		return ErrCardExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2024, time.March, 31, 23, 59, 0, 0, time.UTC)
+
+	assert.NoError(t, CheckExpiry(3, 2024, now))
+	assert.NoError(t, CheckExpiry(3, 24, now))
+	assert.NoError(t, CheckExpiry(12, 2030, now))
+
+	assert.ErrorIs(t, CheckExpiry(2, 2024, now), ErrCardExpired)
+	assert.ErrorIs(t, CheckExpiry(3, 2024, now.Add(time.Minute)), ErrCardExpired)
+	assert.ErrorIs(t, CheckExpiry(12, 23, now), ErrCardExpired)
+
+	assert.ErrorIs(t, CheckExpiry(13, 2025, now), ErrInvalidExpiry)
+	assert.ErrorIs(t, CheckExpiry(0, 2025, now), ErrInvalidExpiry)
+	assert.ErrorIs(t, CheckExpiry(1, 2050, now), ErrInvalidExpiry)
+}
@@ -1,34 +1,28 @@
 package clustering
 
 import (
+	"errors"
 	"fraud-detect-system/domain"
-	"fraud-detect-system/domain/markov"
-	"fraud-detect-system/domain/ports"
-	"github.com/gofiber/fiber/v2"
 	"github.com/muesli/clusters"
 	"github.com/muesli/kmeans"
 	"sort"
 )
 
-var (
-	ErrCannotCluster = "no valid for clustering yet"
-)
-
-func ClusterTransactions(account domain.Account, transactionRepository ports.TransactionRepository, accountRepository ports.AccountRepository) error {
-	// need to get amounts []float64
+// the spending habits: low, average and high
+const numOfHabits = 3
 
-	transactions, err := transactionRepository.GetAllValidTransactionWhereAccountIs(account.CardNum)
-	if err != nil {
-		return err
-	}
+var ErrCannotCluster = errors.New("not enough transactions to cluster")
 
-	if len(transactions) < 20 {
-		return fiber.NewError(fiber.StatusOK, ErrCannotCluster)
+// ClusterTransactions finds the account's spending habits, the centers of its
+// transactions' amounts clustered into low, average and high spending, in that order.
+func ClusterTransactions(transactions []domain.Transaction) (map[int]float64, error) {
+	if len(transactions) < numOfHabits {
+		return nil, ErrCannotCluster
 	}
 
 	var d clusters.Observations
 
-	for _, amount := range transform(transactions[:len(transactions)-markov.NumOfObservations]) {
+	for _, amount := range transform(transactions) {
 		d = append(d, clusters.Coordinates{
 			amount,
 			1.0, // till i find what else to weigh against amount
@@ -36,9 +30,9 @@ func ClusterTransactions(account domain.Account, transactionRepository ports.Tra
 	}
 
 	km := kmeans.New()
-	clusters, err := km.Partition(d, 3)
+	clusters, err := km.Partition(d, numOfHabits)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var corePoints []float64
@@ -50,13 +44,12 @@ func ClusterTransactions(account domain.Account, transactionRepository ports.Tra
 	//sort cluster points
 	sort.Float64s(corePoints)
 
-	account.SpendingHabits[0] = corePoints[0] // TODO: make use of enum constant low, avg & high
-	account.SpendingHabits[1] = corePoints[1]
-	account.SpendingHabits[2] = corePoints[2]
-
-	accountRepository.Save(&account)
+	habits := make(map[int]float64, numOfHabits)
+	for i, point := range corePoints {
+		habits[i] = point
+	}
 
-	return nil
+	return habits, nil
 }
 
 func transform(txs []domain.Transaction) (amounts []float64) {
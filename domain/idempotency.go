@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+// IdempotentRequest is a request an api client sent with an Idempotency-Key. While it
+// is running it holds the key so a concurrent retry can't run it twice; once done it
+// keeps the response to replay to later retries until it expires.
+type IdempotentRequest struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string `bson:"merchant"`
+	Livemode         bool   `bson:"livemode"`
+	Key              string `bson:"key"`
+	// RequestHash tells a retry from a different request reusing the key
+	RequestHash string `bson:"request_hash"`
+
+	Done        bool   `bson:"done"`
+	Status      int    `bson:"status,omitempty"`
+	ContentType string `bson:"content_type,omitempty"`
+	Body        []byte `bson:"body,omitempty"`
+
+	ExpiresAt time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,20 @@
+package domain
+
+import "github.com/kamva/mgm/v3"
+
+const (
+	LoginFailedPassword  = "invalid_credentials"
+	LoginFailedTwoFactor = "invalid_2fa_code"
+	LoginFailedLocked    = "locked"
+	LoginFailedThrottle  = "throttled"
+)
+
+// LoginAttempt is the audit record of a failed dashboard login.
+type LoginAttempt struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string `bson:"merchant,omitempty" json:"merchant,omitempty"`
+	Email            string `bson:"email" json:"email"`
+	Ip               string `bson:"ip_address" json:"ip_address"`
+	UserAgent        string `bson:"user_agent" json:"user_agent"`
+	Reason           string `bson:"reason" json:"reason"`
+}
@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+// LoginCounter counts the recent failed logins of one email or ip. It is stored
+// rather than kept in memory so every instance sees the same count.
+type LoginCounter struct {
+	mgm.DefaultModel `bson:",inline"`
+	Key              string    `bson:"key"` // "email:<address>" or "ip:<address>"
+	Failures         int       `bson:"failures"`
+	LastFailure      time.Time `bson:"last_failure"`
+	LockedUntil      time.Time `bson:"locked_until,omitempty"`
+
+	// the later of the end of the failure window and the end of the lockout, after
+	// which the counter is gone
+	ExpiresAt time.Time `bson:"expires_at"`
+}
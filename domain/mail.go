@@ -2,6 +2,7 @@ package domain
 
 type Mail struct {
 	Subject string `json:"subject"`
-	Message string `json:"message"`
+	Message string `json:"message"`        // plain text body
+	Html    string `json:"html,omitempty"` // optional html alternative of Message
 	To      string `json:"to"`
 }
@@ -1,7 +1,6 @@
 package markov
 
 import (
-	"fmt"
 	"math"
 	"math/rand"
 	"time"
@@ -105,7 +104,6 @@ func (h *HMM) Fit(obs []int) {
 
 	h.OldLogProb = math.Inf(-1)
 	for h.next(&iter) {
-		fmt.Println(iter)
 		h.estimate(obs, gamma, psi)
 	}
 
@@ -294,9 +292,6 @@ func (h *HMM) next(iter *int) bool {
 func (h *HMM) DetectFraud(obs []int) (isFraud bool, maxProb float64, avgLikelihood float64) {
 	viterbiSeq, _ := h.viterbi(obs)
 
-	fmt.Println("obs: ", obs)
-	fmt.Println("viberti sequence: ", viterbiSeq)
-
 	T := len(viterbiSeq)
 
 	// calculate the percentage of the seq that are 1, if more than 50% (0.50) likely fraud
@@ -320,8 +315,6 @@ func (h *HMM) DetectFraud(obs []int) (isFraud bool, maxProb float64, avgLikeliho
 
 	avgLikelihood = p
 
-	fmt.Println("*** ", h.LogLikelihood(viterbiSeq))
-
 	return
 }
 
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"github.com/kamva/mgm/v3"
 	"golang.org/x/crypto/bcrypt"
@@ -9,23 +10,30 @@ import (
 )
 
 type Merchant struct {
-	mgm.DefaultModel `bson:",inline"`
-	Name             string    `bson:"name" json:"name" validate:"required"`
-	Email            string    `bson:"email" json:"email" validate:"required,email"`
-	Secret           string    `bson:"secret" json:"secret"` // TODO: don't add this
-	Theme            string    `bson:"theme" json:"theme"`   // Not a string tho
-	Password         string    `bson:"password" json:"password,omitempty" validate:"required"`
-	ConfirmPassword  string    `bson:"confirm_password" json:"confirm_password,omitempty" validate:"required"`
-	WebsiteUrl       string    `bson:"website_url" json:"website_url" validate:"required"`
-	LastLoggedIn     time.Time `bson:"last_logged_in" json:"last_logged_in"`
-	SiteInformation  string    `bson:"site_information" json:"site_information"`
+	mgm.DefaultModel  `bson:",inline"`
+	Name              string    `bson:"name" json:"name"`
+	Email             string    `bson:"email" json:"email"`
+	Theme             string    `bson:"theme" json:"theme"` // Not a string tho
+	Password          string    `bson:"password" json:"-"`
+	WebsiteUrl        string    `bson:"website_url" json:"website_url"`
+	LastLoggedIn      time.Time `bson:"last_logged_in" json:"last_logged_in"`
+	SiteInformation   string    `bson:"site_information" json:"site_information"`
+	EmailVerified     bool      `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt   time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	PasswordChangedAt time.Time `bson:"password_changed_at" json:"-"`
+
+	TwoFactorEnabled       bool     `bson:"two_factor_enabled" json:"two_factor_enabled"`
+	TwoFactorSecret        string   `bson:"two_factor_secret,omitempty" json:"-"`
+	PendingTwoFactorSecret string   `bson:"pending_two_factor_secret,omitempty" json:"-"`
+	TwoFactorLastStep      int64    `bson:"two_factor_last_step,omitempty" json:"-"`
+	RecoveryCodes          []string `bson:"recovery_codes,omitempty" json:"-"` // sha256 hashes, each usable once
 }
 
 func (m *Merchant) Creating(c context.Context) error {
 	// hashed password
-	hashedBytes, _ := bcrypt.GenerateFromPassword([]byte(m.Password), 10)
-	m.Password = string(hashedBytes)
-	m.ConfirmPassword = ""
+	if err := m.SetPassword(m.Password); err != nil {
+		return err
+	}
 
 	m.CreatedAt = time.Now().UTC()
 	m.UpdatedAt = time.Now().UTC()
@@ -46,3 +54,80 @@ func (m *Merchant) PasswordMatches(password string) error {
 
 	return nil
 }
+
+// PublicMerchant is the only shape of a merchant that leaves the API: no password
+// hash, no 2fa material.
+type PublicMerchant struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Theme            string    `json:"theme"`
+	WebsiteUrl       string    `json:"website_url"`
+	SiteInformation  string    `json:"site_information"`
+	EmailVerified    bool      `json:"email_verified"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	LastLoggedIn     time.Time `json:"last_logged_in"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func (m *Merchant) Public() PublicMerchant {
+	return PublicMerchant{
+		ID:               m.ID.Hex(),
+		Name:             m.Name,
+		Email:            m.Email,
+		Theme:            m.Theme,
+		WebsiteUrl:       m.WebsiteUrl,
+		SiteInformation:  m.SiteInformation,
+		EmailVerified:    m.EmailVerified,
+		TwoFactorEnabled: m.TwoFactorEnabled,
+		LastLoggedIn:     m.LastLoggedIn,
+		CreatedAt:        m.CreatedAt,
+		UpdatedAt:        m.UpdatedAt,
+	}
+}
+
+// SetPassword hashes password onto the merchant and records when it changed,
+// so anything issued before the change (sessions, reset links) can be rejected.
+func (m *Merchant) SetPassword(password string) error {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return err
+	}
+
+	m.Password = string(hashedBytes)
+	m.PasswordChangedAt = time.Now().UTC()
+
+	return nil
+}
+
+// IssuedBeforePasswordChange reports whether something issued at t predates the
+// merchant's last password change.
+func (m *Merchant) IssuedBeforePasswordChange(t time.Time) bool {
+	return !m.PasswordChangedAt.IsZero() && t.Before(m.PasswordChangedAt)
+}
+
+func (m *Merchant) VerifyEmail() {
+	m.EmailVerified = true
+	m.EmailVerifiedAt = time.Now().UTC()
+}
+
+// UseRecoveryCode consumes the recovery code matching hash, reporting whether one did.
+func (m *Merchant) UseRecoveryCode(hash string) bool {
+	for i, code := range m.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(code), []byte(hash)) == 1 {
+			m.RecoveryCodes = append(m.RecoveryCodes[:i], m.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Merchant) DisableTwoFactor() {
+	m.TwoFactorEnabled = false
+	m.TwoFactorSecret = ""
+	m.PendingTwoFactorSecret = ""
+	m.TwoFactorLastStep = 0
+	m.RecoveryCodes = nil
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestPublicMerchantLeavesOutSecrets(t *testing.T) {
+	m := Merchant{
+		Name:                   "Shop",
+		Email:                  "owner@shop.example",
+		Password:               "$2a$10$hash",
+		TwoFactorEnabled:       true,
+		TwoFactorSecret:        "JBSWY3DPEHPK3PXP",
+		PendingTwoFactorSecret: "KRSXG5CTMVRXEZLU",
+		RecoveryCodes:          []string{"code hash"},
+	}
+	m.ID = primitive.NewObjectID()
+
+	body, err := json.Marshal(m.Public())
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `"id":"`+m.ID.Hex()+`"`)
+	assert.Contains(t, string(body), `"two_factor_enabled":true`)
+	for _, secret := range []string{"$2a$10$hash", "JBSWY3DPEHPK3PXP", "KRSXG5CTMVRXEZLU", "code hash", "password"} {
+		assert.NotContains(t, string(body), secret)
+	}
+}
@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	NotificationHighRisk      = "transaction.high_risk"
+	NotificationReview        = "transaction.review"
+	NotificationWebhookFailed = "webhook.failed"
+	NotificationModelDegraded = "model.degraded"
+)
+
+// Notification is an entry in a merchant's dashboard inbox. It stays unread until the
+// merchant marks it read, however often they open the dashboard.
+type Notification struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string    `bson:"merchant" json:"merchant"`
+	Type             string    `bson:"type" json:"type"`
+	Title            string    `bson:"title" json:"title"`
+	Message          string    `bson:"message" json:"message"`
+	Transaction      string    `bson:"transaction,omitempty" json:"transaction,omitempty"`
+	WebhookEndpoint  string    `bson:"webhook_endpoint,omitempty" json:"webhook_endpoint,omitempty"`
+	Read             bool      `bson:"read" json:"read"`
+	ReadAt           time.Time `bson:"read_at,omitempty" json:"read_at,omitempty"`
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // gave up after the last retry, kept for inspection
+)
+
+// OutboxMail is an email waiting to be sent, or the record of having tried.
+type OutboxMail struct {
+	mgm.DefaultModel `bson:",inline"`
+	Mail             `bson:"mail" json:"mail"`
+	Status           string    `bson:"status" json:"status"`
+	Attempts         int       `bson:"attempts" json:"attempts"`
+	NextAttemptAt    time.Time `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastError        string    `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	SentAt           time.Time `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+}
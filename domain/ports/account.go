@@ -1,9 +1,17 @@
 package ports
 
-import "fraud-detect-system/domain"
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"time"
+)
 
 type AccountRepository interface {
-	Get(creditCard string) (domain.Account, error)
-	Create(account domain.Account) (domain.Account, error)
-	Save(account *domain.Account) error
+	// Get finds the account of the card with the given fingerprint.
+	Get(ctx context.Context, fingerprint string) (domain.Account, error)
+	Create(ctx context.Context, account domain.Account) (domain.Account, error)
+	Save(ctx context.Context, account *domain.Account) error
+	// GetProfiledBefore returns up to limit accounts never profiled or last profiled
+	// before the given time, the longest waiting first.
+	GetProfiledBefore(ctx context.Context, before time.Time, limit int) ([]domain.Account, error)
 }
@@ -0,0 +1,11 @@
+package ports
+
+import "fraud-detect-system/domain"
+
+type AlertRepository interface {
+	GetSettings(merchant string) (domain.AlertSettings, error)
+	SaveSettings(settings *domain.AlertSettings) error
+	AddPending(alert domain.PendingAlert) (domain.PendingAlert, error)
+	GetAllPending() ([]domain.PendingAlert, error)
+	DeletePending(alerts []domain.PendingAlert) error
+}
@@ -0,0 +1,12 @@
+package ports
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+)
+
+type AnalyticsRepository interface {
+	// Aggregate totals the query's transactions per period. It returns only the
+	// periods that have any, oldest first; domain.NewAnalytics fills in the rest.
+	Aggregate(ctx context.Context, query domain.AnalyticsQuery) ([]domain.AnalyticsBucket, error)
+}
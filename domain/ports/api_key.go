@@ -0,0 +1,15 @@
+package ports
+
+import (
+	"fraud-detect-system/domain"
+	"time"
+)
+
+type ApiKeyRepository interface {
+	Add(key domain.ApiKey) (domain.ApiKey, error)
+	GetById(merchant string, id string) (domain.ApiKey, error)
+	GetByHash(keyHash string) (domain.ApiKey, error)
+	GetAllWhereMerchantIs(merchant string) ([]domain.ApiKey, error)
+	Save(key *domain.ApiKey) error
+	TouchLastUsed(id string, at time.Time) error
+}
@@ -0,0 +1,16 @@
+package ports
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+)
+
+type IdempotencyRepository interface {
+	// Begin stores request unless an unexpired request with the same merchant, mode and
+	// key is stored, in which case it returns that one and false.
+	Begin(ctx context.Context, request domain.IdempotentRequest) (domain.IdempotentRequest, bool, error)
+	// Complete saves the response of a request Begin stored.
+	Complete(ctx context.Context, request *domain.IdempotentRequest) error
+	// Release deletes a request Begin stored, so the key can be used again.
+	Release(ctx context.Context, request domain.IdempotentRequest) error
+}
@@ -0,0 +1,8 @@
+package ports
+
+import "fraud-detect-system/domain"
+
+type LoginAttemptRepository interface {
+	Add(attempt domain.LoginAttempt) (domain.LoginAttempt, error)
+	GetAllWhereMerchantIs(merchant string) ([]domain.LoginAttempt, error)
+}
@@ -0,0 +1,20 @@
+package ports
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"time"
+)
+
+// LoginCounterRepository keeps the failed login counters by key. A counter past its
+// ExpiresAt is as good as gone.
+type LoginCounterRepository interface {
+	// Get returns the counter for key, unless there is none or it expired by now.
+	Get(ctx context.Context, key string, now time.Time) (domain.LoginCounter, bool, error)
+	// AddFailure counts a failure at now in one step, starting over if the counter
+	// expired, and keeps the counter for at least window after now.
+	AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (domain.LoginCounter, error)
+	// Lock locks key until then and starts its failures over.
+	Lock(ctx context.Context, key string, until time.Time) error
+	Delete(ctx context.Context, key string) error
+}
@@ -1,13 +1,13 @@
 package ports
 
 import (
+	"context"
 	"fraud-detect-system/domain"
 )
 
 type MerchantRepository interface {
-	Add(newMerchant *domain.Merchant) (domain.Merchant, error)
-	GetById(id string) (domain.Merchant, error)
-	GetByIdAndSecret(id, secret string) (domain.Merchant, error)
-	GetByEmail(email string) (domain.Merchant, error)
-	Save(merchant *domain.Merchant) error
+	Add(ctx context.Context, newMerchant *domain.Merchant) (domain.Merchant, error)
+	GetById(ctx context.Context, id string) (domain.Merchant, error)
+	GetByEmail(ctx context.Context, email string) (domain.Merchant, error)
+	Save(ctx context.Context, merchant *domain.Merchant) error
 }
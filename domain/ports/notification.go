@@ -0,0 +1,21 @@
+package ports
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+)
+
+type NotificationRepository interface {
+	Add(ctx context.Context, notification domain.Notification) (domain.Notification, error)
+	// GetAllWhereMerchantIs returns up to limit notifications, newest first, older than
+	// the one with id before when it is set.
+	GetAllWhereMerchantIs(ctx context.Context, merchant string, unreadOnly bool, before string, limit int) ([]domain.Notification, error)
+	CountUnread(ctx context.Context, merchant string) (int, error)
+	// MarkRead marks the given notifications read, or all of them when ids is empty.
+	MarkRead(ctx context.Context, merchant string, ids []string) error
+}
+
+// Notifier puts a notification in the merchant's inbox.
+type Notifier interface {
+	Notify(ctx context.Context, notification domain.Notification) error
+}
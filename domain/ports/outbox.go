@@ -0,0 +1,14 @@
+package ports
+
+import (
+	"fraud-detect-system/domain"
+	"time"
+)
+
+type OutboxRepository interface {
+	Add(mail domain.OutboxMail) (domain.OutboxMail, error)
+	Save(mail *domain.OutboxMail) error
+	// ClaimDue leases the oldest pending mail due at now, pushing its next attempt
+	// back by lease so no other worker sends it meanwhile.
+	ClaimDue(now time.Time, lease time.Duration) (domain.OutboxMail, bool, error)
+}
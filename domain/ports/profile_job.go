@@ -0,0 +1,26 @@
+package ports
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"time"
+)
+
+type ProfileJobRepository interface {
+	// Enqueue adds job unless its account already has a pending job, and reports
+	// whether it did.
+	Enqueue(ctx context.Context, job domain.ProfileJob) (bool, error)
+	// ClaimDue leases the job due at now, see domain.ProfileJob, that has waited
+	// longest, marking it running until now+lease.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (domain.ProfileJob, bool, error)
+	Save(ctx context.Context, job *domain.ProfileJob) error
+	// Find returns up to limit jobs, newest first, only those with status if it is set.
+	Find(ctx context.Context, status string, limit int) ([]domain.ProfileJob, error)
+	// CountByStatus counts the jobs of each status there are any of.
+	CountByStatus(ctx context.Context) (map[string]int, error)
+}
+
+// Profiler works out an account's profile from its history and saves it.
+type Profiler interface {
+	TrainProfile(ctx context.Context, account *domain.Account, minHistory int) error
+}
@@ -1,23 +1,22 @@
 package ports
 
 import (
+	"context"
 	"fraud-detect-system/domain"
-	"time"
 )
 
 type TransactionRepository interface {
-	Add(newTransaction domain.Transaction) (domain.Transaction, error)
-	GetAllWhereAccountIs(creditCard string) ([]domain.Transaction, error)
-	GetAllValidTransactionWhereAccountIs(creditCard string) ([]domain.Transaction, error)
-	FetchTransactions(date time.Time, isFraud bool, merchant string) ([]domain.Transaction, error)
-	GetAllWhereMerchantIs(merchant string) ([]domain.Transaction, error)
-	GetAll() ([]domain.Transaction, error)
-	Save(transaction *domain.Transaction) error
-	GetRelatedPayments(merchantId string, prefix string) ([]domain.Transaction, error)
-	GetNewTransactions(merchantId string, lastLoggedIn time.Time) int
+	Add(ctx context.Context, newTransaction domain.Transaction) (domain.Transaction, error)
+	GetById(ctx context.Context, merchant string, id string) (domain.Transaction, error)
+	// GetAllWhereAccountIs and GetAllValidTransactionWhereAccountIs look a card up by its fingerprint.
+	GetAllWhereAccountIs(ctx context.Context, fingerprint string) ([]domain.Transaction, error)
+	GetAllValidTransactionWhereAccountIs(ctx context.Context, fingerprint string) ([]domain.Transaction, error)
+	Save(ctx context.Context, transaction *domain.Transaction) error
+	// Find returns one page of the transactions matching query, see domain.TransactionQuery.
+	Find(ctx context.Context, query domain.TransactionQuery) (domain.TransactionPage, error)
 }
 
 type ReferenceRepository interface {
-	Set(transactionId string) string
-	Get(reference string) (string, error)
+	Set(ctx context.Context, transactionId string) string
+	Get(ctx context.Context, reference string) (string, error)
 }
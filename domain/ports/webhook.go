@@ -0,0 +1,24 @@
+package ports
+
+import (
+	"fraud-detect-system/domain"
+	"time"
+)
+
+type WebhookEndpointRepository interface {
+	Add(endpoint domain.WebhookEndpoint) (domain.WebhookEndpoint, error)
+	GetById(merchant string, id string) (domain.WebhookEndpoint, error)
+	GetAllWhereMerchantIs(merchant string) ([]domain.WebhookEndpoint, error)
+	Save(endpoint *domain.WebhookEndpoint) error
+	Delete(endpoint *domain.WebhookEndpoint) error
+}
+
+type WebhookDeliveryRepository interface {
+	Add(delivery domain.WebhookDelivery) (domain.WebhookDelivery, error)
+	GetById(merchant string, id string) (domain.WebhookDelivery, error)
+	GetAllWhereEndpointIs(merchant string, endpoint string) ([]domain.WebhookDelivery, error)
+	Save(delivery *domain.WebhookDelivery) error
+	// ClaimDue leases the oldest pending delivery that is due at now, pushing its next
+	// attempt back by lease so no other worker picks it up meanwhile.
+	ClaimDue(now time.Time, lease time.Duration) (domain.WebhookDelivery, bool, error)
+}
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	ProfileJobPending = "pending"
+	ProfileJobRunning = "running"
+	ProfileJobRetry   = "retry" // failed, waiting to run again
+	ProfileJobDone    = "done"
+	ProfileJobFailed  = "failed" // gave up after the last retry, kept for inspection
+)
+
+// Why a profile job was queued.
+const (
+	ProfileReasonTransactions = "transactions" // enough new transactions since the last profile
+	ProfileReasonSchedule     = "schedule"     // the profile got too old
+)
+
+// ProfileJob recomputes the profile of the account of a card in the live or sandbox
+// partition. An account has at most one pending job, more triggers while one is
+// pending are folded into it.
+//
+// Jobs due to run are pending or retry ones whose NextAttemptAt has come, and running
+// ones whose worker let the lease run out.
+type ProfileJob struct {
+	mgm.DefaultModel `bson:",inline"`
+	Card             string    `bson:"card" json:"-"` // the card's fingerprint
+	Livemode         bool      `bson:"livemode" json:"livemode"`
+	Reason           string    `bson:"reason" json:"reason"`
+	Status           string    `bson:"status" json:"status"`
+	Attempts         int       `bson:"attempts" json:"attempts"`
+	NextAttemptAt    time.Time `bson:"next_attempt_at" json:"next_attempt_at"` // while running, when the lease runs out
+	LastError        string    `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	FinishedAt       time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
@@ -0,0 +1,273 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The entities a search can be expanded by, see Linked.
+const (
+	LinkIp    = "ip"
+	LinkEmail = "email"
+	LinkCard  = "card"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// Linked selects the transactions that share an ip address, email or card with any of
+// a set of transactions. A Linked with nothing in it matches nothing.
+type Linked struct {
+	Ips    []string `json:"ips,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	Cards  []string `json:"-"` // fingerprints
+}
+
+func (l Linked) Empty() bool {
+	return len(l.Ips) == 0 && len(l.Emails) == 0 && len(l.Cards) == 0
+}
+
+// Matches reports whether tx shares an entity with the set.
+func (l Linked) Matches(tx Transaction) bool {
+	return (tx.Ip != "" && slices.Contains(l.Ips, tx.Ip)) ||
+		(tx.Email != "" && slices.Contains(l.Emails, tx.Email)) ||
+		(tx.Card.Fingerprint != "" && slices.Contains(l.Cards, tx.Card.Fingerprint))
+}
+
+// LinkedTo collects the given kinds of entity (LinkIp, LinkEmail, LinkCard) from
+// transactions, leaving out blanks and repeats.
+func LinkedTo(transactions []Transaction, kinds ...string) Linked {
+	var l Linked
+
+	add := func(to *[]string, v string) {
+		if v != "" && !slices.Contains(*to, v) {
+			*to = append(*to, v)
+		}
+	}
+
+	for _, tx := range transactions {
+		for _, kind := range kinds {
+			switch kind {
+			case LinkIp:
+				add(&l.Ips, tx.Ip)
+			case LinkEmail:
+				add(&l.Emails, tx.Email)
+			case LinkCard:
+				add(&l.Cards, tx.Card.Fingerprint)
+			}
+		}
+	}
+
+	return l
+}
+
+// ParseSearch adds an investigation search to query. A search is space separated
+// terms, each either a word to look for anywhere or a field and a value:
+//
+//	id:<transaction id>
+//	ip:<address>  email:<address>  state:<state>
+//	card:<last 4 digits or the whole card>  bin:<first 6 digits>
+//	decision:<approve|review|decline>  fraud:<true|false>
+//	amount, risk   with : for an exact value or > >= < <= for a bound
+//	date           a yyyy-mm-dd day (UTC), with : for that day or > >= < <=
+//
+// Values with spaces go in double quotes, as in state:"Cross River". Every value is
+// matched literally. A whole card is looked for by its fingerprint.
+func ParseSearch(search string, query *TransactionQuery, fingerprint func(card string) string) error {
+	terms, err := splitSearch(search)
+	if err != nil {
+		return err
+	}
+
+	for _, term := range terms {
+		field, op, value := splitTerm(term)
+		if field == "" {
+			query.Text = append(query.Text, term)
+			continue
+		}
+
+		if err := applyTerm(query, field, op, value, fingerprint); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSearch, err)
+		}
+	}
+
+	return nil
+}
+
+func applyTerm(query *TransactionQuery, field string, op string, value string, fingerprint func(string) string) error {
+	exact := func() error {
+		if op != ":" {
+			return fmt.Errorf("%s only takes an exact value, as in %s:<value>", field, field)
+		}
+		if value == "" {
+			return fmt.Errorf("%s needs a value", field)
+		}
+		return nil
+	}
+
+	switch field {
+	case "id":
+		if err := exact(); err != nil {
+			return err
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return fmt.Errorf("id must be a transaction id")
+		}
+		query.Id = id
+	case "ip":
+		if err := exact(); err != nil {
+			return err
+		}
+		query.Ip = value
+	case "email":
+		if err := exact(); err != nil {
+			return err
+		}
+		query.Email = value
+	case "state":
+		if err := exact(); err != nil {
+			return err
+		}
+		query.State = value
+	case "card":
+		if err := exact(); err != nil {
+			return err
+		}
+		if len(value) == 4 {
+			query.CardLast4 = value
+		} else {
+			query.CardFingerprint = fingerprint(value)
+		}
+	case "bin":
+		if err := exact(); err != nil {
+			return err
+		}
+		query.CardBin = value
+	case "decision":
+		if err := exact(); err != nil {
+			return err
+		}
+		switch value {
+		case DecisionApprove, DecisionReview, DecisionDecline:
+		default:
+			return fmt.Errorf("decision must be approve, review or decline")
+		}
+		query.Decision = value
+	case "fraud":
+		if err := exact(); err != nil {
+			return err
+		}
+		isFraud, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("fraud must be true or false")
+		}
+		query.IsFraud = &isFraud
+	case "amount":
+		return bound(field, op, value, &query.MinAmount, &query.MaxAmount)
+	case "risk":
+		return bound(field, op, value, &query.MinRisk, &query.MaxRisk)
+	case "date":
+		d, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("date must be a yyyy-mm-dd day")
+		}
+		next := d.AddDate(0, 0, 1)
+
+		switch op {
+		case ":":
+			query.From, query.To = d, next
+		case ">":
+			query.From = next
+		case ">=":
+			query.From = d
+		case "<":
+			query.To = d
+		case "<=":
+			query.To = next
+		}
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+
+	return nil
+}
+
+// bound sets the inclusive range [min, max] from a comparison. Strict ones move to
+// the next float over, which is exact.
+func bound(field string, op string, value string, min **float64, max **float64) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s must be a number", field)
+	}
+
+	switch op {
+	case ":":
+		lo, hi := v, v
+		*min, *max = &lo, &hi
+	case ">":
+		v = math.Nextafter(v, math.Inf(1))
+		*min = &v
+	case ">=":
+		*min = &v
+	case "<":
+		v = math.Nextafter(v, math.Inf(-1))
+		*max = &v
+	case "<=":
+		*max = &v
+	}
+
+	return nil
+}
+
+// splitTerm splits field:value, field>value and the like. field is empty when term is
+// a plain word.
+func splitTerm(term string) (field string, op string, value string) {
+	i := strings.IndexAny(term, ":<>")
+	if i <= 0 {
+		return "", "", term
+	}
+
+	op = term[i : i+1]
+	if op != ":" && strings.HasPrefix(term[i+1:], "=") {
+		op += "="
+	}
+
+	return strings.ToLower(term[:i]), op, term[i+len(op):]
+}
+
+// splitSearch splits on spaces outside double quotes and drops the quotes.
+func splitSearch(search string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+
+	for _, r := range search {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && unicode.IsSpace(r):
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("%w: unclosed quote", ErrInvalidSearch)
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+
+	return terms, nil
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"testing"
+	"time"
+)
+
+func fingerprint(card string) string { return "fp:" + card }
+
+func TestParseSearch(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	var q TransactionQuery
+	err := ParseSearch(`ip:10.0.0.1 email:ada@example.com state:"Cross River" card:4242 id:`+id.Hex()+
+		` decision:review fraud:true amount>=100 amount<500 risk>0.5 date:2024-03-05 iphone "(windows" card:4111111111111111 bin:411111`, &q, fingerprint)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.0.0.1", q.Ip)
+	assert.Equal(t, "ada@example.com", q.Email)
+	assert.Equal(t, "Cross River", q.State)
+	assert.Equal(t, "4242", q.CardLast4)
+	assert.Equal(t, "fp:4111111111111111", q.CardFingerprint)
+	assert.Equal(t, "411111", q.CardBin)
+	assert.Equal(t, id, q.Id)
+	assert.Equal(t, DecisionReview, q.Decision)
+	assert.True(t, *q.IsFraud)
+	assert.Equal(t, 100.0, *q.MinAmount)
+	assert.Less(t, *q.MaxAmount, 500.0)
+	assert.Equal(t, math.Nextafter(500, 0), *q.MaxAmount)
+	assert.Greater(t, *q.MinRisk, 0.5)
+	assert.Nil(t, q.MaxRisk)
+	assert.Equal(t, time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC), q.From)
+	assert.Equal(t, time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC), q.To)
+	assert.Equal(t, []string{"iphone", "(windows"}, q.Text)
+}
+
+func TestParseSearchDateBounds(t *testing.T) {
+	var q TransactionQuery
+	require.NoError(t, ParseSearch("date>2024-03-05 date<=2024-03-09", &q, fingerprint))
+
+	assert.Equal(t, time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC), q.From)
+	assert.Equal(t, time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), q.To)
+}
+
+func TestParseSearchRejectsBadTerms(t *testing.T) {
+	for _, search := range []string{
+		"colour:red",
+		"ip>10.0.0.1",
+		"email:",
+		"amount>lots",
+		"date:yesterday",
+		"decision:maybe",
+		"id:123",
+		`state:"Lagos`,
+	} {
+		err := ParseSearch(search, &TransactionQuery{}, fingerprint)
+		assert.ErrorIs(t, err, ErrInvalidSearch, search)
+	}
+}
+
+func TestLinkedTo(t *testing.T) {
+	transactions := []Transaction{
+		{Ip: "10.0.0.1", User: User{Email: "ada@example.com"}, Card: Card{Fingerprint: "fp1"}},
+		{Ip: "10.0.0.1", User: User{Email: "bob@example.com"}},
+	}
+
+	l := LinkedTo(transactions, LinkIp, LinkEmail)
+	assert.Equal(t, []string{"10.0.0.1"}, l.Ips)
+	assert.Equal(t, []string{"ada@example.com", "bob@example.com"}, l.Emails)
+	assert.Empty(t, l.Cards)
+
+	assert.True(t, l.Matches(Transaction{User: User{Email: "bob@example.com"}}))
+	assert.False(t, l.Matches(Transaction{Card: Card{Fingerprint: "fp1"}}))
+	assert.True(t, LinkedTo(nil, LinkIp).Empty())
+}
@@ -4,24 +4,33 @@ import (
 	"github.com/kamva/mgm/v3"
 )
 
+const (
+	DecisionApprove = "approve"
+	DecisionReview  = "review"
+	DecisionDecline = "decline"
+)
+
 type Transaction struct {
 	mgm.DefaultModel `bson:",inline"`
-	Amt              float64 `bson:"amt" json:"amt" json:"amt"`
-	Merchant         string  `bson:"merchant" json:"merchant" json:"merchant,omitempty"`
-	UserAgent        string  `bson:"user_agent" json:"user_agent""`
-	Ip               string  `bson:"ip_address" json:"ip_address" json:"ip,omitempty"`
-	CreditCard       string  `bson:"credit_card" json:"credit_card" json:"credit_card,omitempty"`
+	Amt              float64 `bson:"amt" json:"amt"`
+	Merchant         string  `bson:"merchant" json:"merchant"`
+	UserAgent        string  `bson:"user_agent" json:"user_agent"`
+	Ip               string  `bson:"ip_address" json:"ip_address"`
+	Card             Card    `bson:"card" json:"card"`
 
 	User
 	//Product
 	RiskScore float64 `bson:"risk_score" json:"risk_score"`
 	IsFraud   bool    `bson:"is_fraud" json:"is_fraud,omitempty"`
+	Decision  string  `bson:"decision" json:"decision"`
 
 	// ------ newly added ----------- (more data for the ml)
 	StateOfTransaction string `bson:"state_of_transaction" json:"state_of_transaction"`
 	SuspiciousIp       bool   `bson:"suspicious_ip" json:"suspicious_ip"` // basically if this ip has been used for a fraudulent transaction
 	IsVpn              bool   `bson:"is_vpn" json:"is_vpn"`
-	CardType           int    `bson:"card_type" json:"card_type"`
+	CardType           int    `bson:"card_type" json:"card_type"`                 // one of the CardType constants, from the bin
+	Country            string `bson:"country,omitempty" json:"country,omitempty"` // where the payment says it is from
+	CountryMismatch    bool   `bson:"country_mismatch" json:"country_mismatch"`   // the card was issued in another country
 
 	// AGGREGATED
 	// last_Transaction_24h_count | last_Transaction_24h_count | hour | avg_spend_pw | avg_spend_pm | travel_speed
@@ -0,0 +1,170 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// The fields transactions can be listed by. Every listing is also ordered by id, so
+// transactions with the same value keep a stable order across pages.
+const (
+	SortCreatedAt = "created_at"
+	SortAmount    = "amt"
+	SortRiskScore = "risk_score"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var (
+	ErrInvalidCursor = errors.New("cursor is invalid or was made for a different sort order")
+	ErrInvalidSort   = errors.New("sort must be created_at, amount or risk_score")
+)
+
+// TransactionQuery filters a merchant's transactions. Zero values don't filter.
+type TransactionQuery struct {
+	Merchant string
+
+	From time.Time // created at or after
+	To   time.Time // created before
+
+	MinAmount *float64
+	MaxAmount *float64
+	MinRisk   *float64
+	MaxRisk   *float64
+
+	Decision string
+	IsFraud  *bool
+
+	Id              primitive.ObjectID
+	CardBin         string
+	CardLast4       string
+	CardFingerprint string // see Card
+	Email           string
+	Ip              string
+	State           string
+
+	// Text matches when any of the words is in the email, ip address, state or user
+	// agent. Words are whole and case-insensitive.
+	Text []string
+
+	// Linked, when set, keeps only transactions sharing an entity with it.
+	Linked *Linked
+
+	Sort  string // one of the Sort constants, created_at when empty
+	Desc  bool
+	Limit int    // DefaultPageSize when zero
+	After string // the NextCursor of the previous page
+}
+
+// TransactionPage is one page of a listing. NextCursor is empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"data"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+	HasMore      bool          `json:"has_more"`
+}
+
+// Cursor is the position of the last transaction of a page: its id and the value it
+// was sorted by.
+type Cursor struct {
+	Sort   string             `json:"s"`
+	Desc   bool               `json:"d,omitempty"`
+	Id     primitive.ObjectID `json:"id"`
+	Time   time.Time          `json:"t,omitempty"`
+	Number float64            `json:"n,omitempty"`
+}
+
+// Normalize fills in the defaults and checks the sort and page size.
+func (q *TransactionQuery) Normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreatedAt
+	case SortCreatedAt, SortAmount, SortRiskScore:
+	default:
+		return ErrInvalidSort
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+
+	return nil
+}
+
+// Cursor decodes After. It is nil on the first page.
+func (q *TransactionQuery) Cursor() (*Cursor, error) {
+	if q.After == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(q.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err = json.Unmarshal(b, &c); err != nil || c.Sort != q.Sort || c.Desc != q.Desc || c.Id.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// SortValue is what tx is ordered by under sort.
+func SortValue(sort string, tx Transaction) interface{} {
+	switch sort {
+	case SortAmount:
+		return tx.Amt
+	case SortRiskScore:
+		return tx.RiskScore
+	default:
+		return tx.CreatedAt
+	}
+}
+
+// Value is the sort value the cursor is at.
+func (c *Cursor) Value() interface{} {
+	if c.Sort == SortCreatedAt {
+		return c.Time
+	}
+
+	return c.Number
+}
+
+// NewTransactionPage trims one-more-than-a-page of results to q.Limit and points the
+// cursor at the last transaction kept.
+func NewTransactionPage(q TransactionQuery, transactions []Transaction) TransactionPage {
+	page := TransactionPage{Transactions: transactions}
+	if page.Transactions == nil {
+		page.Transactions = []Transaction{}
+	}
+
+	if len(transactions) <= q.Limit {
+		return page
+	}
+
+	page.Transactions = transactions[:q.Limit]
+	page.HasMore = true
+
+	last := page.Transactions[q.Limit-1]
+	c := Cursor{Sort: q.Sort, Desc: q.Desc, Id: last.ID}
+	switch v := SortValue(q.Sort, last).(type) {
+	case time.Time:
+		c.Time = v
+	case float64:
+		c.Number = v
+	}
+
+	b, _ := json.Marshal(c)
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+
+	return page
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/kamva/mgm/v3"
+	"time"
+)
+
+const (
+	EventTransactionApproved = "transaction.approved"
+	EventTransactionDeclined = "transaction.declined"
+	EventTransactionReview   = "transaction.review"
+	EventFraudConfirmed      = "fraud.confirmed"
+	EventFraudDismissed      = "fraud.dismissed"
+)
+
+var WebhookEventTypes = []string{
+	EventTransactionApproved,
+	EventTransactionDeclined,
+	EventTransactionReview,
+	EventFraudConfirmed,
+	EventFraudDismissed,
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // failed for good after the last retry
+)
+
+// WebhookEndpoint is a merchant url that receives the events it subscribes to.
+type WebhookEndpoint struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string   `bson:"merchant" json:"merchant"`
+	Url              string   `bson:"url" json:"url"`
+	Description      string   `bson:"description" json:"description"`
+	Events           []string `bson:"events" json:"events"`
+	Secret           string   `bson:"secret" json:"-"` // needed in clear to sign, only shown when created
+	Disabled         bool     `bson:"disabled" json:"disabled"`
+}
+
+func NewWebhookSecret() (string, error) {
+	r := make([]byte, 24)
+	if _, err := rand.Read(r); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(r), nil
+}
+
+func (w *WebhookEndpoint) Subscribes(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidWebhookEvent(eventType string) bool {
+	if eventType == "*" {
+		return true
+	}
+
+	for _, e := range WebhookEventTypes {
+		if e == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is one event queued for one endpoint, and the log of trying to deliver it.
+type WebhookDelivery struct {
+	mgm.DefaultModel `bson:",inline"`
+	Merchant         string    `bson:"merchant" json:"merchant"`
+	Endpoint         string    `bson:"endpoint" json:"endpoint"`
+	EventId          string    `bson:"event_id" json:"event_id"`
+	EventType        string    `bson:"event_type" json:"event_type"`
+	Payload          string    `bson:"payload" json:"payload"`
+	Status           string    `bson:"status" json:"status"`
+	Attempts         int       `bson:"attempts" json:"attempts"`
+	NextAttemptAt    time.Time `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastAttemptAt    time.Time `bson:"last_attempt_at,omitempty" json:"last_attempt_at,omitempty"`
+	LastStatusCode   int       `bson:"last_status_code,omitempty" json:"last_status_code,omitempty"`
+	LastError        string    `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	LastResponse     string    `bson:"last_response,omitempty" json:"last_response,omitempty"`
+	RedeliveryOf     string    `bson:"redelivery_of,omitempty" json:"redelivery_of,omitempty"`
+}
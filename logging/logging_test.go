@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := map[string]string{
+		"card 4111111111111111 declined":     "card ****1111 declined",
+		"card 4111 1111 1111 1111 declined":  "card ****1111 declined",
+		"card 5500-0000-0000-0004":           "card ****0004",
+		"ada.lovelace@example.com signed up": "a***@example.com signed up",
+		"amount 1999.99 at 12:30":            "amount 1999.99 at 12:30",
+	}
+
+	for in, want := range tests {
+		assert.Equal(t, want, Redact(in), in)
+	}
+}
+
+func TestLoggerRedactsAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info", FormatJSON).With("email", "bob@example.com")
+
+	logger.Info("scored 4111111111111111",
+		"error", errors.New("no account for card 4111111111111111"),
+		"payment", struct{ CreditCard string }{"5500000000000004"},
+	)
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+
+	assert.Equal(t, "scored ****1111", line["msg"])
+	assert.Equal(t, "b***@example.com", line["email"])
+	assert.Equal(t, "no account for card ****1111", line["error"])
+	assert.Equal(t, "{CreditCard:****0004}", line["payment"])
+	assert.NotContains(t, buf.String(), "4111111111111111")
+}
+
+func TestRequestIDIsEchoedAndLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info", FormatJSON)
+
+	app := fiber.New()
+	app.Use(RequestID(logger))
+	app.Use(AccessLog)
+	app.Get("/", func(c *fiber.Ctx) error {
+		FromContext(c.UserContext()).Info("handling")
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set(HeaderRequestID, "req-123")
+
+	res, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, "req-123", res.Header.Get(HeaderRequestID))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	for _, l := range lines {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(l, &line))
+		assert.Equal(t, "req-123", line["request_id"])
+	}
+}
+
+func TestRequestIDIsGeneratedWhenMissing(t *testing.T) {
+	app := fiber.New()
+	app.Use(RequestID(New(&bytes.Buffer{}, "info", FormatJSON)))
+	app.Get("/", func(c *fiber.Ctx) error { return nil })
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.NotEmpty(t, res.Header.Get(HeaderRequestID))
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"time"
+)
+
+const HeaderRequestID = fiber.HeaderXRequestID
+
+// callers' ids longer than this are replaced rather than logged
+const maxRequestIDLength = 128
+
+// RequestID reuses the caller's X-Request-ID or makes one up, echoes it in the
+// response and puts a logger tagged with it, and with the trace id when the request is
+// traced, in the request's user context.
+func RequestID(logger *slog.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(HeaderRequestID)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = utils.UUIDv4()
+		}
+
+		c.Set(HeaderRequestID, id)
+
+		requestLogger := logger.With("request_id", id)
+		if span := trace.SpanContextFromContext(c.UserContext()); span.IsValid() {
+			requestLogger = requestLogger.With("trace_id", span.TraceID().String())
+		}
+		c.SetUserContext(WithContext(c.UserContext(), requestLogger))
+
+		return c.Next()
+	}
+}
+
+// AccessLog logs one line per request once it's been handled. It must come after
+// RequestID.
+func AccessLog(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if fe, ok := err.(*fiber.Error); ok {
+		status = fe.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	level := slog.LevelInfo
+	if status >= fiber.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	attrs := []any{
+		"method", c.Method(),
+		"path", c.Path(),
+		"status", status,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"ip", c.IP(),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+
+	FromContext(c.UserContext()).Log(c.UserContext(), level, "request", attrs...)
+
+	return err
+}
@@ -1,29 +1,35 @@
 package mailer
 
 import (
+	"fmt"
 	"fraud-detect-system/domain"
-	"log"
 	"net/smtp"
+	"time"
 )
 
+const defaultFrom = "kofi.akpor@your.domain"
+
 type MailtrapSMTP struct {
 	Host     string
 	Port     string
 	Username string
 	Password string
+	From     string
 	Url      string
 	Auth     smtp.Auth
 }
 
 func (s *MailtrapSMTP) Send(mail domain.Mail) error {
-	from := "kofi.akpor@your.domain"
-
-	err := smtp.SendMail(s.Url, s.Auth, from, []string{mail.To}, []byte(mail.Message))
+	msg, err := BuildMessage(s.From, mail, time.Now())
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
+	err = smtp.SendMail(s.Url, s.Auth, s.From, []string{mail.To}, msg)
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", s.Url, err)
+	}
+
 	return nil
 }
 
@@ -32,6 +38,7 @@ type Config struct {
 	Port     string
 	Username string
 	Password string
+	From     string
 }
 
 func New(config *Config) *MailtrapSMTP {
@@ -39,11 +46,17 @@ func New(config *Config) *MailtrapSMTP {
 	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
 	url := config.Host + ":" + config.Port
 
+	from := config.From
+	if from == "" {
+		from = defaultFrom
+	}
+
 	return &MailtrapSMTP{
 		Host:     config.Host,
 		Port:     config.Port,
 		Username: config.Username,
 		Password: config.Password,
+		From:     from,
 		Url:      url,
 		Auth:     auth,
 	}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"fraud-detect-system/domain"
+	"sync"
+)
+
+// InMemory is an IMailer that keeps what it is asked to send. It is meant for
+// tests and for running locally without an SMTP server.
+type InMemory struct {
+	mu   sync.Mutex
+	sent []domain.Mail
+	Err  error // returned by Send when set, to simulate a failing server
+}
+
+func NewInMemory() *InMemory {
+	return &InMemory{}
+}
+
+func (m *InMemory) Send(mail domain.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+func (m *InMemory) Sent() []domain.Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]domain.Mail(nil), m.sent...)
+}
@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	MaxAttempts  = 6
+	baseBackoff  = time.Minute
+	maxBackoff   = time.Hour
+	claimLease   = 2 * time.Minute
+	pollInterval = 10 * time.Second
+)
+
+// Outbox is an IMailer that only persists mail. Run sends it in the background
+// through transport, retrying failures and dead-lettering mail that keeps failing,
+// so a bad recipient or a slow server never holds up or brings down a request.
+type Outbox struct {
+	outboxRepository ports.OutboxRepository
+	transport        ports.IMailer
+
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewOutbox(outboxRepository ports.OutboxRepository, transport ports.IMailer, logger *slog.Logger) *Outbox {
+	return &Outbox{
+		outboxRepository: outboxRepository,
+		transport:        transport,
+		logger:           logger.With("component", "outbox"),
+		now:              time.Now,
+	}
+}
+
+func (o *Outbox) Send(mail domain.Mail) error {
+	_, err := o.outboxRepository.Add(domain.OutboxMail{
+		Mail:          mail,
+		Status:        domain.OutboxPending,
+		NextAttemptAt: o.now().UTC(),
+	})
+
+	return err
+}
+
+// Run sends queued mail until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.Flush(ctx, 0); err != nil {
+			o.logger.Error("cannot flush outbox", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush attempts the mail that is due now, at most limit of it unless limit is 0, and
+// reports how many it attempted. It stops early once ctx is done.
+func (o *Outbox) Flush(ctx context.Context, limit int) (int, error) {
+	attempted := 0
+
+	for limit == 0 || attempted < limit {
+		if ctx.Err() != nil {
+			return attempted, nil
+		}
+
+		mail, ok, err := o.outboxRepository.ClaimDue(o.now().UTC(), claimLease)
+		if err != nil || !ok {
+			return attempted, err
+		}
+
+		if err = o.attempt(&mail); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+
+	return attempted, nil
+}
+
+func (o *Outbox) attempt(mail *domain.OutboxMail) error {
+	mail.Attempts++
+
+	err := o.transport.Send(mail.Mail)
+
+	switch {
+	case err == nil:
+		mail.Status = domain.OutboxSent
+		mail.SentAt = o.now().UTC()
+		mail.LastError = ""
+		// bodies carry reset and verification links, which have no business sitting
+		// in the database once delivered
+		mail.Message, mail.Html = "", ""
+	case mail.Attempts >= MaxAttempts:
+		mail.Status = domain.OutboxDead
+		mail.LastError = err.Error()
+		o.logger.Warn("giving up on mail", "mail", mail.ID.Hex(), "attempts", mail.Attempts, "error", err)
+	default:
+		mail.NextAttemptAt = o.now().UTC().Add(backoff(mail.Attempts))
+		mail.LastError = err.Error()
+	}
+
+	return o.outboxRepository.Save(mail)
+}
+
+// backoff doubles the wait after every failed attempt.
+func backoff(attempts int) time.Duration {
+	d := time.Duration(float64(baseBackoff) * math.Pow(2, float64(attempts-1)))
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type outboxRepo struct {
+	mails []domain.OutboxMail
+}
+
+func (r *outboxRepo) Add(mail domain.OutboxMail) (domain.OutboxMail, error) {
+	mail.ID = primitive.NewObjectID()
+	r.mails = append(r.mails, mail)
+	return mail, nil
+}
+
+func (r *outboxRepo) Save(mail *domain.OutboxMail) error {
+	for i := range r.mails {
+		if r.mails[i].ID == mail.ID {
+			r.mails[i] = *mail
+		}
+	}
+	return nil
+}
+
+func (r *outboxRepo) ClaimDue(now time.Time, lease time.Duration) (domain.OutboxMail, bool, error) {
+	for i, mail := range r.mails {
+		if mail.Status == domain.OutboxPending && !mail.NextAttemptAt.After(now) {
+			r.mails[i].NextAttemptAt = now.Add(lease)
+			return r.mails[i], true, nil
+		}
+	}
+	return domain.OutboxMail{}, false, nil
+}
+
+func TestOutbox_SendOnlyQueues(t *testing.T) {
+	repo := &outboxRepo{}
+	transport := NewInMemory()
+	transport.Err = errors.New("connection refused")
+
+	outbox := NewOutbox(repo, transport, slog.Default())
+
+	assert.NoError(t, outbox.Send(domain.Mail{To: "owner@shop.example", Subject: "hi"}), "a broken server doesn't fail the caller")
+	assert.Len(t, repo.mails, 1)
+	assert.Equal(t, domain.OutboxPending, repo.mails[0].Status)
+}
+
+func TestOutbox_RetriesThenDeadLetters(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := &outboxRepo{}
+	transport := NewInMemory()
+	transport.Err = errors.New("451 try again later")
+
+	outbox := NewOutbox(repo, transport, slog.Default())
+	outbox.now = func() time.Time { return now }
+
+	_ = outbox.Send(domain.Mail{To: "owner@shop.example", Subject: "hi"})
+
+	for i := 1; i <= MaxAttempts; i++ {
+		attempted, err := outbox.Flush(context.TODO(), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		attempted, _ = outbox.Flush(context.TODO(), 0)
+		assert.Equal(t, 0, attempted, "not retried before its backoff")
+
+		now = now.Add(backoff(i))
+	}
+
+	assert.Equal(t, domain.OutboxDead, repo.mails[0].Status)
+	assert.Equal(t, "451 try again later", repo.mails[0].LastError)
+}
+
+func TestOutbox_SendsOnceServerRecovers(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := &outboxRepo{}
+	transport := NewInMemory()
+	transport.Err = errors.New("connection refused")
+
+	outbox := NewOutbox(repo, transport, slog.Default())
+	outbox.now = func() time.Time { return now }
+
+	_ = outbox.Send(domain.Mail{To: "owner@shop.example", Subject: "hi", Message: "reset at /reset?token=t0k3n"})
+	_, _ = outbox.Flush(context.TODO(), 0)
+	assert.NotEmpty(t, repo.mails[0].Message, "kept to retry")
+
+	transport.Err = nil
+	now = now.Add(backoff(1))
+	_, _ = outbox.Flush(context.TODO(), 0)
+
+	assert.Equal(t, domain.OutboxSent, repo.mails[0].Status)
+	assert.Equal(t, 2, repo.mails[0].Attempts)
+	assert.Empty(t, repo.mails[0].Message, "the body is gone once sent")
+	assert.Equal(t, "hi", repo.mails[0].Subject)
+	require.Len(t, transport.Sent(), 1)
+	assert.Equal(t, "reset at /reset?token=t0k3n", transport.Sent()[0].Message)
+}
+
+func TestOutbox_FlushStopsAtLimitOrWhenCancelled(t *testing.T) {
+	repo := &outboxRepo{}
+	transport := NewInMemory()
+	outbox := NewOutbox(repo, transport, slog.Default())
+
+	for i := 0; i < 3; i++ {
+		_ = outbox.Send(domain.Mail{To: "owner@shop.example", Subject: "hi"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempted, err := outbox.Flush(ctx, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, attempted)
+
+	attempted, err = outbox.Flush(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempted)
+
+	attempted, _ = outbox.Flush(context.Background(), 0)
+	assert.Equal(t, 1, attempted)
+	assert.Len(t, transport.Sent(), 3)
+}
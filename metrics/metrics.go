@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"strconv"
+	"time"
+)
+
+const namespace = "fraudis"
+
+// Scorers timed separately inside a scoring request.
+const (
+	ScorerFeatures = "features"
+	ScorerXGB      = "xgb"
+	ScorerHMM      = "hmm"
+)
+
+// Reasons a call to the ml server failed.
+const (
+	MLErrorRequest = "request"
+	MLErrorStatus  = "status"
+	MLErrorDecode  = "decode"
+)
+
+// Metrics are everything /metrics exposes. Each App has its own registry so tests can
+// build several apps without colliding.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requestDuration *prometheus.HistogramVec
+	scoringDuration *prometheus.HistogramVec
+	scorerDuration  *prometheus.HistogramVec
+	decisions       *prometheus.CounterVec
+	mlErrors        *prometheus.CounterVec
+	mlRetries       prometheus.Counter
+	riskScores      prometheus.Histogram
+	profileJobs     *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to handle an http request, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+
+		scoringDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "scoring_duration_seconds",
+			Help:      "End to end time of AddTransaction, from parsing the payment to the decision.",
+			Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"livemode"}),
+
+		scorerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "scorer_duration_seconds",
+			Help:      "Time spent in each step of scoring a transaction.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"scorer"}),
+
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decisions_total",
+			Help:      "Transactions decided, by outcome and merchant.",
+		}, []string{"decision", "merchant", "livemode"}),
+
+		mlErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ml_client_errors_total",
+			Help:      "Failed calls to the ml server, by reason.",
+		}, []string{"reason"}),
+
+		mlRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ml_client_retries_total",
+			Help:      "Calls to the ml server retried after a failure.",
+		}),
+
+		riskScores: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "risk_score",
+			Help:      "Distribution of risk scores given to transactions.",
+			Buckets:   []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1},
+		}),
+
+		profileJobs: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "profile_job_duration_seconds",
+			Help:      "Time to run an account profile job, by the status it ended in.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"status"}),
+	}
+
+	m.registry.MustRegister(
+		m.requestDuration,
+		m.scoringDuration,
+		m.scorerDuration,
+		m.decisions,
+		m.mlErrors,
+		m.mlRetries,
+		m.riskScores,
+		m.profileJobs,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// Middleware times every request under its route pattern, so ids in paths don't
+// each get their own series.
+func (m *Metrics) Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if fe, ok := err.(*fiber.Error); ok {
+		status = fe.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	m.requestDuration.
+		WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(status)).
+		Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+func (m *Metrics) ObserveScoring(livemode bool, start time.Time) {
+	m.scoringDuration.WithLabelValues(strconv.FormatBool(livemode)).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) ObserveScorer(scorer string, start time.Time) {
+	m.scorerDuration.WithLabelValues(scorer).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) RecordDecision(merchant string, decision string, livemode bool, riskScore float64) {
+	m.decisions.WithLabelValues(decision, merchant, strconv.FormatBool(livemode)).Inc()
+	m.riskScores.Observe(riskScore)
+}
+
+func (m *Metrics) MLError(reason string) {
+	m.mlErrors.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) MLRetry() {
+	m.mlRetries.Inc()
+}
+
+func (m *Metrics) ObserveProfileJob(status string, start time.Time) {
+	m.profileJobs.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, app *fiber.App) string {
+	res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	assert.Contains(t, res.Header.Get(fiber.HeaderContentType), "text/plain")
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := New()
+
+	app := fiber.New()
+	app.Use(m.Middleware)
+	app.Get("/metrics", m.Handler())
+	app.Get("/merchants/:id", func(c *fiber.Ctx) error { return nil })
+
+	_, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/merchants/64b0c0ffee", nil))
+	require.NoError(t, err)
+
+	m.ObserveScoring(true, time.Now().Add(-300*time.Millisecond))
+	m.ObserveScorer(ScorerXGB, time.Now())
+	m.RecordDecision("m1", "decline", true, 0.83)
+	m.MLError(MLErrorStatus)
+	m.MLRetry()
+
+	body := scrape(t, app)
+
+	assert.Contains(t, body, `fraudis_http_request_duration_seconds_count{method="GET",route="/merchants/:id",status="200"} 1`)
+	assert.Contains(t, body, `fraudis_scoring_duration_seconds_count{livemode="true"} 1`)
+	assert.Contains(t, body, `fraudis_scorer_duration_seconds_count{scorer="xgb"} 1`)
+	assert.Contains(t, body, `fraudis_decisions_total{decision="decline",livemode="true",merchant="m1"} 1`)
+	assert.Contains(t, body, `fraudis_ml_client_errors_total{reason="status"} 1`)
+	assert.Contains(t, body, `fraudis_ml_client_retries_total 1`)
+	assert.Contains(t, body, `fraudis_risk_score_bucket{le="0.9"} 1`)
+}
@@ -0,0 +1,216 @@
+package alert_srv
+
+import (
+	"bytes"
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const DigestInterval = time.Hour
+
+// template is what text/template and html/template have in common.
+type template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+type AlertService struct {
+	alertRepository    ports.AlertRepository
+	merchantRepository ports.MerchantRepository
+	mailer             ports.IMailer
+
+	logger *slog.Logger
+}
+
+func New(alertRepository ports.AlertRepository, merchantRepository ports.MerchantRepository, mailer ports.IMailer, logger *slog.Logger) *AlertService {
+	return &AlertService{
+		alertRepository:    alertRepository,
+		merchantRepository: merchantRepository,
+		mailer:             mailer,
+
+		logger: logger.With("component", "alerts"),
+	}
+}
+
+// GetSettings returns the merchant's alert settings, defaulting to instant alerts on
+// declines and reviews sent to the merchant's own email.
+func (as *AlertService) GetSettings(ctx context.Context, merchant string) (domain.AlertSettings, error) {
+	settings, err := as.alertRepository.GetSettings(merchant)
+	if err == nil {
+		return settings, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return domain.AlertSettings{}, err
+	}
+
+	m, err := as.merchantRepository.GetById(ctx, merchant)
+	if err != nil {
+		return domain.AlertSettings{}, err
+	}
+
+	return domain.AlertSettings{
+		Merchant:   merchant,
+		Recipients: []string{m.Email},
+		Mode:       domain.AlertModeInstant,
+		Decisions:  []string{domain.DecisionDecline, domain.DecisionReview},
+	}, nil
+}
+
+func (as *AlertService) SaveSettings(settings *domain.AlertSettings) error {
+	return as.alertRepository.SaveSettings(settings)
+}
+
+// Notify alerts the merchant about a declined or reviewed transaction, straight away
+// or in the next digest depending on their settings.
+func (as *AlertService) Notify(ctx context.Context, transaction domain.Transaction) error {
+	settings, err := as.GetSettings(ctx, transaction.Merchant)
+	if err != nil {
+		return err
+	}
+
+	if !settings.AlertsOn(transaction.Decision) || len(settings.Recipients) == 0 {
+		return nil
+	}
+
+	alert := domain.NewPendingAlert(transaction)
+
+	if settings.Mode == domain.AlertModeDigest {
+		_, err = as.alertRepository.AddPending(alert)
+		return err
+	}
+
+	mail, err := render(alertSubjectTmpl, alertTextTmpl, alertHtmlTmpl, alert)
+	if err != nil {
+		return err
+	}
+
+	return as.sendToAll(settings.Recipients, mail)
+}
+
+// SendDigests mails every merchant in digest mode a summary of what is pending, then clears it.
+func (as *AlertService) SendDigests(ctx context.Context) error {
+	pending, err := as.alertRepository.GetAllPending()
+	if err != nil {
+		return err
+	}
+
+	byMerchant := make(map[string][]domain.PendingAlert)
+	for _, alert := range pending {
+		byMerchant[alert.Merchant] = append(byMerchant[alert.Merchant], alert)
+	}
+
+	for merchant, alerts := range byMerchant {
+		settings, err := as.GetSettings(ctx, merchant)
+		if err != nil {
+			as.logger.Error("cannot load alert settings", "merchant", merchant, "error", err)
+			continue
+		}
+
+		// the merchant may have switched away from digests since these were queued
+		if settings.Mode == domain.AlertModeDigest && len(settings.Recipients) > 0 {
+			digest := newDigest(alerts)
+
+			mail, err := render(digestSubjectTmpl, digestTextTmpl, digestHtmlTmpl, digest)
+			if err != nil {
+				return err
+			}
+
+			if err = as.sendToAll(settings.Recipients, mail); err != nil {
+				as.logger.Error("cannot send digest", "merchant", merchant, "error", err)
+				continue
+			}
+		}
+
+		if err = as.alertRepository.DeletePending(alerts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run sends digests every DigestInterval until ctx is cancelled.
+func (as *AlertService) Run(ctx context.Context) {
+	ticker := time.NewTicker(DigestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := as.SendDigests(ctx); err != nil {
+				as.logger.Error("cannot send digests", "error", err)
+			}
+		}
+	}
+}
+
+func (as *AlertService) sendToAll(recipients []string, mail domain.Mail) error {
+	var failed []string
+
+	for _, to := range recipients {
+		mail.To = to
+		if err := as.mailer.Send(mail); err != nil {
+			failed = append(failed, to+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return &SendError{Failures: failed}
+	}
+
+	return nil
+}
+
+type SendError struct {
+	Failures []string
+}
+
+func (e *SendError) Error() string {
+	return "cannot send alert to " + strings.Join(e.Failures, "; ")
+}
+
+type digest struct {
+	Alerts   []domain.PendingAlert
+	Declined int
+	Review   int
+}
+
+func newDigest(alerts []domain.PendingAlert) digest {
+	d := digest{Alerts: alerts}
+
+	for _, alert := range alerts {
+		if alert.Decision == domain.DecisionDecline {
+			d.Declined++
+		} else {
+			d.Review++
+		}
+	}
+
+	return d
+}
+
+func render(subject, text, html template, data interface{}) (domain.Mail, error) {
+	var s, t, h bytes.Buffer
+
+	for _, r := range []struct {
+		tmpl template
+		buf  *bytes.Buffer
+	}{{subject, &s}, {text, &t}, {html, &h}} {
+		if err := r.tmpl.Execute(r.buf, data); err != nil {
+			return domain.Mail{}, err
+		}
+	}
+
+	return domain.Mail{
+		Subject: strings.TrimSpace(s.String()),
+		Message: t.String(),
+		Html:    h.String(),
+	}, nil
+}
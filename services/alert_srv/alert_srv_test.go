@@ -0,0 +1,155 @@
+package alert_srv
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/mailer"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type alertRepo struct {
+	settings map[string]domain.AlertSettings
+	pending  []domain.PendingAlert
+}
+
+func (r *alertRepo) GetSettings(merchant string) (domain.AlertSettings, error) {
+	settings, ok := r.settings[merchant]
+	if !ok {
+		return domain.AlertSettings{}, mongo.ErrNoDocuments
+	}
+	return settings, nil
+}
+
+func (r *alertRepo) SaveSettings(settings *domain.AlertSettings) error {
+	r.settings[settings.Merchant] = *settings
+	return nil
+}
+
+func (r *alertRepo) AddPending(alert domain.PendingAlert) (domain.PendingAlert, error) {
+	alert.ID = primitive.NewObjectID()
+	r.pending = append(r.pending, alert)
+	return alert, nil
+}
+
+func (r *alertRepo) GetAllPending() ([]domain.PendingAlert, error) {
+	return r.pending, nil
+}
+
+func (r *alertRepo) DeletePending(alerts []domain.PendingAlert) error {
+	r.pending = nil
+	return nil
+}
+
+type merchantRepo struct {
+	merchant domain.Merchant
+}
+
+func (r *merchantRepo) Add(ctx context.Context, newMerchant *domain.Merchant) (domain.Merchant, error) {
+	return *newMerchant, nil
+}
+
+func (r *merchantRepo) GetById(ctx context.Context, id string) (domain.Merchant, error) {
+	if id != r.merchant.ID.Hex() {
+		return domain.Merchant{}, errors.New("not found")
+	}
+	return r.merchant, nil
+}
+
+func (r *merchantRepo) GetByEmail(ctx context.Context, email string) (domain.Merchant, error) {
+	return r.merchant, nil
+}
+
+func (r *merchantRepo) Save(ctx context.Context, merchant *domain.Merchant) error {
+	return nil
+}
+
+func newTestService() (*AlertService, *alertRepo, *mailer.InMemory, domain.Merchant) {
+	merchant := domain.Merchant{Email: "owner@shop.example"}
+	merchant.ID = primitive.NewObjectID()
+
+	repo := &alertRepo{settings: map[string]domain.AlertSettings{}}
+	m := mailer.NewInMemory()
+
+	return New(repo, &merchantRepo{merchant: merchant}, m, slog.Default()), repo, m, merchant
+}
+
+func flagged(merchant string, decision string, amount float64) domain.Transaction {
+	transaction := domain.Transaction{
+		Amt:       amount,
+		Merchant:  merchant,
+		Ip:        "203.0.113.9",
+		RiskScore: 0.42,
+		Decision:  decision,
+		User:      domain.User{Email: "buyer@example.com"},
+	}
+	transaction.ID = primitive.NewObjectID()
+	transaction.CreatedAt = time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	return transaction
+}
+
+func TestAlertService_InstantAlertGoesToMerchantByDefault(t *testing.T) {
+	as, _, m, merchant := newTestService()
+
+	assert.NoError(t, as.Notify(context.TODO(), flagged(merchant.ID.Hex(), domain.DecisionDecline, 250)))
+
+	sent := m.Sent()
+	assert.Len(t, sent, 1)
+	assert.Equal(t, "owner@shop.example", sent[0].To)
+	assert.Equal(t, "Declined: 250.00 payment from buyer@example.com", sent[0].Subject)
+	assert.Contains(t, sent[0].Message, "Risk score:  42%")
+	assert.Contains(t, sent[0].Html, "<strong>declined</strong>")
+
+	msg, err := mailer.BuildMessage("alerts@fraudis.example", sent[0], time.Now())
+	assert.NoError(t, err)
+	assert.Contains(t, string(msg), "Content-Type: multipart/alternative; boundary=")
+	assert.Contains(t, string(msg), "Content-Type: text/html; charset=utf-8")
+}
+
+func TestAlertService_DigestCollectsUntilSent(t *testing.T) {
+	as, repo, m, merchant := newTestService()
+
+	assert.NoError(t, as.SaveSettings(&domain.AlertSettings{
+		Merchant:   merchant.ID.Hex(),
+		Recipients: []string{"risk@shop.example", "ops@shop.example"},
+		Mode:       domain.AlertModeDigest,
+		Decisions:  []string{domain.DecisionDecline, domain.DecisionReview},
+	}))
+
+	assert.NoError(t, as.Notify(context.TODO(), flagged(merchant.ID.Hex(), domain.DecisionDecline, 250)))
+	assert.NoError(t, as.Notify(context.TODO(), flagged(merchant.ID.Hex(), domain.DecisionReview, 80)))
+	assert.Empty(t, m.Sent(), "digests wait for the hourly run")
+	assert.Len(t, repo.pending, 2)
+
+	assert.NoError(t, as.SendDigests(context.TODO()))
+
+	sent := m.Sent()
+	assert.Len(t, sent, 2, "one digest per recipient")
+	assert.Equal(t, "2 payments flagged in the last hour", sent[0].Subject)
+	assert.True(t, strings.HasPrefix(sent[0].Message, "1 declined and 1 held for review"))
+	assert.Empty(t, repo.pending)
+}
+
+func TestAlertService_RespectsDecisionsAndOff(t *testing.T) {
+	as, _, m, merchant := newTestService()
+
+	assert.NoError(t, as.SaveSettings(&domain.AlertSettings{
+		Merchant:   merchant.ID.Hex(),
+		Recipients: []string{"risk@shop.example"},
+		Mode:       domain.AlertModeInstant,
+		Decisions:  []string{domain.DecisionDecline},
+	}))
+
+	assert.NoError(t, as.Notify(context.TODO(), flagged(merchant.ID.Hex(), domain.DecisionReview, 80)))
+	assert.Empty(t, m.Sent())
+
+	m.Err = errors.New("mailbox unavailable")
+	assert.Error(t, as.Notify(context.TODO(), flagged(merchant.ID.Hex(), domain.DecisionDecline, 80)))
+}
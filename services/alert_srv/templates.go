@@ -0,0 +1,78 @@
+package alert_srv
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+var funcs = map[string]interface{}{
+	"percent": func(score float64) string {
+		return fmt.Sprintf("%.0f%%", score*100)
+	},
+	"verb": func(decision string) string {
+		if decision == "decline" {
+			return "declined"
+		}
+		return "held for review"
+	},
+}
+
+const alertSubject = `{{if eq .Decision "decline"}}Declined{{else}}Review needed{{end}}: {{printf "%.2f" .Amount}} payment from {{.Email}}`
+
+const alertText = `A payment was {{verb .Decision}} by fraud screening.
+
+Transaction: {{.Transaction}}
+Amount:      {{printf "%.2f" .Amount}}
+Customer:    {{.Email}}
+IP address:  {{.Ip}}
+Risk score:  {{percent .RiskScore}}
+Time:        {{.OccurredAt.Format "2006-01-02 15:04 MST"}}
+
+Open your dashboard to review it.
+`
+
+const alertHtml = `<!doctype html>
+<html><body style="font-family: sans-serif; color: #222">
+<p>A payment was <strong>{{verb .Decision}}</strong> by fraud screening.</p>
+<table cellpadding="4">
+<tr><td>Transaction</td><td>{{.Transaction}}</td></tr>
+<tr><td>Amount</td><td>{{printf "%.2f" .Amount}}</td></tr>
+<tr><td>Customer</td><td>{{.Email}}</td></tr>
+<tr><td>IP address</td><td>{{.Ip}}</td></tr>
+<tr><td>Risk score</td><td>{{percent .RiskScore}}</td></tr>
+<tr><td>Time</td><td>{{.OccurredAt.Format "2006-01-02 15:04 MST"}}</td></tr>
+</table>
+<p>Open your dashboard to review it.</p>
+</body></html>
+`
+
+const digestSubject = `{{len .Alerts}} payment{{if ne (len .Alerts) 1}}s{{end}} flagged in the last hour`
+
+const digestText = `{{.Declined}} declined and {{.Review}} held for review since the last summary.
+{{range .Alerts}}
+- {{.OccurredAt.Format "15:04"}}  {{verb .Decision}}  {{printf "%.2f" .Amount}}  {{.Email}}  risk {{percent .RiskScore}}  ({{.Transaction}})
+{{- end}}
+
+Open your dashboard to review them.
+`
+
+const digestHtml = `<!doctype html>
+<html><body style="font-family: sans-serif; color: #222">
+<p>{{.Declined}} declined and {{.Review}} held for review since the last summary.</p>
+<table cellpadding="4">
+<tr><th align="left">Time</th><th align="left">Outcome</th><th align="right">Amount</th><th align="left">Customer</th><th align="right">Risk</th></tr>
+{{range .Alerts}}<tr><td>{{.OccurredAt.Format "15:04"}}</td><td>{{verb .Decision}}</td><td align="right">{{printf "%.2f" .Amount}}</td><td>{{.Email}}</td><td align="right">{{percent .RiskScore}}</td></tr>
+{{end}}</table>
+<p>Open your dashboard to review them.</p>
+</body></html>
+`
+
+var (
+	alertSubjectTmpl  = texttemplate.Must(texttemplate.New("alert_subject").Funcs(funcs).Parse(alertSubject))
+	alertTextTmpl     = texttemplate.Must(texttemplate.New("alert_text").Funcs(funcs).Parse(alertText))
+	alertHtmlTmpl     = htmltemplate.Must(htmltemplate.New("alert_html").Funcs(funcs).Parse(alertHtml))
+	digestSubjectTmpl = texttemplate.Must(texttemplate.New("digest_subject").Funcs(funcs).Parse(digestSubject))
+	digestTextTmpl    = texttemplate.Must(texttemplate.New("digest_text").Funcs(funcs).Parse(digestText))
+	digestHtmlTmpl    = htmltemplate.Must(htmltemplate.New("digest_html").Funcs(funcs).Parse(digestHtml))
+)
@@ -0,0 +1,187 @@
+package api_key_srv
+
+import (
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// last used is only written when it's older than this, so busy keys don't cost a write per request
+	lastUsedResolution = time.Minute
+
+	MaxRotationGracePeriod = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidApiKey = errors.New("invalid api key")
+	ErrKeyRevoked    = errors.New("api key already revoked")
+)
+
+type ApiKeyService struct {
+	apiKeyRepository ports.ApiKeyRepository
+	logger           *slog.Logger
+	now              func() time.Time
+}
+
+func New(apiKeyRepository ports.ApiKeyRepository, logger *slog.Logger) *ApiKeyService {
+	return &ApiKeyService{
+		apiKeyRepository: apiKeyRepository,
+		logger:           logger.With("component", "api_keys"),
+		now:              time.Now,
+	}
+}
+
+// Create stores a new key for merchant and returns it with the plain key, which is not kept anywhere.
+func (aks *ApiKeyService) Create(merchant, name, mode string) (domain.ApiKey, string, error) {
+	if mode != domain.ApiKeyModeTest && mode != domain.ApiKeyModeLive {
+		return domain.ApiKey{}, "", domain.ErrInvalidApiKeyMode
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = strings.ToUpper(mode[:1]) + mode[1:] + " key"
+	}
+
+	key, plain, err := domain.NewApiKey(merchant, name, mode)
+	if err != nil {
+		return domain.ApiKey{}, "", err
+	}
+
+	key, err = aks.apiKeyRepository.Add(key)
+	if err != nil {
+		return domain.ApiKey{}, "", err
+	}
+
+	return key, plain, nil
+}
+
+// CreateDefaults gives a new merchant one test and one live key. If either can't be
+// created, the other is revoked so the merchant is left with none.
+func (aks *ApiKeyService) CreateDefaults(merchant string) (map[string]string, error) {
+	keys := make(map[string]string)
+
+	for _, mode := range []string{domain.ApiKeyModeTest, domain.ApiKeyModeLive} {
+		_, plain, err := aks.Create(merchant, "", mode)
+		if err != nil {
+			if err := aks.RevokeAll(merchant); err != nil {
+				aks.logger.Error("cannot revoke partly created default keys", "merchant", merchant, "error", err)
+			}
+			return nil, err
+		}
+
+		keys[mode] = plain
+	}
+
+	return keys, nil
+}
+
+func (aks *ApiKeyService) List(merchant string) ([]domain.ApiKey, error) {
+	return aks.apiKeyRepository.GetAllWhereMerchantIs(merchant)
+}
+
+// Rotate issues a replacement with the same name and mode. The old key keeps working
+// for gracePeriod so deployments can switch over, or stops immediately if it is zero.
+func (aks *ApiKeyService) Rotate(merchant, id string, gracePeriod time.Duration) (domain.ApiKey, string, error) {
+	old, err := aks.apiKeyRepository.GetById(merchant, id)
+	if err != nil {
+		return domain.ApiKey{}, "", err
+	}
+
+	now := aks.now()
+	if !old.Active(now) {
+		return domain.ApiKey{}, "", ErrKeyRevoked
+	}
+
+	if gracePeriod > MaxRotationGracePeriod {
+		gracePeriod = MaxRotationGracePeriod
+	}
+
+	replacement, plain, err := aks.Create(merchant, old.Name, old.Mode)
+	if err != nil {
+		return domain.ApiKey{}, "", err
+	}
+
+	old.RotatedTo = replacement.ID.Hex()
+	if gracePeriod > 0 {
+		old.ExpiresAt = now.Add(gracePeriod).UTC()
+	} else {
+		old.RevokedAt = now.UTC()
+	}
+
+	if err = aks.apiKeyRepository.Save(&old); err != nil {
+		return domain.ApiKey{}, "", err
+	}
+
+	return replacement, plain, nil
+}
+
+func (aks *ApiKeyService) Revoke(merchant, id string) (domain.ApiKey, error) {
+	key, err := aks.apiKeyRepository.GetById(merchant, id)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	if !key.RevokedAt.IsZero() {
+		return domain.ApiKey{}, ErrKeyRevoked
+	}
+
+	key.RevokedAt = aks.now().UTC()
+
+	if err = aks.apiKeyRepository.Save(&key); err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+// RevokeAll revokes every key of merchant still in use, for example the keys created
+// for a signup that then failed.
+func (aks *ApiKeyService) RevokeAll(merchant string) error {
+	keys, err := aks.apiKeyRepository.GetAllWhereMerchantIs(merchant)
+	if err != nil {
+		return err
+	}
+
+	now := aks.now().UTC()
+	for i := range keys {
+		if !keys[i].RevokedAt.IsZero() {
+			continue
+		}
+
+		keys[i].RevokedAt = now
+		if err = aks.apiKeyRepository.Save(&keys[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Authenticate resolves a presented plain key to its active record and notes that it was used.
+func (aks *ApiKeyService) Authenticate(plain string) (domain.ApiKey, error) {
+	if plain == "" {
+		return domain.ApiKey{}, ErrInvalidApiKey
+	}
+
+	key, err := aks.apiKeyRepository.GetByHash(domain.HashSecret(plain))
+	if err != nil {
+		return domain.ApiKey{}, ErrInvalidApiKey
+	}
+
+	now := aks.now()
+	if !key.Active(now) {
+		return domain.ApiKey{}, ErrInvalidApiKey
+	}
+
+	if now.Sub(key.LastUsedAt) >= lastUsedResolution {
+		key.LastUsedAt = now.UTC()
+		if err := aks.apiKeyRepository.TouchLastUsed(key.ID.Hex(), key.LastUsedAt); err != nil {
+			aks.logger.Warn("cannot record api key use", "api_key", key.ID.Hex(), "error", err)
+		}
+	}
+
+	return key, nil
+}
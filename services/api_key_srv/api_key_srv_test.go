@@ -0,0 +1,188 @@
+package api_key_srv
+
+import (
+	"fraud-detect-system/domain"
+	"fraud-detect-system/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestService() (*ApiKeyService, *time.Time) {
+	now := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+	aks := New(memory.NewApiKeyStorage(), slog.Default())
+	aks.now = func() time.Time { return now }
+
+	return aks, &now
+}
+
+func TestCreateDefaults(t *testing.T) {
+	aks, _ := newTestService()
+
+	keys, err := aks.CreateDefaults("m1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(keys[domain.ApiKeyModeTest], "sk_test_"))
+	assert.True(t, strings.HasPrefix(keys[domain.ApiKeyModeLive], "sk_live_"))
+
+	live, err := aks.Authenticate(keys[domain.ApiKeyModeLive])
+	require.NoError(t, err)
+	assert.Equal(t, "m1", live.Merchant)
+	assert.True(t, live.Livemode())
+	assert.Equal(t, "Live key", live.Name)
+	assert.Equal(t, keys[domain.ApiKeyModeLive][:len("sk_live_")+6], live.Prefix)
+
+	listed, err := aks.List("m1")
+	require.NoError(t, err)
+	assert.Len(t, listed, 2)
+	for _, key := range listed {
+		assert.NotContains(t, key.KeyHash, keys[key.Mode], "only the hash is stored")
+	}
+}
+
+func TestCreateRejectsUnknownMode(t *testing.T) {
+	aks, _ := newTestService()
+
+	_, _, err := aks.Create("m1", "", "prod")
+	assert.ErrorIs(t, err, domain.ErrInvalidApiKeyMode)
+
+	_, _, err = aks.Create("m1", "", "")
+	assert.ErrorIs(t, err, domain.ErrInvalidApiKeyMode)
+}
+
+func TestAuthenticate(t *testing.T) {
+	aks, now := newTestService()
+
+	_, plain, err := aks.Create("m1", "Checkout", domain.ApiKeyModeTest)
+	require.NoError(t, err)
+
+	for _, wrong := range []string{"", "sk_test_nope", plain + "0"} {
+		_, err = aks.Authenticate(wrong)
+		assert.ErrorIs(t, err, ErrInvalidApiKey, wrong)
+	}
+
+	key, err := aks.Authenticate(plain)
+	require.NoError(t, err)
+	assert.False(t, key.Livemode())
+	assert.True(t, now.Equal(key.LastUsedAt))
+
+	// uses within the resolution don't write last used again
+	*now = now.Add(30 * time.Second)
+	_, err = aks.Authenticate(plain)
+	require.NoError(t, err)
+	listed, err := aks.List("m1")
+	require.NoError(t, err)
+	assert.True(t, now.Add(-30*time.Second).Equal(listed[0].LastUsedAt))
+
+	*now = now.Add(time.Minute)
+	_, err = aks.Authenticate(plain)
+	require.NoError(t, err)
+	listed, err = aks.List("m1")
+	require.NoError(t, err)
+	assert.True(t, now.Equal(listed[0].LastUsedAt))
+}
+
+func TestRotateWithGracePeriod(t *testing.T) {
+	aks, now := newTestService()
+
+	old, oldPlain, err := aks.Create("m1", "Checkout", domain.ApiKeyModeLive)
+	require.NoError(t, err)
+
+	replacement, plain, err := aks.Rotate("m1", old.ID.Hex(), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "Checkout", replacement.Name)
+	assert.Equal(t, domain.ApiKeyModeLive, replacement.Mode)
+	assert.NotEqual(t, oldPlain, plain)
+
+	// both work during the grace period
+	_, err = aks.Authenticate(oldPlain)
+	assert.NoError(t, err)
+	_, err = aks.Authenticate(plain)
+	assert.NoError(t, err)
+
+	rotated, err := aks.Authenticate(oldPlain)
+	require.NoError(t, err)
+	assert.Equal(t, replacement.ID.Hex(), rotated.RotatedTo)
+	assert.True(t, now.Add(time.Hour).Equal(rotated.ExpiresAt))
+
+	*now = now.Add(time.Hour)
+	_, err = aks.Authenticate(oldPlain)
+	assert.ErrorIs(t, err, ErrInvalidApiKey)
+	_, err = aks.Authenticate(plain)
+	assert.NoError(t, err)
+
+	// an expired key can't be rotated again
+	_, _, err = aks.Rotate("m1", old.ID.Hex(), 0)
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+}
+
+func TestRotateWithoutGracePeriodRevokes(t *testing.T) {
+	aks, _ := newTestService()
+
+	old, oldPlain, err := aks.Create("m1", "", domain.ApiKeyModeTest)
+	require.NoError(t, err)
+
+	_, _, err = aks.Rotate("m1", old.ID.Hex(), 0)
+	require.NoError(t, err)
+
+	_, err = aks.Authenticate(oldPlain)
+	assert.ErrorIs(t, err, ErrInvalidApiKey)
+
+	// only the owner can rotate
+	_, _, err = aks.Rotate("m2", old.ID.Hex(), time.Hour)
+	assert.Error(t, err)
+}
+
+func TestRotateCapsGracePeriod(t *testing.T) {
+	aks, now := newTestService()
+
+	old, _, err := aks.Create("m1", "", domain.ApiKeyModeLive)
+	require.NoError(t, err)
+
+	_, _, err = aks.Rotate("m1", old.ID.Hex(), 30*24*time.Hour)
+	require.NoError(t, err)
+
+	rotated, err := aks.apiKeyRepository.GetById("m1", old.ID.Hex())
+	require.NoError(t, err)
+	assert.True(t, now.Add(MaxRotationGracePeriod).Equal(rotated.ExpiresAt))
+}
+
+func TestRevoke(t *testing.T) {
+	aks, _ := newTestService()
+
+	key, plain, err := aks.Create("m1", "", domain.ApiKeyModeLive)
+	require.NoError(t, err)
+
+	_, err = aks.Revoke("m2", key.ID.Hex())
+	assert.Error(t, err, "only the owner can revoke")
+
+	revoked, err := aks.Revoke("m1", key.ID.Hex())
+	require.NoError(t, err)
+	assert.False(t, revoked.RevokedAt.IsZero())
+
+	_, err = aks.Authenticate(plain)
+	assert.ErrorIs(t, err, ErrInvalidApiKey)
+
+	_, err = aks.Revoke("m1", key.ID.Hex())
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+}
+
+func TestRevokeAll(t *testing.T) {
+	aks, _ := newTestService()
+
+	keys, err := aks.CreateDefaults("m1")
+	require.NoError(t, err)
+	_, other, err := aks.Create("m2", "", domain.ApiKeyModeLive)
+	require.NoError(t, err)
+
+	require.NoError(t, aks.RevokeAll("m1"))
+
+	for _, plain := range keys {
+		_, err = aks.Authenticate(plain)
+		assert.ErrorIs(t, err, ErrInvalidApiKey)
+	}
+	_, err = aks.Authenticate(other)
+	assert.NoError(t, err)
+}
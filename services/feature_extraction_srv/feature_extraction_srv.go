@@ -1,13 +1,17 @@
 package feature_extraction_srv
 
 import (
+	"context"
 	"errors"
 	"fraud-detect-system/domain"
 	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/tracing"
 	"math"
 	"time"
 )
 
+var tracer = tracing.Tracer("feature_extraction")
+
 const (
 	earthRadiusKm = 6371.0
 )
@@ -44,9 +48,12 @@ func calculateDistance(lat1, lng1, lat2, lng2 float64) float64 {
 	return distance
 }
 
-func (fes *FeatureExtractionService) ExtractLast24HourCount(currTransaction domain.Transaction) (int, int) {
+func (fes *FeatureExtractionService) ExtractLast24HourCount(ctx context.Context, currTransaction domain.Transaction) (int, int) {
+	ctx, span := tracer.Start(ctx, "FeatureExtractionService.ExtractLast24HourCount")
+	defer span.End()
+
 	// Assuming transactions is a slice of Transaction structs and currTransaction is the current transaction
-	transactions, _ := fes.getAllPreviousTransactions(currTransaction)
+	transactions, _ := fes.getAllPreviousTransactions(ctx, currTransaction)
 
 	last24hTransactionCount := 0
 	last24hFraudTransactionCount := 0
@@ -66,17 +73,17 @@ func (fes *FeatureExtractionService) ExtractLast24HourCount(currTransaction doma
 	return last24hTransactionCount, last24hFraudTransactionCount
 }
 
-func (fes *FeatureExtractionService) getAllPreviousTransactions(transaction domain.Transaction) ([]domain.Transaction, error) {
-	cardNumber := transaction.CreditCard
+func (fes *FeatureExtractionService) getAllPreviousTransactions(ctx context.Context, transaction domain.Transaction) ([]domain.Transaction, error) {
+	card := transaction.Card.Fingerprint
 
-	return fes.transactionRepository.GetAllWhereAccountIs(cardNumber)
+	return fes.transactionRepository.GetAllWhereAccountIs(ctx, card)
 }
 
-func (fes *FeatureExtractionService) getPreviousTransaction(transaction domain.Transaction) (domain.Transaction, error) {
+func (fes *FeatureExtractionService) getPreviousTransaction(ctx context.Context, transaction domain.Transaction) (domain.Transaction, error) {
 
-	cardNumber := transaction.CreditCard
+	card := transaction.Card.Fingerprint
 
-	transactions, err := fes.transactionRepository.GetAllWhereAccountIs(cardNumber)
+	transactions, err := fes.transactionRepository.GetAllWhereAccountIs(ctx, card)
 
 	if err != nil {
 		return domain.Transaction{}, err
@@ -89,13 +96,19 @@ func (fes *FeatureExtractionService) getPreviousTransaction(transaction domain.T
 	return transactions[len(transactions)-2], nil
 }
 
-func (fes *FeatureExtractionService) ExtractHour(currTransaction domain.Transaction) int {
+func (fes *FeatureExtractionService) ExtractHour(ctx context.Context, currTransaction domain.Transaction) int {
+	_, span := tracer.Start(ctx, "FeatureExtractionService.ExtractHour")
+	defer span.End()
+
 	return currTransaction.CreatedAt.Hour()
 }
 
-func (fes *FeatureExtractionService) ExtractAvgSpend(currTransaction domain.Transaction) float64 {
+func (fes *FeatureExtractionService) ExtractAvgSpend(ctx context.Context, currTransaction domain.Transaction) float64 {
+	ctx, span := tracer.Start(ctx, "FeatureExtractionService.ExtractAvgSpend")
+	defer span.End()
+
 	// Assuming you have access to the list of transactions for a credit card
-	transactions, _ := fes.getAllPreviousTransactions(currTransaction)
+	transactions, _ := fes.getAllPreviousTransactions(ctx, currTransaction)
 
 	totalAmount := 0.0
 	numWeeks := 0
@@ -117,14 +130,17 @@ func (fes *FeatureExtractionService) ExtractAvgSpend(currTransaction domain.Tran
 	return avgSpendPw
 }
 
-func (fes *FeatureExtractionService) ExtractTravelSpeed(currentTransaction domain.Transaction) float64 {
+func (fes *FeatureExtractionService) ExtractTravelSpeed(ctx context.Context, currentTransaction domain.Transaction) float64 {
+	ctx, span := tracer.Start(ctx, "FeatureExtractionService.ExtractTravelSpeed")
+	defer span.End()
+
 	// Assuming prevTransaction and currTransaction are instances of Transaction
 
 	//TODO: remove this
 	if currentTransaction.Ip == "127.0.0.1" {
 		return 0.5
 	}
-	previousTransaction, err := fes.getPreviousTransaction(currentTransaction)
+	previousTransaction, err := fes.getPreviousTransaction(ctx, currentTransaction)
 
 	if errors.Is(err, errors.New("not enough transactions")) {
 		return 0
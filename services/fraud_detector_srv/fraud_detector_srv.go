@@ -2,111 +2,143 @@ package fraud_detector_srv
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"fraud-detect-system/config"
 	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/clustering"
 	"fraud-detect-system/domain/markov"
 	"fraud-detect-system/domain/ports"
-	"github.com/muesli/clusters"
-	"github.com/muesli/kmeans"
+	"fraud-detect-system/metrics"
+	"fraud-detect-system/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
-	"os"
-	"sort"
+	"strings"
+	"time"
 )
 
-const (
-	ErrCannotCluster = "no valid for clustering yet"
-)
+var tracer = tracing.Tracer("fraud_detector")
 
 type FraudDetectorService struct {
 	transactionRepository ports.TransactionRepository
 	accountRepository     ports.AccountRepository
+
+	mlServerUrl string
+	mlRetries   int
+	client      *http.Client
+
+	logger  *slog.Logger
+	metrics *metrics.Metrics
 }
 
-func New(accountRepository ports.AccountRepository, transactionRepository ports.TransactionRepository) *FraudDetectorService {
+// mlRetryBackoff is multiplied by the attempt number between retries to the ml server.
+const mlRetryBackoff = 100 * time.Millisecond
+
+// New scores against the ml server, giving up on each call after its timeout and on a
+// prediction after its retries.
+func New(accountRepository ports.AccountRepository, transactionRepository ports.TransactionRepository, mlServer config.MLServer, logger *slog.Logger, metrics *metrics.Metrics) *FraudDetectorService {
 	return &FraudDetectorService{
 		transactionRepository: transactionRepository,
 		accountRepository:     accountRepository,
-	}
-}
 
-func (fds *FraudDetectorService) cluster(points []float64, k int) []float64 {
-	var d clusters.Observations
+		mlServerUrl: strings.TrimSuffix(mlServer.Url, "/") + "/",
+		mlRetries:   mlServer.Retries,
+		client:      &http.Client{Timeout: mlServer.Timeout},
 
-	for _, pt := range points {
-		d = append(d, clusters.Coordinates{
-			pt,
-			1.0,
-		})
+		logger:  logger.With("component", "fraud_detector"),
+		metrics: metrics,
 	}
+}
 
-	km := kmeans.New()
-	clusters, _ := km.Partition(d, k)
+// ErrNoProfile is DetectHMM's answer for accounts the profile jobs haven't trained an
+// HMM for yet. Score them without it.
+var ErrNoProfile = errors.New("account has no trained profile yet")
 
-	var corePoints []float64
+// TrainProfile works out the account's spending habits and trains its HMM on its valid
+// transactions, then saves it. With fewer than minHistory of them, or too few to
+// cluster, it is saved untrained.
+func (fds *FraudDetectorService) TrainProfile(ctx context.Context, account *domain.Account, minHistory int) (err error) {
+	ctx, span := tracer.Start(ctx, "FraudDetectorService.TrainProfile")
+	defer tracing.End(span, &err)
 
-	for _, c := range clusters {
-		corePoints = append(corePoints, c.Center[0])
+	transactions, err := fds.transactionRepository.GetAllValidTransactionWhereAccountIs(ctx, account.Card.Fingerprint)
+	if err != nil {
+		return err
 	}
 
-	//sort cluster points
-	sort.Float64s(corePoints)
-
-	return corePoints
-}
-
-func (fds *FraudDetectorService) classify(spendingHabits map[int]float64, amounts []float64) []int {
-	var labeledData []int
-
-	for _, amount := range amounts {
-		switch {
-		case amount >= spendingHabits[2]:
-			labeledData = append(labeledData, 2)
-		case amount >= spendingHabits[1]:
-			labeledData = append(labeledData, 1)
-		default:
-			labeledData = append(labeledData, 0)
-		}
+	account.Profile = domain.Profile{
+		Transactions: len(transactions),
+		ProfiledAt:   time.Now().UTC(),
 	}
 
-	return labeledData
-}
+	habits, err := clustering.ClusterTransactions(transactions)
+	if len(transactions) < max(minHistory, markov.NumOfObservations) || err == clustering.ErrCannotCluster {
+		return fds.accountRepository.Save(ctx, account)
+	}
+	if err != nil {
+		return err
+	}
 
-func (fds *FraudDetectorService) DetectHMM(account *domain.Account) (HMMPred, error) {
-	transactions, err := fds.transactionRepository.GetAllWhereAccountIs(account.CardNum)
+	hmm := markov.New(markov.NumStates, markov.NumOfObservableSymbols, markov.MaxIters)
+	hmm.SpendingHabits = habits
+	account.HMM = *hmm
 
-	if err != nil {
-		return HMMPred{}, err
+	symbols := make([]int, len(transactions))
+	for i, tx := range transactions {
+		symbols[i] = account.ClassifyTransaction(tx.Amt)
 	}
 
-	// remove the current transaction
-	transformedAmounts := transform(transactions)
+	// fit every window of the history in turn
+	for i := 0; i+markov.NumOfObservations <= len(symbols); i++ {
+		account.HMM.Fit(symbols[i : i+markov.NumOfObservations])
+	}
 
-	corePoints := fds.cluster(transformedAmounts, 3)
+	account.Profile.Trained = true
 
-	clusteredAmounts := fds.classify(map[int]float64{
-		0: corePoints[0], 1: corePoints[1], 2: corePoints[2]}, transformedAmounts)
+	return fds.accountRepository.Save(ctx, account)
+}
 
-	var obs [][]int
+// DetectHMM compares how the account's trained HMM sees its latest transactions with
+// and without the current one, the last of them. It trains nothing, an account without
+// a trained profile gets ErrNoProfile.
+func (fds *FraudDetectorService) DetectHMM(ctx context.Context, account *domain.Account) (_ HMMPred, err error) {
+	defer fds.metrics.ObserveScorer(metrics.ScorerHMM, time.Now())
 
-	currentTrans := clusteredAmounts[len(clusteredAmounts)-1]
-	clusteredAmounts = clusteredAmounts[:len(clusteredAmounts)-2]
+	ctx, span := tracer.Start(ctx, "FraudDetectorService.DetectHMM")
+	defer tracing.End(span, &err)
 
-	window := 10
+	if !account.Profile.Trained {
+		return HMMPred{}, ErrNoProfile
+	}
 
-	for i := 0; i < len(clusteredAmounts)-window; i++ {
-		obs = append(obs, clusteredAmounts[i:i+window])
+	transactions, err := fds.transactionRepository.GetAllWhereAccountIs(ctx, account.Card.Fingerprint)
+	if err != nil {
+		return HMMPred{}, err
 	}
 
-	hmm := fds.initializeAccountHMM()
+	if len(transactions) <= markov.NumOfObservations {
+		return HMMPred{}, ErrNoProfile
+	}
 
-	for i := 0; i < len(obs); i++ {
-		hmm.Fit(obs[i])
+	// the window before the current transaction and the one ending with it
+	latest := transactions[len(transactions)-markov.NumOfObservations-1:]
+	symbols := make([]int, len(latest))
+	for i, tx := range latest {
+		symbols[i] = account.ClassifyTransaction(tx.Amt)
 	}
 
-	prevSequence := obs[len(obs)-1]
-	currSequence := append(prevSequence[1:], currentTrans)
+	prevSequence := symbols[:markov.NumOfObservations]
+	currSequence := symbols[1:]
+
+	hmm := account.HMM
 
 	_, x, _ := hmm.DetectFraud(prevSequence)
 	_, y, avgLikelihood := hmm.DetectFraud(currSequence)
@@ -119,10 +151,6 @@ func (fds *FraudDetectorService) DetectHMM(account *domain.Account) (HMMPred, er
 		isFraud = false
 	}
 
-	//if math.IsNaN(fraudProb) {
-	//	fraudProb = 0
-	//}
-
 	return HMMPred{
 		IsFraud:       isFraud,
 		FraudProb:     y,
@@ -130,20 +158,13 @@ func (fds *FraudDetectorService) DetectHMM(account *domain.Account) (HMMPred, er
 	}, nil
 }
 
-func transform(txs []domain.Transaction) (amounts []float64) {
-	for _, tx := range txs {
-		amounts = append(amounts, tx.Amt)
-	}
-	return
-}
+func (fds *FraudDetectorService) DetectXGB(ctx context.Context, features XGBFeatures) (_ XGBPred, err error) {
+	defer fds.metrics.ObserveScorer(metrics.ScorerXGB, time.Now())
 
-func (fds *FraudDetectorService) initializeAccountHMM() markov.HMM {
-	// initialize HMM
-	return *markov.New(markov.NumStates, markov.NumOfObservableSymbols, markov.MaxIters)
-}
+	ctx, span := tracer.Start(ctx, "FraudDetectorService.DetectXGB")
+	defer tracing.End(span, &err)
 
-func (fds *FraudDetectorService) DetectXGB(features XGBFeatures) (XGBPred, error) {
-	return detectFraudXGB(features)
+	return fds.detectFraudXGB(ctx, features)
 }
 
 type EnsembleResult struct {
@@ -183,38 +204,114 @@ type HMMPred struct {
 	AvgLikelihood float64 `json:"avg_likelihood"`
 }
 
-func detectFraudXGB(features XGBFeatures) (XGBPred, error) {
-	url := os.Getenv("ML_SERVER_URL_DEV") + "predict/xgb"
+var ErrModelNotLoaded = errors.New("ml server has no model loaded")
 
-	jsonData, err := json.Marshal(features)
+// Ready checks the ml server answers its health endpoint and, if it says, that the
+// xgb model is loaded.
+func (fds *FraudDetectorService) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fds.mlServerUrl+"health", nil)
 	if err != nil {
-		return XGBPred{}, err
+		return err
+	}
+
+	resp, err := fds.client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ml server health returned %d", resp.StatusCode)
+	}
 
-	req.Header.Set("Content-Type", "application/json")
+	health := struct {
+		ModelLoaded *bool `json:"model_loaded"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&health); err == nil && health.ModelLoaded != nil && !*health.ModelLoaded {
+		return ErrModelNotLoaded
+	}
+
+	return nil
+}
+
+var errMLServerUnavailable = errors.New("ml server unavailable")
 
-	resp, err := http.DefaultClient.Do(req)
+func (fds *FraudDetectorService) detectFraudXGB(ctx context.Context, features XGBFeatures) (XGBPred, error) {
+	jsonData, err := json.Marshal(features)
 	if err != nil {
 		return XGBPred{}, err
 	}
 
-	responseStream, err := io.ReadAll(resp.Body)
+	var responseStream []byte
+	for attempt := 0; ; attempt++ {
+		responseStream, err = fds.postPrediction(ctx, "predict/xgb", jsonData)
+		if err == nil || !errors.Is(err, errMLServerUnavailable) || attempt >= fds.mlRetries {
+			break
+		}
+
+		fds.metrics.MLRetry()
+		fds.logger.Warn("retrying xgb prediction", "attempt", attempt+1, "error", err)
+		time.Sleep(time.Duration(attempt+1) * mlRetryBackoff)
+	}
 	if err != nil {
 		return XGBPred{}, err
 	}
 
 	var xgbPred XGBPred
-	print(string(responseStream))
 	err = json.Unmarshal(responseStream, &xgbPred)
+	if err == nil && len(xgbPred.Probability) < 2 {
+		err = errors.New("xgb prediction has no fraud probability")
+	}
 	if err != nil {
+		fds.metrics.MLError(metrics.MLErrorDecode)
+		fds.logger.Error("unreadable xgb prediction", "error", err)
 		return XGBPred{}, err
 	}
 
+	fds.logger.Debug("xgb prediction", "prediction", xgbPred.Prediction, "probability", xgbPred.Probability)
+
 	return xgbPred, nil
 }
 
+// postPrediction returns the ml server's response body. Failures worth retrying wrap
+// errMLServerUnavailable.
+func (fds *FraudDetectorService) postPrediction(ctx context.Context, path string, body []byte) (_ []byte, err error) {
+	ctx, span := tracer.Start(ctx, "POST "+path, trace.WithSpanKind(trace.SpanKindClient))
+	defer tracing.End(span, &err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fds.mlServerUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := fds.client.Do(req)
+	if err != nil {
+		fds.metrics.MLError(metrics.MLErrorRequest)
+		return nil, fmt.Errorf("%w: %v", errMLServerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	responseStream, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fds.metrics.MLError(metrics.MLErrorRequest)
+		return nil, fmt.Errorf("%w: %v", errMLServerUnavailable, err)
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		fds.metrics.MLError(metrics.MLErrorStatus)
+		err = fmt.Errorf("ml server %s returned %d", path, resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%w: %v", errMLServerUnavailable, err)
+		}
+		return nil, err
+	}
+
+	return responseStream, nil
+}
+
 // should be able to do xgb
 
 // should be able to do avg of xgb and hmm
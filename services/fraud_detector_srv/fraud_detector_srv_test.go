@@ -0,0 +1,95 @@
+package fraud_detector_srv
+
+import (
+	"context"
+	"encoding/json"
+	"fraud-detect-system/config"
+	"fraud-detect-system/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestService(url string, retries int) *FraudDetectorService {
+	return New(nil, nil, config.MLServer{Url: url, Timeout: time.Second, Retries: retries}, slog.Default(), metrics.New())
+}
+
+func TestDetectXGBRetriesServerErrors(t *testing.T) {
+	calls := 0
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/predict/xgb", r.URL.Path)
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"prediction": 1, "probability": [0.3, 0.7]}`))
+	}))
+	defer ml.Close()
+
+	pred, err := newTestService(ml.URL, 2).DetectXGB(context.TODO(), XGBFeatures{Amount: 120})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []float64{0.3, 0.7}, pred.Probability)
+}
+
+func TestDetectXGBSendsOnlyTheTrainedFeatures(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var features map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&features))
+
+		names := make([]string, 0, len(features))
+		for name := range features {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, []string{"hour", "amt", "categoryIndex", "travel_speed", "avg_spend_pw",
+			"last_24h_transaction_count", "last_24h_fraud_transaction_count"}, names)
+
+		w.Write([]byte(`{"prediction": 0, "probability": [0.9, 0.1]}`))
+	}))
+	defer ml.Close()
+
+	_, err := newTestService(ml.URL, 0).DetectXGB(context.TODO(), XGBFeatures{Amount: 120})
+	require.NoError(t, err)
+}
+
+func TestDetectXGBGivesUp(t *testing.T) {
+	calls := 0
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ml.Close()
+
+	_, err := newTestService(ml.URL, 1).DetectXGB(context.TODO(), XGBFeatures{})
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestDetectXGBDoesNotRetryBadRequests(t *testing.T) {
+	calls := 0
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer ml.Close()
+
+	_, err := newTestService(ml.URL, 3).DetectXGB(context.TODO(), XGBFeatures{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDetectXGBRejectsPredictionWithoutProbabilities(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prediction": 0, "probability": []}`))
+	}))
+	defer ml.Close()
+
+	_, err := newTestService(ml.URL, 0).DetectXGB(context.TODO(), XGBFeatures{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package login_guard_srv
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// failures allowed before each further attempt has to wait
+	freeAttempts = 3
+	baseBackoff  = time.Second
+	maxBackoff   = 5 * time.Minute
+
+	// failures before the email or ip is locked out entirely
+	MaxEmailFailures = 10
+	MaxIpFailures    = 50
+	LockoutDuration  = 15 * time.Minute
+
+	// counters reset after this long without a failure
+	failureWindow = time.Hour
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many login attempts, try again later")
+	ErrLocked          = errors.New("account temporarily locked after too many failed logins")
+)
+
+// LoginGuardService throttles and locks out logins by email and by ip. The counters
+// are kept through the LoginCounterRepository, so every process, and every Lambda
+// container, counts against the same limits.
+type LoginGuardService struct {
+	loginAttemptRepository ports.LoginAttemptRepository
+	loginCounterRepository ports.LoginCounterRepository
+
+	now func() time.Time
+}
+
+func New(loginAttemptRepository ports.LoginAttemptRepository, loginCounterRepository ports.LoginCounterRepository) *LoginGuardService {
+	return &LoginGuardService{
+		loginAttemptRepository: loginAttemptRepository,
+		loginCounterRepository: loginCounterRepository,
+		now:                    time.Now,
+	}
+}
+
+// Check reports whether a login for email from ip may go ahead. When it may not,
+// the returned duration is how long the caller should wait before retrying.
+func (lgs *LoginGuardService) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := lgs.now()
+	var wait time.Duration
+	var reason error
+
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		counter, found, err := lgs.loginCounterRepository.Get(ctx, key, now)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			continue
+		}
+
+		if now.Before(counter.LockedUntil) {
+			if d := counter.LockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+			reason = ErrLocked
+			continue
+		}
+
+		if d := counter.LastFailure.Add(backoff(counter.Failures)).Sub(now); d > 0 && d > wait {
+			wait = d
+			if reason == nil {
+				reason = ErrTooManyAttempts
+			}
+		}
+	}
+
+	return wait, reason
+}
+
+// Fail records a failed login, advancing the backoff and locking the email or ip
+// once they cross their limits. The attempt is kept as an audit record.
+func (lgs *LoginGuardService) Fail(ctx context.Context, attempt domain.LoginAttempt) error {
+	now := lgs.now()
+	if err := lgs.fail(ctx, emailKey(attempt.Email), MaxEmailFailures, now); err != nil {
+		return err
+	}
+	if err := lgs.fail(ctx, ipKey(attempt.Ip), MaxIpFailures, now); err != nil {
+		return err
+	}
+
+	_, err := lgs.loginAttemptRepository.Add(attempt)
+	return err
+}
+
+// Reject keeps the audit record of a login refused by Check without counting it as another failure.
+func (lgs *LoginGuardService) Reject(attempt domain.LoginAttempt) error {
+	_, err := lgs.loginAttemptRepository.Add(attempt)
+	return err
+}
+
+// Succeed clears the failures recorded against email. The ip keeps its count so a
+// single address can't reset itself by logging into an account it owns.
+func (lgs *LoginGuardService) Succeed(ctx context.Context, email string) error {
+	return lgs.loginCounterRepository.Delete(ctx, emailKey(email))
+}
+
+func (lgs *LoginGuardService) GetFailedAttempts(merchant string) ([]domain.LoginAttempt, error) {
+	return lgs.loginAttemptRepository.GetAllWhereMerchantIs(merchant)
+}
+
+func (lgs *LoginGuardService) fail(ctx context.Context, key string, limit int, now time.Time) error {
+	counter, err := lgs.loginCounterRepository.AddFailure(ctx, key, now, failureWindow)
+	if err != nil {
+		return err
+	}
+
+	if counter.Failures >= limit {
+		return lgs.loginCounterRepository.Lock(ctx, key, now.Add(LockoutDuration))
+	}
+
+	return nil
+}
+
+// backoff doubles the wait for every failure past the free attempts.
+func backoff(failures int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	d := time.Duration(float64(baseBackoff) * math.Pow(2, float64(failures-freeAttempts)))
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
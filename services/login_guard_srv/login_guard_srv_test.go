@@ -0,0 +1,112 @@
+package login_guard_srv
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type attemptRepo struct {
+	attempts []domain.LoginAttempt
+}
+
+func (r *attemptRepo) Add(attempt domain.LoginAttempt) (domain.LoginAttempt, error) {
+	r.attempts = append(r.attempts, attempt)
+	return attempt, nil
+}
+
+func (r *attemptRepo) GetAllWhereMerchantIs(merchant string) ([]domain.LoginAttempt, error) {
+	return r.attempts, nil
+}
+
+var ctx = context.Background()
+
+func TestLoginGuard_BackoffAndLockout(t *testing.T) {
+	repo := &attemptRepo{}
+	guard := New(repo, memory.NewLoginCounterStorage())
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	guard.now = func() time.Time { return now }
+
+	attempt := domain.LoginAttempt{Email: "shop@example.com", Ip: "10.0.0.1"}
+
+	for i := 0; i < freeAttempts-1; i++ {
+		_ = guard.Fail(ctx, attempt)
+		_, err := guard.Check(ctx, attempt.Email, attempt.Ip)
+		assert.NoError(t, err, "the first failures are free")
+	}
+
+	_ = guard.Fail(ctx, attempt)
+	wait, err := guard.Check(ctx, attempt.Email, attempt.Ip)
+	assert.Equal(t, ErrTooManyAttempts, err)
+	assert.Equal(t, baseBackoff, wait)
+
+	now = now.Add(wait)
+	_ = guard.Fail(ctx, attempt)
+	wait, _ = guard.Check(ctx, attempt.Email, attempt.Ip)
+	assert.Equal(t, 2*baseBackoff, wait, "backoff doubles with each failure")
+
+	for i := freeAttempts + 1; i < MaxEmailFailures; i++ {
+		now = now.Add(maxBackoff)
+		_ = guard.Fail(ctx, attempt)
+	}
+
+	wait, err = guard.Check(ctx, attempt.Email, "10.0.0.2")
+	assert.Equal(t, ErrLocked, err, "the email is locked from any ip")
+	assert.Equal(t, LockoutDuration, wait)
+
+	now = now.Add(LockoutDuration)
+	_, err = guard.Check(ctx, attempt.Email, "10.0.0.2")
+	assert.NoError(t, err)
+
+	assert.Len(t, repo.attempts, MaxEmailFailures)
+}
+
+func TestLoginGuard_SucceedResetsEmailOnly(t *testing.T) {
+	guard := New(&attemptRepo{}, memory.NewLoginCounterStorage())
+
+	attempt := domain.LoginAttempt{Email: "shop@example.com", Ip: "10.0.0.1"}
+	for i := 0; i < freeAttempts; i++ {
+		_ = guard.Fail(ctx, attempt)
+	}
+
+	require.NoError(t, guard.Succeed(ctx, attempt.Email))
+
+	_, err := guard.Check(ctx, attempt.Email, "10.0.0.2")
+	assert.NoError(t, err)
+
+	_, err = guard.Check(ctx, "other@example.com", attempt.Ip)
+	assert.Equal(t, ErrTooManyAttempts, err, "the ip keeps its failures")
+}
+
+func TestLoginGuard_InstancesShareCounters(t *testing.T) {
+	counters := memory.NewLoginCounterStorage()
+	first, second := New(&attemptRepo{}, counters), New(&attemptRepo{}, counters)
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	first.now = func() time.Time { return now }
+	second.now = first.now
+
+	// failures spread over two instances add up to one lockout
+	attempt := domain.LoginAttempt{Email: "shop@example.com", Ip: "10.0.0.1"}
+	for i := 0; i < MaxEmailFailures; i++ {
+		guard := first
+		if i%2 == 1 {
+			guard = second
+		}
+		now = now.Add(maxBackoff)
+		require.NoError(t, guard.Fail(ctx, attempt))
+	}
+
+	wait, err := first.Check(ctx, attempt.Email, "10.0.0.2")
+	assert.Equal(t, ErrLocked, err)
+	assert.Equal(t, LockoutDuration, wait)
+
+	wait, err = second.Check(ctx, attempt.Email, "10.0.0.2")
+	assert.Equal(t, ErrLocked, err)
+	assert.Equal(t, LockoutDuration, wait)
+}
@@ -0,0 +1,188 @@
+package notification_srv
+
+import (
+	"context"
+	"fmt"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	// a merchant hears about the model being down at most this often
+	degradedInterval = time.Hour
+
+	// notifications a slow stream can fall behind by before it starts missing them
+	subscriberBuffer = 16
+)
+
+// NotificationService keeps each merchant's inbox and pushes new notifications to the
+// dashboards that are streaming them. Streams only see notifications made by this
+// process.
+type NotificationService struct {
+	notificationRepository ports.NotificationRepository
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan domain.Notification]struct{}
+	degradedAt  map[string]time.Time
+	closed      bool
+
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func New(notificationRepository ports.NotificationRepository, logger *slog.Logger) *NotificationService {
+	return &NotificationService{
+		notificationRepository: notificationRepository,
+
+		subscribers: make(map[string]map[chan domain.Notification]struct{}),
+		degradedAt:  make(map[string]time.Time),
+
+		logger: logger.With("component", "notifications"),
+		now:    time.Now,
+	}
+}
+
+// Notify stores the notification and pushes it to the merchant's open streams.
+func (ns *NotificationService) Notify(ctx context.Context, notification domain.Notification) error {
+	notification, err := ns.notificationRepository.Add(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for ch := range ns.subscribers[notification.Merchant] {
+		select {
+		case ch <- notification:
+		default:
+			ns.logger.Warn("notification stream is behind, dropping", "merchant", notification.Merchant, "notification", notification.ID.Hex())
+		}
+	}
+
+	return nil
+}
+
+// Subscribe streams the merchant's new notifications until cancel is called, which
+// closes the channel.
+func (ns *NotificationService) Subscribe(merchant string) (<-chan domain.Notification, func()) {
+	ch := make(chan domain.Notification, subscriberBuffer)
+
+	ns.mu.Lock()
+	if ns.closed {
+		ns.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if ns.subscribers[merchant] == nil {
+		ns.subscribers[merchant] = make(map[chan domain.Notification]struct{})
+	}
+	ns.subscribers[merchant][ch] = struct{}{}
+	ns.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			ns.mu.Lock()
+			defer ns.mu.Unlock()
+
+			if _, ok := ns.subscribers[merchant][ch]; !ok {
+				return
+			}
+			close(ch)
+			delete(ns.subscribers[merchant], ch)
+			if len(ns.subscribers[merchant]) == 0 {
+				delete(ns.subscribers, merchant)
+			}
+		})
+	}
+
+	return ch, cancel
+}
+
+// Close ends every open stream, and any opened after, so shutdown doesn't wait on
+// dashboards that never disconnect.
+func (ns *NotificationService) Close() {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.closed = true
+	for merchant, subscribers := range ns.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(ns.subscribers, merchant)
+	}
+}
+
+// List pages through the inbox newest first, see NotificationRepository.
+func (ns *NotificationService) List(ctx context.Context, merchant string, unreadOnly bool, before string, limit int) ([]domain.Notification, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	return ns.notificationRepository.GetAllWhereMerchantIs(ctx, merchant, unreadOnly, before, limit)
+}
+
+func (ns *NotificationService) CountUnread(ctx context.Context, merchant string) (int, error) {
+	return ns.notificationRepository.CountUnread(ctx, merchant)
+}
+
+// MarkRead marks the given notifications read, or the whole inbox when ids is empty.
+func (ns *NotificationService) MarkRead(ctx context.Context, merchant string, ids []string) error {
+	return ns.notificationRepository.MarkRead(ctx, merchant, ids)
+}
+
+// TransactionFlagged tells the merchant about a declined or reviewed transaction.
+func (ns *NotificationService) TransactionFlagged(ctx context.Context, transaction domain.Transaction) error {
+	notification := domain.Notification{
+		Merchant:    transaction.Merchant,
+		Transaction: transaction.ID.Hex(),
+	}
+
+	switch transaction.Decision {
+	case domain.DecisionDecline:
+		notification.Type = domain.NotificationHighRisk
+		notification.Title = "High risk transaction declined"
+	case domain.DecisionReview:
+		notification.Type = domain.NotificationReview
+		notification.Title = "Transaction needs review"
+	default:
+		return nil
+	}
+
+	notification.Message = fmt.Sprintf("%.2f from %s, risk score %.2f", transaction.Amt, transaction.Email, transaction.RiskScore)
+
+	return ns.Notify(ctx, notification)
+}
+
+// ModelDegraded tells the merchant their transactions can't be scored by the model,
+// once per degradedInterval however many fail.
+func (ns *NotificationService) ModelDegraded(ctx context.Context, merchant string, cause error) error {
+	now := ns.now()
+
+	ns.mu.Lock()
+	last, seen := ns.degradedAt[merchant]
+	if seen && now.Sub(last) < degradedInterval {
+		ns.mu.Unlock()
+		return nil
+	}
+	ns.degradedAt[merchant] = now
+	ns.mu.Unlock()
+
+	return ns.Notify(ctx, domain.Notification{
+		Merchant: merchant,
+		Type:     domain.NotificationModelDegraded,
+		Title:    "Fraud model unavailable",
+		Message:  "Transactions could not be scored: " + cause.Error(),
+	})
+}
@@ -0,0 +1,92 @@
+package notification_srv
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestService() *NotificationService {
+	return New(memory.NewNotificationStorage(), slog.Default())
+}
+
+func TestSubscribersOnlySeeTheirOwnNotifications(t *testing.T) {
+	ns := newTestService()
+	ctx := context.Background()
+
+	mine, cancel := ns.Subscribe("m1")
+	defer cancel()
+	theirs, cancelTheirs := ns.Subscribe("m2")
+	defer cancelTheirs()
+
+	flagged := domain.Transaction{Merchant: "m1", Decision: domain.DecisionDecline}
+	flagged.ID = primitive.NewObjectID()
+	require.NoError(t, ns.TransactionFlagged(ctx, flagged))
+
+	select {
+	case n := <-mine:
+		assert.Equal(t, domain.NotificationHighRisk, n.Type)
+		assert.Equal(t, flagged.ID.Hex(), n.Transaction)
+		assert.False(t, n.ID.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("subscriber did not get the notification")
+	}
+
+	assert.Empty(t, theirs)
+
+	unread, err := ns.CountUnread(ctx, "m1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, unread)
+}
+
+func TestApprovedTransactionsAreNotNotified(t *testing.T) {
+	ns := newTestService()
+
+	require.NoError(t, ns.TransactionFlagged(context.Background(), domain.Transaction{Merchant: "m1", Decision: domain.DecisionApprove}))
+
+	unread, err := ns.CountUnread(context.Background(), "m1")
+	require.NoError(t, err)
+	assert.Zero(t, unread)
+}
+
+func TestModelDegradedIsThrottled(t *testing.T) {
+	ns := newTestService()
+	ctx := context.Background()
+
+	now := time.Now()
+	ns.now = func() time.Time { return now }
+
+	cause := errors.New("ml server unreachable")
+	require.NoError(t, ns.ModelDegraded(ctx, "m1", cause))
+	require.NoError(t, ns.ModelDegraded(ctx, "m1", cause))
+	require.NoError(t, ns.ModelDegraded(ctx, "m2", cause))
+
+	now = now.Add(degradedInterval)
+	require.NoError(t, ns.ModelDegraded(ctx, "m1", cause))
+
+	inbox, err := ns.List(ctx, "m1", false, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, inbox, 2)
+}
+
+func TestCloseEndsStreams(t *testing.T) {
+	ns := newTestService()
+
+	stream, cancel := ns.Subscribe("m1")
+	ns.Close()
+	cancel()
+
+	_, open := <-stream
+	assert.False(t, open)
+
+	late, _ := ns.Subscribe("m1")
+	_, open = <-late
+	assert.False(t, open)
+}
@@ -0,0 +1,267 @@
+package profile_srv
+
+import (
+	"context"
+	"fraud-detect-system/config"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/metrics"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	MaxAttempts  = 5
+	baseBackoff  = time.Minute
+	maxBackoff   = time.Hour
+	claimLease   = 10 * time.Minute
+	pollInterval = 10 * time.Second
+
+	// how often Run looks for profiles older than the max age, and how many accounts of
+	// each partition it queues each time
+	scheduleInterval = time.Hour
+	scheduleBatch    = 500
+)
+
+// Partition is the accounts of the live or sandbox partition and what profiles them.
+type Partition struct {
+	Accounts ports.AccountRepository
+	Profiler ports.Profiler
+}
+
+// ProfileService keeps accounts' spending habits and hmms up to date in the background,
+// so scoring only has to read them. Jobs are queued when an account has had enough new
+// transactions or its profile gets old, and run a few at a time with retries.
+type ProfileService struct {
+	jobs    ports.ProfileJobRepository
+	live    Partition
+	sandbox Partition
+
+	config     config.Profiles
+	minHistory int
+
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+	now     func() time.Time
+}
+
+// New profiles accounts once they have minHistory valid transactions, before that
+// their profile only records that there aren't enough.
+func New(jobs ports.ProfileJobRepository, live Partition, sandbox Partition, profiles config.Profiles, minHistory int, logger *slog.Logger, metrics *metrics.Metrics) *ProfileService {
+	return &ProfileService{
+		jobs:    jobs,
+		live:    live,
+		sandbox: sandbox,
+
+		config:     profiles,
+		minHistory: minHistory,
+
+		logger:  logger.With("component", "profiles"),
+		metrics: metrics,
+		now:     time.Now,
+	}
+}
+
+// TransactionAdded queues a job for the account once it has RetrainAfter more valid
+// transactions than its profile was worked out from.
+func (ps *ProfileService) TransactionAdded(ctx context.Context, livemode bool, account domain.Account, validTransactions int) error {
+	if validTransactions-account.Profile.Transactions < ps.config.RetrainAfter {
+		return nil
+	}
+
+	_, err := ps.Enqueue(ctx, livemode, account.Card.Fingerprint, domain.ProfileReasonTransactions)
+	return err
+}
+
+// Enqueue queues a job for the account of the card with the given fingerprint, unless
+// it has one pending already, and reports whether it did.
+func (ps *ProfileService) Enqueue(ctx context.Context, livemode bool, card string, reason string) (bool, error) {
+	return ps.jobs.Enqueue(ctx, domain.ProfileJob{
+		Card:          card,
+		Livemode:      livemode,
+		Reason:        reason,
+		Status:        domain.ProfileJobPending,
+		NextAttemptAt: ps.now().UTC(),
+	})
+}
+
+// Run queues the old profiles and runs due jobs until ctx is cancelled.
+func (ps *ProfileService) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var scheduled time.Time
+	for {
+		if ps.now().Sub(scheduled) >= scheduleInterval {
+			if _, err := ps.ScheduleStale(ctx); err != nil {
+				ps.logger.Error("cannot queue stale profiles", "error", err)
+			}
+			scheduled = ps.now()
+		}
+
+		if _, err := ps.ProcessDue(ctx); err != nil {
+			ps.logger.Error("cannot process due profile jobs", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScheduleStale queues jobs for accounts whose profile is older than MaxAge, or that
+// were never profiled, and reports how many it queued.
+func (ps *ProfileService) ScheduleStale(ctx context.Context) (int, error) {
+	queued := 0
+	before := ps.now().Add(-ps.config.MaxAge)
+
+	for _, livemode := range []bool{true, false} {
+		accounts, err := ps.partition(livemode).Accounts.GetProfiledBefore(ctx, before, scheduleBatch)
+		if err != nil {
+			return queued, err
+		}
+
+		for _, account := range accounts {
+			added, err := ps.Enqueue(ctx, livemode, account.Card.Fingerprint, domain.ProfileReasonSchedule)
+			if err != nil {
+				return queued, err
+			}
+			if added {
+				queued++
+			}
+		}
+	}
+
+	return queued, nil
+}
+
+// ProcessDue runs every job that is due now, up to Workers at a time, and reports how
+// many it ran. It stops claiming jobs once ctx is done.
+func (ps *ProfileService) ProcessDue(ctx context.Context) (int, error) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	slots := make(chan struct{}, ps.config.Workers)
+	ran := 0
+
+	for {
+		// a job is only claimed once there's a worker free to run it
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ran, nil
+		}
+		if ctx.Err() != nil {
+			return ran, nil
+		}
+
+		job, ok, err := ps.jobs.ClaimDue(ctx, ps.now().UTC(), claimLease)
+		if err != nil || !ok {
+			return ran, err
+		}
+		ran++
+
+		wg.Add(1)
+		go func(job domain.ProfileJob) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			if err := ps.run(ctx, &job); err != nil {
+				ps.logger.Error("cannot save profile job", "job", job.ID.Hex(), "error", err)
+			}
+		}(job)
+	}
+}
+
+func (ps *ProfileService) run(ctx context.Context, job *domain.ProfileJob) error {
+	start := ps.now()
+	job.Attempts++
+
+	err := ps.profile(ctx, *job)
+
+	switch {
+	case err == nil:
+		job.Status = domain.ProfileJobDone
+		job.FinishedAt = ps.now().UTC()
+		job.LastError = ""
+	case job.Attempts >= MaxAttempts:
+		job.Status = domain.ProfileJobFailed
+		job.FinishedAt = ps.now().UTC()
+		job.LastError = err.Error()
+		ps.logger.Warn("giving up on profile job", "job", job.ID.Hex(), "attempts", job.Attempts, "error", err)
+	default:
+		job.Status = domain.ProfileJobRetry
+		job.NextAttemptAt = ps.now().UTC().Add(backoff(job.Attempts))
+		job.LastError = err.Error()
+	}
+
+	ps.metrics.ObserveProfileJob(job.Status, start)
+
+	return ps.jobs.Save(ctx, job)
+}
+
+func (ps *ProfileService) profile(ctx context.Context, job domain.ProfileJob) error {
+	p := ps.partition(job.Livemode)
+
+	account, err := p.Accounts.Get(ctx, job.Card)
+	if err == mongo.ErrNoDocuments {
+		// nothing left to profile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return p.Profiler.TrainProfile(ctx, &account, ps.minHistory)
+}
+
+func (ps *ProfileService) partition(livemode bool) Partition {
+	if livemode {
+		return ps.live
+	}
+
+	return ps.sandbox
+}
+
+// Jobs returns up to limit jobs, newest first, only those with status if it is set,
+// and how many jobs there are of each status.
+func (ps *ProfileService) Jobs(ctx context.Context, status string, limit int) ([]domain.ProfileJob, map[string]int, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	jobs, err := ps.jobs.Find(ctx, status, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counts, err := ps.jobs.CountByStatus(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return jobs, counts, nil
+}
+
+// backoff doubles the wait after every failed attempt.
+func backoff(attempts int) time.Duration {
+	d := time.Duration(float64(baseBackoff) * math.Pow(2, float64(attempts-1)))
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
@@ -0,0 +1,190 @@
+package profile_srv
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/config"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/metrics"
+	"fraud-detect-system/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// profiler marks accounts profiled, or fails with err, keeping count of how many it
+// profiles at once.
+type profiler struct {
+	accounts *memory.AccountStorage
+	err      error
+	delay    time.Duration
+
+	running, most atomic.Int32
+	mu            sync.Mutex
+	profiled      []string
+}
+
+func (p *profiler) TrainProfile(ctx context.Context, account *domain.Account, minHistory int) error {
+	running := p.running.Add(1)
+	defer p.running.Add(-1)
+	for {
+		most := p.most.Load()
+		if running <= most || p.most.CompareAndSwap(most, running) {
+			break
+		}
+	}
+	time.Sleep(p.delay)
+
+	if p.err != nil {
+		return p.err
+	}
+
+	p.mu.Lock()
+	p.profiled = append(p.profiled, account.Card.Fingerprint)
+	p.mu.Unlock()
+
+	account.Profile = domain.Profile{Trained: true, ProfiledAt: time.Now().UTC()}
+	return p.accounts.Save(ctx, account)
+}
+
+func newTestService(t *testing.T, workers int, p *profiler, cards ...string) *ProfileService {
+	p.accounts = memory.NewAccountStorage()
+	for _, card := range cards {
+		_, err := p.accounts.Create(context.Background(), domain.Account{Card: domain.Card{Fingerprint: card}})
+		require.NoError(t, err)
+	}
+
+	part := Partition{Accounts: p.accounts, Profiler: p}
+	return New(memory.NewProfileJobStorage(), part, Partition{Accounts: memory.NewAccountStorage(), Profiler: p},
+		config.Profiles{RetrainAfter: 10, MaxAge: 24 * time.Hour, Workers: workers}, 20, slog.Default(), metrics.New())
+}
+
+func TestTransactionAddedQueuesAfterRetrainAfter(t *testing.T) {
+	p := &profiler{}
+	ps := newTestService(t, 1, p, "fp1")
+	ctx := context.Background()
+
+	account := domain.Account{Card: domain.Card{Fingerprint: "fp1"}, Profile: domain.Profile{Transactions: 25}}
+
+	require.NoError(t, ps.TransactionAdded(ctx, true, account, 34))
+	ran, err := ps.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, ran)
+
+	require.NoError(t, ps.TransactionAdded(ctx, true, account, 35))
+	require.NoError(t, ps.TransactionAdded(ctx, true, account, 36))
+
+	jobs, counts, err := ps.Jobs(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "triggers for the same account share the pending job")
+	assert.Equal(t, domain.ProfileReasonTransactions, jobs[0].Reason)
+	assert.Equal(t, map[string]int{domain.ProfileJobPending: 1}, counts)
+
+	ran, err = ps.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ran)
+	assert.Equal(t, []string{"fp1"}, p.profiled)
+
+	profiled, err := p.accounts.Get(ctx, "fp1")
+	require.NoError(t, err)
+	assert.True(t, profiled.Profile.Trained)
+
+	jobs, _, err = ps.Jobs(ctx, domain.ProfileJobDone, 0)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.False(t, jobs[0].FinishedAt.IsZero())
+}
+
+func TestProcessDueBoundsConcurrency(t *testing.T) {
+	cards := []string{"fp1", "fp2", "fp3", "fp4", "fp5", "fp6", "fp7", "fp8"}
+	p := &profiler{delay: 20 * time.Millisecond}
+	ps := newTestService(t, 3, p, cards...)
+	ctx := context.Background()
+
+	for _, card := range cards {
+		_, err := ps.Enqueue(ctx, true, card, domain.ProfileReasonSchedule)
+		require.NoError(t, err)
+	}
+
+	ran, err := ps.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(cards), ran)
+	assert.ElementsMatch(t, cards, p.profiled)
+	assert.LessOrEqual(t, p.most.Load(), int32(3))
+	assert.Greater(t, p.most.Load(), int32(1))
+}
+
+func TestFailedJobsRetryThenGiveUp(t *testing.T) {
+	p := &profiler{err: errors.New("ml server down")}
+	ps := newTestService(t, 1, p, "fp1")
+	ctx := context.Background()
+
+	now := time.Now()
+	ps.now = func() time.Time { return now }
+
+	_, err := ps.Enqueue(ctx, true, "fp1", domain.ProfileReasonSchedule)
+	require.NoError(t, err)
+
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		ran, err := ps.ProcessDue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, ran)
+
+		jobs, _, err := ps.Jobs(ctx, domain.ProfileJobRetry, 0)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, attempt, jobs[0].Attempts)
+		assert.Equal(t, "ml server down", jobs[0].LastError)
+
+		// not due again until the backoff is over
+		ran, err = ps.ProcessDue(ctx)
+		require.NoError(t, err)
+		require.Zero(t, ran)
+
+		now = now.Add(backoff(attempt))
+	}
+
+	ran, err := ps.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, ran)
+
+	_, counts, err := ps.Jobs(ctx, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{domain.ProfileJobFailed: 1}, counts)
+}
+
+func TestScheduleStaleQueuesOldProfiles(t *testing.T) {
+	p := &profiler{}
+	ps := newTestService(t, 2, p, "never", "old", "fresh")
+	ctx := context.Background()
+
+	for card, at := range map[string]time.Time{"old": time.Now().Add(-48 * time.Hour), "fresh": time.Now()} {
+		account, err := p.accounts.Get(ctx, card)
+		require.NoError(t, err)
+		account.Profile = domain.Profile{Trained: true, ProfiledAt: at.UTC()}
+		require.NoError(t, p.accounts.Save(ctx, &account))
+	}
+
+	queued, err := ps.ScheduleStale(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, queued)
+
+	_, err = ps.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"never", "old"}, p.profiled)
+
+	queued, err = ps.ScheduleStale(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+}
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	assert.Equal(t, time.Minute, backoff(1))
+	assert.Equal(t, 4*time.Minute, backoff(3))
+	assert.Equal(t, time.Hour, backoff(10))
+}
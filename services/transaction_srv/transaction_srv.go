@@ -1,18 +1,13 @@
 package transaction_srv
 
 import (
+	"context"
 	"errors"
 	"fraud-detect-system/domain"
 	"fraud-detect-system/domain/ports"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-const (
-	low = iota
-	avg
-	high
-)
-
 var ErrNoAccountFound = errors.New("no account found, create one")
 
 type TransactionService struct {
@@ -27,20 +22,13 @@ func New(accountRepository ports.AccountRepository, transactionRepository ports.
 	}
 }
 
-func (ts *TransactionService) SaveCluster(account *domain.Account, corePoints []float64) error {
-	account.SpendingHabits[low] = corePoints[low] // TODO: make use of enum constant low, avg & high
-	account.SpendingHabits[avg] = corePoints[avg]
-	account.SpendingHabits[high] = corePoints[high]
-
-	return ts.accountRepository.Save(account)
-}
-
-func (ts *TransactionService) GetAllValidByCreditCard(card string) ([]domain.Transaction, error) {
-	return ts.transactionRepository.GetAllValidTransactionWhereAccountIs(card)
+// GetAllValidByCard takes the card's fingerprint, as do the other card lookups.
+func (ts *TransactionService) GetAllValidByCard(ctx context.Context, fingerprint string) ([]domain.Transaction, error) {
+	return ts.transactionRepository.GetAllValidTransactionWhereAccountIs(ctx, fingerprint)
 }
 
-func (ts *TransactionService) GetAccountByCreditCard(card string) (domain.Account, error) {
-	account, err := ts.accountRepository.Get(card)
+func (ts *TransactionService) GetAccountByCard(ctx context.Context, fingerprint string) (domain.Account, error) {
+	account, err := ts.accountRepository.Get(ctx, fingerprint)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -53,10 +41,10 @@ func (ts *TransactionService) GetAccountByCreditCard(card string) (domain.Accoun
 	return account, nil
 }
 
-func (ts *TransactionService) CreateAccount(account domain.Account) (domain.Account, error) {
-	return ts.accountRepository.Create(account)
+func (ts *TransactionService) CreateAccount(ctx context.Context, account domain.Account) (domain.Account, error) {
+	return ts.accountRepository.Create(ctx, account)
 }
 
-func (ts *TransactionService) Create(transaction domain.Transaction) (domain.Transaction, error) {
-	return ts.transactionRepository.Add(transaction)
+func (ts *TransactionService) Create(ctx context.Context, transaction domain.Transaction) (domain.Transaction, error) {
+	return ts.transactionRepository.Add(ctx, transaction)
 }
@@ -0,0 +1,408 @@
+package webhook_srv
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"github.com/gofiber/fiber/v2/utils"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "Fraudis-Signature"
+	EventHeader     = "Fraudis-Event"
+	DeliveryHeader  = "Fraudis-Delivery"
+
+	MaxAttempts  = 8
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+	claimLease   = 2 * time.Minute
+	pollInterval = 5 * time.Second
+
+	requestTimeout   = 10 * time.Second
+	maxResponseBytes = 1024
+)
+
+var (
+	ErrInvalidUrl   = errors.New("webhook url must be an absolute http or https url")
+	ErrInsecureUrl  = errors.New("webhook url must use https")
+	ErrInvalidEvent = errors.New("unknown webhook event type")
+	ErrNoEvents     = errors.New("subscribe to at least one event type")
+)
+
+// Event is the envelope every delivery body is made of.
+type Event struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	Merchant  string      `json:"merchant"`
+	Livemode  bool        `json:"livemode"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type WebhookService struct {
+	endpointRepository ports.WebhookEndpointRepository
+	deliveryRepository ports.WebhookDeliveryRepository
+	notifier           ports.Notifier
+
+	allowPrivate bool
+
+	client *http.Client
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// New delivers through the repositories and tells the merchant through notifier about
+// deliveries that failed for good. Plain http and private addresses are only accepted
+// with allowPrivate, which is meant for development.
+func New(endpointRepository ports.WebhookEndpointRepository, deliveryRepository ports.WebhookDeliveryRepository, notifier ports.Notifier, allowPrivate bool, logger *slog.Logger) *WebhookService {
+	return &WebhookService{
+		endpointRepository: endpointRepository,
+		deliveryRepository: deliveryRepository,
+		notifier:           notifier,
+
+		allowPrivate: allowPrivate,
+
+		client: newClient(allowPrivate),
+		logger: logger.With("component", "webhooks"),
+		now:    time.Now,
+	}
+}
+
+// CreateEndpoint registers url for events and returns the signing secret, which is only shown here.
+func (ws *WebhookService) CreateEndpoint(merchant, rawUrl, description string, events []string) (domain.WebhookEndpoint, string, error) {
+	if err := ws.validateEndpoint(rawUrl, events); err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+
+	secret, err := domain.NewWebhookSecret()
+	if err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+
+	endpoint, err := ws.endpointRepository.Add(domain.WebhookEndpoint{
+		Merchant:    merchant,
+		Url:         rawUrl,
+		Description: description,
+		Events:      events,
+		Secret:      secret,
+	})
+	if err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+
+	return endpoint, secret, nil
+}
+
+func (ws *WebhookService) ListEndpoints(merchant string) ([]domain.WebhookEndpoint, error) {
+	return ws.endpointRepository.GetAllWhereMerchantIs(merchant)
+}
+
+func (ws *WebhookService) UpdateEndpoint(merchant, id, rawUrl, description string, events []string, disabled bool) (domain.WebhookEndpoint, error) {
+	endpoint, err := ws.endpointRepository.GetById(merchant, id)
+	if err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	if rawUrl == "" {
+		rawUrl = endpoint.Url
+	}
+	if events == nil {
+		events = endpoint.Events
+	}
+
+	if err = ws.validateEndpoint(rawUrl, events); err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	endpoint.Url = rawUrl
+	endpoint.Description = description
+	endpoint.Events = events
+	endpoint.Disabled = disabled
+
+	if err = ws.endpointRepository.Save(&endpoint); err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+func (ws *WebhookService) DeleteEndpoint(merchant, id string) error {
+	endpoint, err := ws.endpointRepository.GetById(merchant, id)
+	if err != nil {
+		return err
+	}
+
+	return ws.endpointRepository.Delete(&endpoint)
+}
+
+// Publish queues eventType for every enabled endpoint of merchant that subscribes to it.
+// Delivery happens in the background, see Run.
+func (ws *WebhookService) Publish(merchant string, eventType string, livemode bool, data interface{}) error {
+	endpoints, err := ws.endpointRepository.GetAllWhereMerchantIs(merchant)
+	if err != nil {
+		return err
+	}
+
+	event := Event{
+		Id:        "evt_" + utils.UUIDv4(),
+		Type:      eventType,
+		Merchant:  merchant,
+		Livemode:  livemode,
+		CreatedAt: ws.now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.Disabled || !endpoint.Subscribes(eventType) {
+			continue
+		}
+
+		_, err := ws.deliveryRepository.Add(domain.WebhookDelivery{
+			Merchant:      merchant,
+			Endpoint:      endpoint.ID.Hex(),
+			EventId:       event.Id,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: event.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ws *WebhookService) ListDeliveries(merchant, endpoint string) ([]domain.WebhookDelivery, error) {
+	return ws.deliveryRepository.GetAllWhereEndpointIs(merchant, endpoint)
+}
+
+// Redeliver queues a fresh copy of a delivery, keeping the original in the log.
+func (ws *WebhookService) Redeliver(merchant, id string) (domain.WebhookDelivery, error) {
+	original, err := ws.deliveryRepository.GetById(merchant, id)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	return ws.deliveryRepository.Add(domain.WebhookDelivery{
+		Merchant:      original.Merchant,
+		Endpoint:      original.Endpoint,
+		EventId:       original.EventId,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        domain.DeliveryPending,
+		NextAttemptAt: ws.now().UTC(),
+		RedeliveryOf:  original.ID.Hex(),
+	})
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (ws *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ws.ProcessDue(ctx, 0); err != nil {
+			ws.logger.Error("cannot process due deliveries", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue attempts the deliveries that are due now, at most limit of them unless
+// limit is 0, and reports how many it attempted. It stops early once ctx is done.
+func (ws *WebhookService) ProcessDue(ctx context.Context, limit int) (int, error) {
+	attempted := 0
+
+	for limit == 0 || attempted < limit {
+		if ctx.Err() != nil {
+			return attempted, nil
+		}
+
+		delivery, ok, err := ws.deliveryRepository.ClaimDue(ws.now().UTC(), claimLease)
+		if err != nil || !ok {
+			return attempted, err
+		}
+
+		if err = ws.attempt(ctx, &delivery); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+
+	return attempted, nil
+}
+
+func (ws *WebhookService) attempt(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	endpoint, err := ws.endpointRepository.GetById(delivery.Merchant, delivery.Endpoint)
+	if err != nil || endpoint.Disabled {
+		// nowhere to send it any more
+		delivery.Status = domain.DeliveryFailed
+		delivery.LastError = "endpoint deleted or disabled"
+		return ws.deliveryRepository.Save(delivery)
+	}
+
+	now := ws.now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+
+	statusCode, response, err := ws.send(ctx, endpoint, *delivery, now)
+	delivery.LastStatusCode = statusCode
+	delivery.LastResponse = response
+	delivery.LastError = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = domain.DeliverySucceeded
+	case delivery.Attempts >= MaxAttempts:
+		delivery.Status = domain.DeliveryFailed
+		delivery.LastError = err.Error()
+		ws.notifyFailed(ctx, endpoint, *delivery)
+	default:
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+
+	return ws.deliveryRepository.Save(delivery)
+}
+
+func (ws *WebhookService) notifyFailed(ctx context.Context, endpoint domain.WebhookEndpoint, delivery domain.WebhookDelivery) {
+	err := ws.notifier.Notify(ctx, domain.Notification{
+		Merchant:        delivery.Merchant,
+		Type:            domain.NotificationWebhookFailed,
+		Title:           "Webhook delivery failed",
+		Message:         fmt.Sprintf("%s to %s failed %d times, last with: %s", delivery.EventType, endpoint.Url, delivery.Attempts, delivery.LastError),
+		WebhookEndpoint: endpoint.ID.Hex(),
+	})
+	if err != nil {
+		ws.logger.Error("cannot notify merchant of failed delivery", "delivery", delivery.ID.Hex(), "error", err)
+	}
+}
+
+func (ws *WebhookService) send(ctx context.Context, endpoint domain.WebhookEndpoint, delivery domain.WebhookDelivery, now time.Time) (int, string, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Fraudis-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, SignatureHeaderValue(endpoint.Secret, now, body))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
+		// redirects aren't followed, and whatever the body says isn't the merchant's answer
+		return resp.StatusCode, "", fmt.Errorf("endpoint redirected with %d", resp.StatusCode)
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(snippet), fmt.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, string(snippet), nil
+}
+
+// SignatureHeaderValue is "t=<unix seconds>,v1=<hex hmac>". The hmac is sha256 keyed by
+// the endpoint secret over "<unix seconds>.<body>", so receivers can check both that
+// the body is ours and that it isn't an old one being replayed.
+func SignatureHeaderValue(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + Sign(secret, timestamp, body)
+}
+
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the wait after every failed attempt.
+func backoff(attempts int) time.Duration {
+	d := time.Duration(float64(baseBackoff) * math.Pow(2, float64(attempts-1)))
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
+
+func (ws *WebhookService) validateEndpoint(rawUrl string, events []string) error {
+	if err := validateUrl(rawUrl, ws.allowPrivate); err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		return ErrNoEvents
+	}
+
+	for _, e := range events {
+		if !domain.ValidWebhookEvent(e) {
+			return ErrInvalidEvent
+		}
+	}
+
+	return nil
+}
+
+// TransactionData is the transaction as webhook receivers see it.
+type TransactionData struct {
+	Id        string    `json:"id"`
+	Amount    float64   `json:"amount"`
+	Email     string    `json:"email"`
+	Ip        string    `json:"ip_address"`
+	RiskScore float64   `json:"risk_score"`
+	IsFraud   bool      `json:"is_fraud"`
+	Decision  string    `json:"decision"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewTransactionData(transaction domain.Transaction) TransactionData {
+	return TransactionData{
+		Id:        transaction.ID.Hex(),
+		Amount:    transaction.Amt,
+		Email:     transaction.Email,
+		Ip:        transaction.Ip,
+		RiskScore: transaction.RiskScore,
+		IsFraud:   transaction.IsFraud,
+		Decision:  transaction.Decision,
+		CreatedAt: transaction.CreatedAt,
+	}
+}
@@ -0,0 +1,296 @@
+package webhook_srv
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type endpointRepo struct {
+	endpoints map[string]domain.WebhookEndpoint
+}
+
+func (r *endpointRepo) Add(endpoint domain.WebhookEndpoint) (domain.WebhookEndpoint, error) {
+	endpoint.ID = primitive.NewObjectID()
+	r.endpoints[endpoint.ID.Hex()] = endpoint
+	return endpoint, nil
+}
+
+func (r *endpointRepo) GetById(merchant string, id string) (domain.WebhookEndpoint, error) {
+	endpoint, ok := r.endpoints[id]
+	if !ok || endpoint.Merchant != merchant {
+		return domain.WebhookEndpoint{}, errors.New("not found")
+	}
+	return endpoint, nil
+}
+
+func (r *endpointRepo) GetAllWhereMerchantIs(merchant string) ([]domain.WebhookEndpoint, error) {
+	var endpoints []domain.WebhookEndpoint
+	for _, endpoint := range r.endpoints {
+		if endpoint.Merchant == merchant {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (r *endpointRepo) Save(endpoint *domain.WebhookEndpoint) error {
+	r.endpoints[endpoint.ID.Hex()] = *endpoint
+	return nil
+}
+
+func (r *endpointRepo) Delete(endpoint *domain.WebhookEndpoint) error {
+	delete(r.endpoints, endpoint.ID.Hex())
+	return nil
+}
+
+type deliveryRepo struct {
+	deliveries map[string]domain.WebhookDelivery
+}
+
+func (r *deliveryRepo) Add(delivery domain.WebhookDelivery) (domain.WebhookDelivery, error) {
+	delivery.ID = primitive.NewObjectID()
+	r.deliveries[delivery.ID.Hex()] = delivery
+	return delivery, nil
+}
+
+func (r *deliveryRepo) GetById(merchant string, id string) (domain.WebhookDelivery, error) {
+	delivery, ok := r.deliveries[id]
+	if !ok || delivery.Merchant != merchant {
+		return domain.WebhookDelivery{}, errors.New("not found")
+	}
+	return delivery, nil
+}
+
+func (r *deliveryRepo) GetAllWhereEndpointIs(merchant string, endpoint string) ([]domain.WebhookDelivery, error) {
+	var deliveries []domain.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.Merchant == merchant && delivery.Endpoint == endpoint {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *deliveryRepo) Save(delivery *domain.WebhookDelivery) error {
+	r.deliveries[delivery.ID.Hex()] = *delivery
+	return nil
+}
+
+func (r *deliveryRepo) ClaimDue(now time.Time, lease time.Duration) (domain.WebhookDelivery, bool, error) {
+	var due []domain.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.Status == domain.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	if len(due) == 0 {
+		return domain.WebhookDelivery{}, false, nil
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+
+	claimed := due[0]
+	claimed.NextAttemptAt = now.Add(lease)
+	r.deliveries[claimed.ID.Hex()] = claimed
+	return claimed, true, nil
+}
+
+type notifier struct {
+	notifications []domain.Notification
+}
+
+func (n *notifier) Notify(ctx context.Context, notification domain.Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func newTestService(now *time.Time) (*WebhookService, *deliveryRepo) {
+	deliveries := &deliveryRepo{deliveries: map[string]domain.WebhookDelivery{}}
+	ws := New(&endpointRepo{endpoints: map[string]domain.WebhookEndpoint{}}, deliveries, &notifier{}, true, slog.Default())
+	ws.now = func() time.Time { return *now }
+	return ws, deliveries
+}
+
+func TestWebhookService_DeliversSignedEvents(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	ws, deliveries := newTestService(&now)
+
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	endpoint, secret, err := ws.CreateEndpoint("merchant-1", receiver.URL, "", []string{domain.EventTransactionDeclined})
+	assert.NoError(t, err)
+
+	assert.NoError(t, ws.Publish("merchant-1", domain.EventTransactionDeclined, true, map[string]string{"id": "tx-1"}))
+	assert.NoError(t, ws.Publish("merchant-1", domain.EventTransactionApproved, true, map[string]string{"id": "tx-2"}))
+
+	attempted, err := ws.ProcessDue(context.TODO(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempted, "only subscribed events are queued")
+
+	assert.Equal(t, domain.EventTransactionDeclined, received.Header.Get(EventHeader))
+	assert.Equal(t, SignatureHeaderValue(secret, now, body), received.Header.Get(SignatureHeader))
+	assert.Contains(t, string(body), `"tx-1"`)
+
+	log, _ := ws.ListDeliveries("merchant-1", endpoint.ID.Hex())
+	assert.Len(t, log, 1)
+	assert.Equal(t, domain.DeliverySucceeded, log[0].Status)
+	assert.Len(t, deliveries.deliveries, 1)
+}
+
+func TestWebhookService_RetriesWithBackoffThenFails(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	ws, deliveries := newTestService(&now)
+
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	_, _, err := ws.CreateEndpoint("merchant-1", receiver.URL, "", []string{"*"})
+	assert.NoError(t, err)
+	assert.NoError(t, ws.Publish("merchant-1", domain.EventFraudConfirmed, true, nil))
+
+	_, _ = ws.ProcessDue(context.TODO(), 0)
+	assert.Equal(t, 1, calls)
+
+	// nothing is due until the backoff has passed
+	attempted, _ := ws.ProcessDue(context.TODO(), 0)
+	assert.Equal(t, 0, attempted)
+
+	for i := 1; i < MaxAttempts; i++ {
+		now = now.Add(backoff(i))
+		_, _ = ws.ProcessDue(context.TODO(), 0)
+	}
+	assert.Equal(t, MaxAttempts, calls)
+
+	notifications := ws.notifier.(*notifier).notifications
+	if assert.Len(t, notifications, 1) {
+		assert.Equal(t, domain.NotificationWebhookFailed, notifications[0].Type)
+		assert.Equal(t, "merchant-1", notifications[0].Merchant)
+	}
+
+	var delivery domain.WebhookDelivery
+	for _, d := range deliveries.deliveries {
+		delivery = d
+	}
+	assert.Equal(t, domain.DeliveryFailed, delivery.Status)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.LastStatusCode)
+	assert.True(t, strings.HasPrefix(delivery.LastResponse, "down for maintenance"))
+
+	redelivery, err := ws.Redeliver("merchant-1", delivery.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, domain.DeliveryPending, redelivery.Status)
+	assert.Equal(t, delivery.EventId, redelivery.EventId)
+}
+
+func TestWebhookService_RejectsBadEndpoints(t *testing.T) {
+	now := time.Now()
+	ws, _ := newTestService(&now)
+
+	_, _, err := ws.CreateEndpoint("merchant-1", "ftp://example.com", "", []string{domain.EventFraudConfirmed})
+	assert.Equal(t, ErrInvalidUrl, err)
+
+	_, _, err = ws.CreateEndpoint("merchant-1", "https://example.com/hooks", "", []string{"transaction.refunded"})
+	assert.Equal(t, ErrInvalidEvent, err)
+}
+
+func TestWebhookService_OnlyReachesPublicHttpsOutsideDevelopment(t *testing.T) {
+	now := time.Now()
+	ws, _ := newTestService(&now)
+	ws.allowPrivate = false
+
+	for rawUrl, want := range map[string]error{
+		"http://example.com/hooks":            ErrInsecureUrl,
+		"https://localhost/hooks":             ErrPrivateDestination,
+		"https://127.0.0.1:8080/hooks":        ErrPrivateDestination,
+		"https://10.0.0.7/hooks":              ErrPrivateDestination,
+		"https://169.254.169.254/latest/meta": ErrPrivateDestination,
+		"https://[::1]/hooks":                 ErrPrivateDestination,
+		"https://[::ffff:192.168.1.1]/hooks":  ErrPrivateDestination,
+	} {
+		_, _, err := ws.CreateEndpoint("merchant-1", rawUrl, "", []string{"*"})
+		assert.Equal(t, want, err, rawUrl)
+	}
+
+	_, _, err := ws.CreateEndpoint("merchant-1", "https://example.com/hooks", "", []string{"*"})
+	assert.NoError(t, err)
+}
+
+func TestWebhookService_RefusesPrivateAddressesWhenSending(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	ws, deliveries := newTestService(&now)
+	ws.allowPrivate = false
+	ws.client = newClient(false)
+
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		_, _ = w.Write([]byte("internal secrets"))
+	}))
+	defer receiver.Close()
+
+	// as if the host had resolved to a public address when the endpoint was saved
+	_, err := ws.endpointRepository.Add(domain.WebhookEndpoint{Merchant: "merchant-1", Url: receiver.URL, Events: []string{"*"}})
+	assert.NoError(t, err)
+	assert.NoError(t, ws.Publish("merchant-1", domain.EventFraudConfirmed, true, nil))
+
+	_, _ = ws.ProcessDue(context.TODO(), 0)
+	assert.False(t, called)
+
+	for _, d := range deliveries.deliveries {
+		assert.Equal(t, domain.DeliveryPending, d.Status)
+		assert.Empty(t, d.LastResponse)
+		assert.Contains(t, d.LastError, ErrPrivateDestination.Error())
+	}
+}
+
+func TestWebhookService_DoesNotFollowRedirects(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	ws, deliveries := newTestService(&now)
+
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", target.URL)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		_, _ = w.Write([]byte("see the other place"))
+	}))
+	defer receiver.Close()
+
+	_, _, err := ws.CreateEndpoint("merchant-1", receiver.URL, "", []string{"*"})
+	assert.NoError(t, err)
+	assert.NoError(t, ws.Publish("merchant-1", domain.EventFraudConfirmed, true, nil))
+
+	_, _ = ws.ProcessDue(context.TODO(), 0)
+	assert.False(t, followed)
+
+	for _, d := range deliveries.deliveries {
+		assert.Equal(t, domain.DeliveryPending, d.Status)
+		assert.Equal(t, http.StatusTemporaryRedirect, d.LastStatusCode)
+		assert.Empty(t, d.LastResponse)
+	}
+}
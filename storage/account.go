@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
 	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 var (
@@ -17,12 +20,14 @@ var (
 type AccountStorage struct {
 	collName   string
 	collection *mgm.Collection
-	context    context.Context
 }
 
-func (a *AccountStorage) Get(creditCard string) (domain.Account, error) {
+func (a *AccountStorage) Get(ctx context.Context, fingerprint string) (_ domain.Account, err error) {
+	ctx, span := startSpan(ctx, a.collName, "Get")
+	defer tracing.End(span, &err)
+
 	var account domain.Account
-	err := a.collection.FirstWithCtx(a.context, bson.M{"credit_card": creditCard}, &account)
+	err = a.collection.FirstWithCtx(ctx, bson.M{"card.fingerprint": fingerprint}, &account)
 	if err != nil {
 		return domain.Account{}, err
 	}
@@ -30,8 +35,11 @@ func (a *AccountStorage) Get(creditCard string) (domain.Account, error) {
 	return account, nil
 }
 
-func (a *AccountStorage) Create(account domain.Account) (domain.Account, error) {
-	err := a.collection.CreateWithCtx(a.context, &account)
+func (a *AccountStorage) Create(ctx context.Context, account domain.Account) (_ domain.Account, err error) {
+	ctx, span := startSpan(ctx, a.collName, "Create")
+	defer tracing.End(span, &err)
+
+	err = a.collection.CreateWithCtx(ctx, &account)
 	if err != nil {
 		return domain.Account{}, err
 	}
@@ -39,16 +47,43 @@ func (a *AccountStorage) Create(account domain.Account) (domain.Account, error)
 	return account, nil
 }
 
-func (a *AccountStorage) Save(account *domain.Account) error {
-	return a.collection.UpdateWithCtx(a.context, account)
+func (a *AccountStorage) Save(ctx context.Context, account *domain.Account) (err error) {
+	ctx, span := startSpan(ctx, a.collName, "Save")
+	defer tracing.End(span, &err)
+
+	return a.collection.UpdateWithCtx(ctx, account)
+}
+
+func (a *AccountStorage) GetProfiledBefore(ctx context.Context, before time.Time, limit int) (_ []domain.Account, err error) {
+	ctx, span := startSpan(ctx, a.collName, "GetProfiledBefore")
+	defer tracing.End(span, &err)
+
+	filter := bson.M{operator.Or: bson.A{
+		bson.M{"profile.profiled_at": bson.M{operator.Lt: before}},
+		bson.M{"profile.profiled_at": bson.M{operator.Exists: false}},
+	}}
+	// missing dates sort first
+	opts := options.Find().SetSort(bson.D{{Key: "profile.profiled_at", Value: 1}}).SetLimit(int64(limit))
+
+	var accounts []domain.Account
+	err = a.collection.SimpleFindWithCtx(ctx, &accounts, filter, opts)
+	if err != nil {
+		return []domain.Account{}, err
+	}
+
+	return accounts, nil
 }
 
 func NewAccountStorage(context context.Context, collName string) *AccountStorage {
 	collection := mgm.CollectionByName(collName)
 
-	_, err := collection.Indexes().CreateOne(context, mongo.IndexModel{
-		Keys:    bson.D{{Key: "credit_card", Value: 1}},
-		Options: options.Index().SetUnique(true),
+	_, err := collection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "card.fingerprint", Value: 1}},
+			// accounts from before the card vault have no card until MigrateCards runs
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"card.fingerprint": bson.M{operator.Exists: true}}),
+		},
+		{Keys: bson.D{{Key: "profile.profiled_at", Value: 1}}},
 	})
 	if err != nil {
 		panic(err)
@@ -57,6 +92,5 @@ func NewAccountStorage(context context.Context, collName string) *AccountStorage
 	return &AccountStorage{
 		collName:   collName,
 		collection: collection,
-		context:    context,
 	}
 }
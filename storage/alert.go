@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AlertStorage struct {
+	settings *mgm.Collection
+	pending  *mgm.Collection
+	context  context.Context
+}
+
+func (a *AlertStorage) GetSettings(merchant string) (domain.AlertSettings, error) {
+	var settings domain.AlertSettings
+	err := a.settings.FirstWithCtx(a.context, bson.M{"merchant": merchant}, &settings)
+	if err != nil {
+		return domain.AlertSettings{}, err
+	}
+
+	return settings, nil
+}
+
+func (a *AlertStorage) SaveSettings(settings *domain.AlertSettings) error {
+	if settings.ID.IsZero() {
+		return a.settings.CreateWithCtx(a.context, settings)
+	}
+
+	return a.settings.UpdateWithCtx(a.context, settings)
+}
+
+func (a *AlertStorage) AddPending(alert domain.PendingAlert) (domain.PendingAlert, error) {
+	err := a.pending.CreateWithCtx(a.context, &alert)
+	if err != nil {
+		return domain.PendingAlert{}, err
+	}
+
+	return alert, nil
+}
+
+func (a *AlertStorage) GetAllPending() ([]domain.PendingAlert, error) {
+	var alerts []domain.PendingAlert
+
+	opts := options.Find().SetSort(bson.D{{Key: "merchant", Value: 1}, {Key: "occurred_at", Value: 1}})
+
+	err := a.pending.SimpleFindWithCtx(a.context, &alerts, bson.M{}, opts)
+	if err != nil {
+		return []domain.PendingAlert{}, err
+	}
+
+	return alerts, nil
+}
+
+func (a *AlertStorage) DeletePending(alerts []domain.PendingAlert) error {
+	ids := make([]primitive.ObjectID, 0, len(alerts))
+	for _, alert := range alerts {
+		ids = append(ids, alert.ID)
+	}
+
+	_, err := a.pending.DeleteMany(a.context, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func NewAlertStorage(ctx context.Context, settingsCollName string, pendingCollName string) *AlertStorage {
+	settings := mgm.CollectionByName(settingsCollName)
+
+	_, err := settings.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "merchant", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &AlertStorage{
+		settings: settings,
+		pending:  mgm.CollectionByName(pendingCollName),
+		context:  ctx,
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// AnalyticsStorage aggregates a transaction collection in Mongo, so only one document
+// per period comes back however many transactions there are. It needs MongoDB 5.0
+// or later for $dateTrunc.
+type AnalyticsStorage struct {
+	collName   string
+	collection *mgm.Collection
+}
+
+func NewAnalyticsStorage(transactionsCollName string) *AnalyticsStorage {
+	return &AnalyticsStorage{
+		collName:   transactionsCollName,
+		collection: mgm.CollectionByName(transactionsCollName),
+	}
+}
+
+func (a *AnalyticsStorage) Aggregate(ctx context.Context, query domain.AnalyticsQuery) (_ []domain.AnalyticsBucket, err error) {
+	ctx, span := startSpan(ctx, a.collName, "Aggregate")
+	defer tracing.End(span, &err)
+
+	if err = query.Validate(); err != nil {
+		return nil, err
+	}
+
+	truncate := bson.M{
+		"date":     "$created_at",
+		"unit":     query.Granularity,
+		"timezone": query.Zone().String(),
+	}
+	if query.Granularity == domain.GranularityWeek {
+		truncate["startOfWeek"] = "monday"
+	}
+
+	ifFraud := func(then interface{}) bson.M {
+		return bson.M{"$cond": bson.A{"$is_fraud", then, 0}}
+	}
+
+	// the match is covered by the merchant, created_at index
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"merchant":   query.Merchant,
+			"created_at": bson.M{"$gte": query.From, "$lt": query.To},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":            bson.M{"$dateTrunc": truncate},
+			"count":          bson.M{"$sum": 1},
+			"blocked":        bson.M{"$sum": ifFraud(1)},
+			"volume":         bson.M{"$sum": "$amt"},
+			"volume_blocked": bson.M{"$sum": ifFraud("$amt")},
+			"risk_total":     bson.M{"$sum": "$risk_score"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	var results []struct {
+		Start         time.Time `bson:"_id"`
+		Count         int       `bson:"count"`
+		Blocked       int       `bson:"blocked"`
+		Volume        float64   `bson:"volume"`
+		VolumeBlocked float64   `bson:"volume_blocked"`
+		RiskTotal     float64   `bson:"risk_total"`
+	}
+
+	if err = a.collection.SimpleAggregateWithCtx(ctx, &results, pipeline...); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]domain.AnalyticsBucket, 0, len(results))
+	for _, r := range results {
+		buckets = append(buckets, domain.AnalyticsBucket{
+			Start:          query.Truncate(r.Start),
+			Count:          r.Count,
+			Blocked:        r.Blocked,
+			Volume:         r.Volume,
+			VolumeBlocked:  r.VolumeBlocked,
+			RiskScoreTotal: r.RiskTotal,
+		})
+	}
+
+	return buckets, nil
+}
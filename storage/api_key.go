@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type ApiKeyStorage struct {
+	collName   string
+	collection *mgm.Collection
+	context    context.Context
+}
+
+func (a *ApiKeyStorage) Add(key domain.ApiKey) (domain.ApiKey, error) {
+	err := a.collection.CreateWithCtx(a.context, &key)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+func (a *ApiKeyStorage) GetById(merchant string, id string) (domain.ApiKey, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	var key domain.ApiKey
+	err = a.collection.FirstWithCtx(a.context, bson.M{"_id": objectId, "merchant": merchant}, &key)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+func (a *ApiKeyStorage) GetByHash(keyHash string) (domain.ApiKey, error) {
+	var key domain.ApiKey
+	err := a.collection.FirstWithCtx(a.context, bson.M{"key_hash": keyHash}, &key)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+func (a *ApiKeyStorage) GetAllWhereMerchantIs(merchant string) ([]domain.ApiKey, error) {
+	var keys []domain.ApiKey
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	err := a.collection.SimpleFindWithCtx(a.context, &keys, bson.M{"merchant": merchant}, opts)
+	if err != nil {
+		return []domain.ApiKey{}, err
+	}
+
+	return keys, nil
+}
+
+func (a *ApiKeyStorage) Save(key *domain.ApiKey) error {
+	return a.collection.UpdateWithCtx(a.context, key)
+}
+
+func (a *ApiKeyStorage) TouchLastUsed(id string, at time.Time) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.collection.UpdateByID(a.context, objectId, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+// migrateMerchantSecrets turns the single plain secret older versions kept on each
+// merchant into a live api key the merchant can keep using.
+func (a *ApiKeyStorage) migrateMerchantSecrets(merchants *mgm.Collection) error {
+	cur, err := merchants.Find(a.context, bson.M{"secret": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(a.context)
+
+	for cur.Next(a.context) {
+		var doc struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Secret string             `bson:"secret"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+
+		if doc.Secret != "" {
+			_, err := a.Add(domain.ApiKey{
+				Merchant: doc.ID.Hex(),
+				Name:     "Original secret",
+				Mode:     domain.ApiKeyModeLive,
+				KeyHash:  domain.HashSecret(doc.Secret),
+			})
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+
+		unset := bson.M{"$unset": bson.M{"secret": "", "confirm_password": ""}}
+		if _, err := merchants.UpdateByID(a.context, doc.ID, unset); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+func NewApiKeyStorage(ctx context.Context, collName string, merchantCollName string) *ApiKeyStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	storage := &ApiKeyStorage{
+		collName:   collName,
+		collection: collection,
+		context:    ctx,
+	}
+
+	if err = storage.migrateMerchantSecrets(mgm.CollectionByName(merchantCollName)); err != nil {
+		panic(err)
+	}
+
+	return storage
+}
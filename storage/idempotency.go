@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type IdempotencyStorage struct {
+	collName   string
+	collection *mgm.Collection
+}
+
+func (i *IdempotencyStorage) Begin(ctx context.Context, request domain.IdempotentRequest) (_ domain.IdempotentRequest, _ bool, err error) {
+	ctx, span := startSpan(ctx, i.collName, "Begin")
+	defer tracing.End(span, &err)
+
+	scope := bson.M{"merchant": request.Merchant, "livemode": request.Livemode, "key": request.Key}
+
+	// the ttl monitor only runs every minute, an expired request may still be there
+	_, err = i.collection.DeleteOne(ctx, bson.M{"merchant": request.Merchant, "livemode": request.Livemode, "key": request.Key,
+		"expires_at": bson.M{operator.Lte: time.Now()}})
+	if err != nil {
+		return domain.IdempotentRequest{}, false, err
+	}
+
+	err = i.collection.CreateWithCtx(ctx, &request)
+	if err == nil {
+		return request, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return domain.IdempotentRequest{}, false, err
+	}
+
+	var existing domain.IdempotentRequest
+	if err = i.collection.FirstWithCtx(ctx, scope, &existing); err != nil {
+		return domain.IdempotentRequest{}, false, err
+	}
+
+	return existing, false, nil
+}
+
+func (i *IdempotencyStorage) Complete(ctx context.Context, request *domain.IdempotentRequest) (err error) {
+	ctx, span := startSpan(ctx, i.collName, "Complete")
+	defer tracing.End(span, &err)
+
+	request.Done = true
+	return i.collection.UpdateWithCtx(ctx, request)
+}
+
+func (i *IdempotencyStorage) Release(ctx context.Context, request domain.IdempotentRequest) (err error) {
+	ctx, span := startSpan(ctx, i.collName, "Release")
+	defer tracing.End(span, &err)
+
+	_, err = i.collection.DeleteOne(ctx, bson.M{"_id": request.ID})
+	return err
+}
+
+func NewIdempotencyStorage(ctx context.Context, collName string) *IdempotencyStorage {
+	collection := mgm.CollectionByName(collName)
+
+	// one request per key, gone once expired
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "merchant", Value: 1}, {Key: "livemode", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &IdempotencyStorage{
+		collName:   collName,
+		collection: collection,
+	}
+}
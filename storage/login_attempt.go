@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginAttemptStorage struct {
+	collName   string
+	collection *mgm.Collection
+	context    context.Context
+}
+
+func (l *LoginAttemptStorage) Add(attempt domain.LoginAttempt) (domain.LoginAttempt, error) {
+	err := l.collection.CreateWithCtx(l.context, &attempt)
+	if err != nil {
+		return domain.LoginAttempt{}, err
+	}
+
+	return attempt, nil
+}
+
+func (l *LoginAttemptStorage) GetAllWhereMerchantIs(merchant string) ([]domain.LoginAttempt, error) {
+	var attempts []domain.LoginAttempt
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+
+	err := l.collection.SimpleFindWithCtx(l.context, &attempts, bson.M{"merchant": merchant}, opts)
+	if err != nil {
+		return []domain.LoginAttempt{}, err
+	}
+
+	return attempts, nil
+}
+
+func NewLoginAttemptStorage(ctx context.Context, collName string) *LoginAttemptStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &LoginAttemptStorage{
+		collName:   collName,
+		collection: collection,
+		context:    ctx,
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type LoginCounterStorage struct {
+	collName   string
+	collection *mgm.Collection
+}
+
+func (l *LoginCounterStorage) Get(ctx context.Context, key string, now time.Time) (_ domain.LoginCounter, _ bool, err error) {
+	ctx, span := startSpan(ctx, l.collName, "Get")
+	defer tracing.End(span, &err)
+
+	// the ttl monitor only runs every minute, an expired counter may still be there
+	var counter domain.LoginCounter
+	err = l.collection.FirstWithCtx(ctx, bson.M{"key": key, "expires_at": bson.M{operator.Gte: now}}, &counter)
+	if err == mongo.ErrNoDocuments {
+		return domain.LoginCounter{}, false, nil
+	}
+	if err != nil {
+		return domain.LoginCounter{}, false, err
+	}
+
+	return counter, true, nil
+}
+
+func (l *LoginCounterStorage) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (_ domain.LoginCounter, err error) {
+	ctx, span := startSpan(ctx, l.collName, "AddFailure")
+	defer tracing.End(span, &err)
+
+	// an update pipeline, so starting over and counting happen in one step however many
+	// instances fail logins for the same key at once
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$expires_at", now}},
+				bson.M{"$add": bson.A{"$failures", 1}},
+				1,
+			}},
+			"last_failure": now,
+			"created_at":   bson.M{"$ifNull": bson.A{"$created_at", now}},
+			"updated_at":   now,
+		}},
+		bson.M{"$set": bson.M{
+			"expires_at": bson.M{"$max": bson.A{now.Add(window), "$locked_until"}},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter domain.LoginCounter
+	err = l.collection.FindOneAndUpdate(ctx, bson.M{"key": key}, update, opts).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		// another instance created the counter first, count on top of theirs
+		err = l.collection.FindOneAndUpdate(ctx, bson.M{"key": key}, update, opts).Decode(&counter)
+	}
+	if err != nil {
+		return domain.LoginCounter{}, err
+	}
+
+	return counter, nil
+}
+
+func (l *LoginCounterStorage) Lock(ctx context.Context, key string, until time.Time) (err error) {
+	ctx, span := startSpan(ctx, l.collName, "Lock")
+	defer tracing.End(span, &err)
+
+	_, err = l.collection.UpdateOne(ctx, bson.M{"key": key}, bson.A{
+		bson.M{"$set": bson.M{
+			"failures":     0,
+			"locked_until": until,
+			"expires_at":   bson.M{"$max": bson.A{"$expires_at", until}},
+			"updated_at":   time.Now(),
+		}},
+	})
+	return err
+}
+
+func (l *LoginCounterStorage) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := startSpan(ctx, l.collName, "Delete")
+	defer tracing.End(span, &err)
+
+	_, err = l.collection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
+
+func NewLoginCounterStorage(ctx context.Context, collName string) *LoginCounterStorage {
+	collection := mgm.CollectionByName(collName)
+
+	// one counter per key, gone once both its failure window and lockout are over
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &LoginCounterStorage{
+		collName:   collName,
+		collection: collection,
+	}
+}
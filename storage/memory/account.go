@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"sort"
+	"time"
+)
+
+type AccountStorage struct {
+	accounts *collection[domain.Account]
+}
+
+func NewAccountStorage() *AccountStorage {
+	return &AccountStorage{
+		accounts: newCollection[domain.Account]("card.fingerprint"),
+	}
+}
+
+func (a *AccountStorage) Get(ctx context.Context, fingerprint string) (domain.Account, error) {
+	return a.accounts.first(func(account domain.Account) bool {
+		return account.Card.Fingerprint == fingerprint
+	})
+}
+
+func (a *AccountStorage) Create(ctx context.Context, account domain.Account) (domain.Account, error) {
+	if err := a.accounts.create(ctx, &account); err != nil {
+		return domain.Account{}, err
+	}
+
+	return account, nil
+}
+
+func (a *AccountStorage) Save(ctx context.Context, account *domain.Account) error {
+	return a.accounts.update(ctx, account)
+}
+
+func (a *AccountStorage) GetProfiledBefore(ctx context.Context, before time.Time, limit int) ([]domain.Account, error) {
+	accounts, err := a.accounts.find(func(account domain.Account) bool {
+		return account.Profile.ProfiledAt.Before(mongoTime(before))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].Profile.ProfiledAt.Before(accounts[j].Profile.ProfiledAt)
+	})
+
+	if len(accounts) > limit {
+		accounts = accounts[:limit]
+	}
+
+	return accounts, nil
+}
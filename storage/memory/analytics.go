@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"sort"
+)
+
+// AnalyticsStorage aggregates the transactions of a TransactionStorage.
+type AnalyticsStorage struct {
+	transactions *TransactionStorage
+}
+
+func NewAnalyticsStorage(transactions *TransactionStorage) *AnalyticsStorage {
+	return &AnalyticsStorage{transactions: transactions}
+}
+
+func (a *AnalyticsStorage) Aggregate(ctx context.Context, query domain.AnalyticsQuery) ([]domain.AnalyticsBucket, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	from, to := mongoTime(query.From), mongoTime(query.To)
+
+	transactions, err := a.transactions.transactions.find(func(tx domain.Transaction) bool {
+		return tx.Merchant == query.Merchant && !tx.CreatedAt.Before(from) && tx.CreatedAt.Before(to)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byStart := make(map[int64]*domain.AnalyticsBucket)
+	for _, tx := range transactions {
+		start := query.Truncate(tx.CreatedAt)
+
+		b, ok := byStart[start.Unix()]
+		if !ok {
+			b = &domain.AnalyticsBucket{Start: start}
+			byStart[start.Unix()] = b
+		}
+		b.Add(tx)
+	}
+
+	buckets := make([]domain.AnalyticsBucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	return buckets, nil
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type ApiKeyStorage struct {
+	keys *collection[domain.ApiKey]
+}
+
+func NewApiKeyStorage() *ApiKeyStorage {
+	return &ApiKeyStorage{
+		keys: newCollection[domain.ApiKey]("key_hash"),
+	}
+}
+
+func (a *ApiKeyStorage) Add(key domain.ApiKey) (domain.ApiKey, error) {
+	if err := a.keys.create(context.Background(), &key); err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+func (a *ApiKeyStorage) GetById(merchant string, id string) (domain.ApiKey, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.ApiKey{}, err
+	}
+
+	return a.keys.first(func(key domain.ApiKey) bool {
+		return key.ID == objectId && key.Merchant == merchant
+	})
+}
+
+func (a *ApiKeyStorage) GetByHash(keyHash string) (domain.ApiKey, error) {
+	return a.keys.first(func(key domain.ApiKey) bool {
+		return key.KeyHash == keyHash
+	})
+}
+
+func (a *ApiKeyStorage) GetAllWhereMerchantIs(merchant string) ([]domain.ApiKey, error) {
+	keys, err := a.keys.find(func(key domain.ApiKey) bool {
+		return key.Merchant == merchant
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (a *ApiKeyStorage) Save(key *domain.ApiKey) error {
+	return a.keys.update(context.Background(), key)
+}
+
+func (a *ApiKeyStorage) TouchLastUsed(id string, at time.Time) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	return a.keys.updateMany(func(key domain.ApiKey) bool {
+		return key.ID == objectId
+	}, func(key *domain.ApiKey) {
+		key.LastUsedAt = mongoTime(at)
+	})
+}
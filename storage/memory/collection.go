@@ -0,0 +1,256 @@
+// Package memory has thread-safe in-memory implementations of the repository ports,
+// for tests and running locally without Mongo. They go through the same mgm hooks
+// and bson encoding as the Mongo storage, so what comes back matches what Mongo
+// would return, down to timestamps being cut to milliseconds.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collection stores documents as bson in insertion order, which is the order Mongo
+// returns them in when no sort is given.
+type collection[T any] struct {
+	mu   sync.RWMutex
+	docs []bson.Raw
+
+	// bson keys that no two documents may share, like the Mongo unique indexes
+	unique []string
+}
+
+func newCollection[T any](unique ...string) *collection[T] {
+	return &collection[T]{unique: unique}
+}
+
+// create does what mgm's CreateWithCtx does: run the creating hooks, give the model
+// an id if it has none, insert it and run the created hooks.
+func (c *collection[T]) create(ctx context.Context, model mgm.Model) error {
+	if err := beforeCreate(ctx, model); err != nil {
+		return err
+	}
+
+	if model.GetID() == primitive.NilObjectID {
+		model.SetID(primitive.NewObjectID())
+	}
+
+	doc, err := bson.Marshal(model)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	err = c.checkUnique(doc, -1)
+	if err == nil {
+		c.docs = append(c.docs, doc)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return afterCreate(ctx, model)
+}
+
+// update does what mgm's UpdateWithCtx does: $set every field of the model on the
+// document with its id. Fields the model leaves out, omitempty ones, keep their old
+// value, and a model with no stored document is silently not saved.
+func (c *collection[T]) update(ctx context.Context, model mgm.Model) error {
+	if err := beforeUpdate(ctx, model); err != nil {
+		return err
+	}
+
+	set, err := toM(model)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	err = c.set(model.GetID(), set)
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return afterUpdate(ctx, model)
+}
+
+func (c *collection[T]) set(id interface{}, set bson.M) error {
+	i := c.indexOf(id)
+	if i < 0 {
+		return nil
+	}
+
+	doc, err := toM(c.docs[i])
+	if err != nil {
+		return err
+	}
+
+	for k, v := range set {
+		doc[k] = v
+	}
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if err = c.checkUnique(raw, i); err != nil {
+		return err
+	}
+
+	c.docs[i] = raw
+	return nil
+}
+
+// updateMany applies change to every document that matches, like an UpdateMany.
+func (c *collection[T]) updateMany(match func(T) bool, change func(*T)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, raw := range c.docs {
+		var doc T
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+
+		if !match(doc) {
+			continue
+		}
+
+		change(&doc)
+
+		updated, err := bson.Marshal(&doc)
+		if err != nil {
+			return err
+		}
+		c.docs[i] = updated
+	}
+
+	return nil
+}
+
+// deleteMany removes every document that matches, like a DeleteMany.
+func (c *collection[T]) deleteMany(match func(T) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := c.docs[:0]
+	for _, raw := range c.docs {
+		var doc T
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+
+		if !match(doc) {
+			kept = append(kept, raw)
+		}
+	}
+	c.docs = kept
+
+	return nil
+}
+
+// find decodes every document that matches, in insertion order.
+func (c *collection[T]) find(match func(T) bool) ([]T, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var found []T
+	for _, raw := range c.docs {
+		var doc T
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+
+		if match(doc) {
+			found = append(found, doc)
+		}
+	}
+
+	return found, nil
+}
+
+// first returns the first match or mongo.ErrNoDocuments, like FindOne.
+func (c *collection[T]) first(match func(T) bool) (T, error) {
+	found, err := c.find(match)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	if len(found) == 0 {
+		var zero T
+		return zero, mongo.ErrNoDocuments
+	}
+
+	return found[0], nil
+}
+
+func (c *collection[T]) indexOf(id interface{}) int {
+	for i, raw := range c.docs {
+		if v, ok := raw.Lookup("_id").ObjectIDOK(); ok && v == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkUnique fails with the error Mongo gives for a unique index violation if doc
+// clashes with any stored document other than the one at skip.
+func (c *collection[T]) checkUnique(doc bson.Raw, skip int) error {
+	for _, key := range append([]string{"_id"}, c.unique...) {
+		path := strings.Split(key, ".")
+
+		value, err := doc.LookupErr(path...)
+		if err != nil {
+			continue
+		}
+
+		for i, other := range c.docs {
+			if i != skip && other.Lookup(path...).Equal(value) {
+				return duplicateKeyError(key, value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func duplicateKeyError(key string, value bson.RawValue) error {
+	return mongo.WriteException{
+		WriteErrors: []mongo.WriteError{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error dup key: { %s: %s }", key, value),
+		}},
+	}
+}
+
+func toM(v interface{}) (bson.M, error) {
+	raw, ok := v.(bson.Raw)
+	if !ok {
+		var err error
+		if raw, err = bson.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var m bson.M
+	err := bson.Unmarshal(raw, &m)
+	return m, err
+}
+
+// mongoTime is t as Mongo compares it, to the millisecond.
+func mongoTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond)
+}
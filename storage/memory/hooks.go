@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The hooks run in the same order mgm runs them, so models such as Merchant hash
+// their password and get their timestamps exactly as they would going to Mongo.
+
+func beforeCreate(ctx context.Context, model mgm.Model) error {
+	if hook, ok := model.(mgm.CreatingHookWithCtx); ok {
+		if err := hook.Creating(ctx); err != nil {
+			return err
+		}
+	} else if hook, ok := model.(mgm.CreatingHook); ok {
+		if err := hook.Creating(); err != nil {
+			return err
+		}
+	}
+
+	return saving(ctx, model)
+}
+
+func afterCreate(ctx context.Context, model mgm.Model) error {
+	if hook, ok := model.(mgm.CreatedHookWithCtx); ok {
+		if err := hook.Created(ctx); err != nil {
+			return err
+		}
+	} else if hook, ok := model.(mgm.CreatedHook); ok {
+		if err := hook.Created(); err != nil {
+			return err
+		}
+	}
+
+	return saved(ctx, model)
+}
+
+func beforeUpdate(ctx context.Context, model mgm.Model) error {
+	if hook, ok := model.(mgm.UpdatingHookWithCtx); ok {
+		if err := hook.Updating(ctx); err != nil {
+			return err
+		}
+	} else if hook, ok := model.(mgm.UpdatingHook); ok {
+		if err := hook.Updating(); err != nil {
+			return err
+		}
+	}
+
+	return saving(ctx, model)
+}
+
+func afterUpdate(ctx context.Context, model mgm.Model) error {
+	// nothing here looks at the update result
+	result := &mongo.UpdateResult{}
+
+	if hook, ok := model.(mgm.UpdatedHookWithCtx); ok {
+		if err := hook.Updated(ctx, result); err != nil {
+			return err
+		}
+	} else if hook, ok := model.(mgm.UpdatedHook); ok {
+		if err := hook.Updated(result); err != nil {
+			return err
+		}
+	}
+
+	return saved(ctx, model)
+}
+
+func saving(ctx context.Context, model mgm.Model) error {
+	if hook, ok := model.(mgm.SavingHookWithCtx); ok {
+		return hook.Saving(ctx)
+	} else if hook, ok := model.(mgm.SavingHook); ok {
+		return hook.Saving()
+	}
+
+	return nil
+}
+
+func saved(ctx context.Context, model mgm.Model) error {
+	if hook, ok := model.(mgm.SavedHookWithCtx); ok {
+		return hook.Saved(ctx)
+	} else if hook, ok := model.(mgm.SavedHook); ok {
+		return hook.Saved()
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"sync"
+	"time"
+)
+
+type IdempotencyStorage struct {
+	// makes Begin's find and create one step, like the unique index does in Mongo
+	mu       sync.Mutex
+	requests *collection[domain.IdempotentRequest]
+}
+
+func NewIdempotencyStorage() *IdempotencyStorage {
+	return &IdempotencyStorage{
+		requests: newCollection[domain.IdempotentRequest](),
+	}
+}
+
+func (i *IdempotencyStorage) Begin(ctx context.Context, request domain.IdempotentRequest) (domain.IdempotentRequest, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sameKey := func(other domain.IdempotentRequest) bool {
+		return other.Merchant == request.Merchant && other.Livemode == request.Livemode && other.Key == request.Key
+	}
+
+	now := time.Now()
+	err := i.requests.deleteMany(func(other domain.IdempotentRequest) bool {
+		return sameKey(other) && !other.ExpiresAt.After(now)
+	})
+	if err != nil {
+		return domain.IdempotentRequest{}, false, err
+	}
+
+	existing, err := i.requests.find(sameKey)
+	if err != nil {
+		return domain.IdempotentRequest{}, false, err
+	}
+	if len(existing) > 0 {
+		return existing[0], false, nil
+	}
+
+	if err = i.requests.create(ctx, &request); err != nil {
+		return domain.IdempotentRequest{}, false, err
+	}
+
+	return request, true, nil
+}
+
+func (i *IdempotencyStorage) Complete(ctx context.Context, request *domain.IdempotentRequest) error {
+	request.Done = true
+	return i.requests.update(ctx, request)
+}
+
+func (i *IdempotencyStorage) Release(ctx context.Context, request domain.IdempotentRequest) error {
+	return i.requests.deleteMany(func(other domain.IdempotentRequest) bool {
+		return other.ID == request.ID
+	})
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"sync"
+	"time"
+)
+
+type LoginCounterStorage struct {
+	// makes AddFailure's read and write one step, like the update pipeline does in Mongo
+	mu       sync.Mutex
+	counters *collection[domain.LoginCounter]
+}
+
+func NewLoginCounterStorage() *LoginCounterStorage {
+	return &LoginCounterStorage{
+		counters: newCollection[domain.LoginCounter]("key"),
+	}
+}
+
+func (l *LoginCounterStorage) Get(ctx context.Context, key string, now time.Time) (domain.LoginCounter, bool, error) {
+	found, err := l.counters.find(func(counter domain.LoginCounter) bool {
+		return counter.Key == key && !counter.ExpiresAt.Before(mongoTime(now))
+	})
+	if err != nil || len(found) == 0 {
+		return domain.LoginCounter{}, false, err
+	}
+
+	return found[0], true, nil
+}
+
+func (l *LoginCounterStorage) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (domain.LoginCounter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found, err := l.counters.find(func(counter domain.LoginCounter) bool {
+		return counter.Key == key
+	})
+	if err != nil {
+		return domain.LoginCounter{}, err
+	}
+
+	if len(found) == 0 {
+		counter := domain.LoginCounter{Key: key, Failures: 1, LastFailure: now, ExpiresAt: now.Add(window)}
+		if err = l.counters.create(ctx, &counter); err != nil {
+			return domain.LoginCounter{}, err
+		}
+		return l.reload(counter)
+	}
+
+	counter := found[0]
+	if counter.ExpiresAt.Before(mongoTime(now)) {
+		counter.Failures = 0
+	}
+	counter.Failures++
+	counter.LastFailure = now
+	counter.ExpiresAt = now.Add(window)
+	if counter.LockedUntil.After(counter.ExpiresAt) {
+		counter.ExpiresAt = counter.LockedUntil
+	}
+
+	if err = l.counters.update(ctx, &counter); err != nil {
+		return domain.LoginCounter{}, err
+	}
+
+	return l.reload(counter)
+}
+
+func (l *LoginCounterStorage) Lock(ctx context.Context, key string, until time.Time) error {
+	return l.counters.updateMany(func(counter domain.LoginCounter) bool {
+		return counter.Key == key
+	}, func(counter *domain.LoginCounter) {
+		counter.Failures = 0
+		counter.LockedUntil = mongoTime(until)
+		if counter.LockedUntil.After(counter.ExpiresAt) {
+			counter.ExpiresAt = counter.LockedUntil
+		}
+	})
+}
+
+func (l *LoginCounterStorage) Delete(ctx context.Context, key string) error {
+	return l.counters.deleteMany(func(counter domain.LoginCounter) bool {
+		return counter.Key == key
+	})
+}
+
+// reload reads counter back, so its times are cut to milliseconds the way Mongo returns them
+func (l *LoginCounterStorage) reload(counter domain.LoginCounter) (domain.LoginCounter, error) {
+	return l.counters.first(func(other domain.LoginCounter) bool {
+		return other.ID == counter.ID
+	})
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/storage/storagetest"
+	"testing"
+)
+
+func TestTransactionStorage(t *testing.T) {
+	storagetest.TestTransactionRepository(t, func(t *testing.T) ports.TransactionRepository {
+		return NewTransactionStorage()
+	})
+}
+
+func TestAccountStorage(t *testing.T) {
+	storagetest.TestAccountRepository(t, func(t *testing.T) ports.AccountRepository {
+		return NewAccountStorage()
+	})
+}
+
+func TestMerchantStorage(t *testing.T) {
+	storagetest.TestMerchantRepository(t, func(t *testing.T) ports.MerchantRepository {
+		return NewMerchantStorage()
+	})
+}
+
+func TestReferenceStorage(t *testing.T) {
+	storagetest.TestReferenceRepository(t, func(t *testing.T) ports.ReferenceRepository {
+		return NewReferenceStorage()
+	})
+}
+
+func TestAnalyticsStorage(t *testing.T) {
+	storagetest.TestAnalyticsRepository(t, func(t *testing.T) (ports.TransactionRepository, ports.AnalyticsRepository) {
+		transactions := NewTransactionStorage()
+		return transactions, NewAnalyticsStorage(transactions)
+	})
+}
+
+func TestNotificationStorage(t *testing.T) {
+	storagetest.TestNotificationRepository(t, func(t *testing.T) ports.NotificationRepository {
+		return NewNotificationStorage()
+	})
+}
+
+func TestIdempotencyStorage(t *testing.T) {
+	storagetest.TestIdempotencyRepository(t, func(t *testing.T) ports.IdempotencyRepository {
+		return NewIdempotencyStorage()
+	})
+}
+
+func TestProfileJobStorage(t *testing.T) {
+	storagetest.TestProfileJobRepository(t, func(t *testing.T) ports.ProfileJobRepository {
+		return NewProfileJobStorage()
+	})
+}
+
+func TestApiKeyStorage(t *testing.T) {
+	storagetest.TestApiKeyRepository(t, func(t *testing.T) ports.ApiKeyRepository {
+		return NewApiKeyStorage()
+	})
+}
+
+func TestLoginCounterStorage(t *testing.T) {
+	storagetest.TestLoginCounterRepository(t, func(t *testing.T) ports.LoginCounterRepository {
+		return NewLoginCounterStorage()
+	})
+}
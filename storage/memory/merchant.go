@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MerchantStorage struct {
+	merchants *collection[domain.Merchant]
+}
+
+func NewMerchantStorage() *MerchantStorage {
+	return &MerchantStorage{
+		merchants: newCollection[domain.Merchant]("email"),
+	}
+}
+
+func (m *MerchantStorage) Add(ctx context.Context, newMerchant *domain.Merchant) (domain.Merchant, error) {
+	if err := m.merchants.create(ctx, newMerchant); err != nil {
+		return domain.Merchant{}, err
+	}
+
+	return *newMerchant, nil
+}
+
+func (m *MerchantStorage) GetById(ctx context.Context, id string) (domain.Merchant, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.Merchant{}, err
+	}
+
+	return m.merchants.first(func(merchant domain.Merchant) bool {
+		return merchant.ID == objectId
+	})
+}
+
+func (m *MerchantStorage) GetByEmail(ctx context.Context, email string) (domain.Merchant, error) {
+	return m.merchants.first(func(merchant domain.Merchant) bool {
+		return merchant.Email == email
+	})
+}
+
+func (m *MerchantStorage) Save(ctx context.Context, merchant *domain.Merchant) error {
+	return m.merchants.update(ctx, merchant)
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fraud-detect-system/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type NotificationStorage struct {
+	notifications *collection[domain.Notification]
+}
+
+func NewNotificationStorage() *NotificationStorage {
+	return &NotificationStorage{
+		notifications: newCollection[domain.Notification](),
+	}
+}
+
+func (n *NotificationStorage) Add(ctx context.Context, notification domain.Notification) (domain.Notification, error) {
+	if err := n.notifications.create(ctx, &notification); err != nil {
+		return domain.Notification{}, err
+	}
+
+	return notification, nil
+}
+
+func (n *NotificationStorage) GetAllWhereMerchantIs(ctx context.Context, merchant string, unreadOnly bool, before string, limit int) ([]domain.Notification, error) {
+	var beforeId primitive.ObjectID
+	if before != "" {
+		var err error
+		if beforeId, err = primitive.ObjectIDFromHex(before); err != nil {
+			return nil, err
+		}
+	}
+
+	notifications, err := n.notifications.find(func(notification domain.Notification) bool {
+		return notification.Merchant == merchant &&
+			(!unreadOnly || !notification.Read) &&
+			(before == "" || bytes.Compare(notification.ID[:], beforeId[:]) < 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return bytes.Compare(notifications[i].ID[:], notifications[j].ID[:]) > 0
+	})
+
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+
+	return notifications, nil
+}
+
+func (n *NotificationStorage) CountUnread(ctx context.Context, merchant string) (int, error) {
+	unread, err := n.notifications.find(func(notification domain.Notification) bool {
+		return notification.Merchant == merchant && !notification.Read
+	})
+
+	return len(unread), err
+}
+
+func (n *NotificationStorage) MarkRead(ctx context.Context, merchant string, ids []string) error {
+	only := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		only[objectId] = true
+	}
+
+	now := time.Now().UTC()
+
+	return n.notifications.updateMany(func(notification domain.Notification) bool {
+		return notification.Merchant == merchant && !notification.Read && (len(ids) == 0 || only[notification.ID])
+	}, func(notification *domain.Notification) {
+		notification.Read = true
+		notification.ReadAt = now
+		notification.UpdatedAt = now
+	})
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fraud-detect-system/domain"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ProfileJobStorage struct {
+	// makes Enqueue and ClaimDue one step each, like the unique index and
+	// FindOneAndUpdate do in Mongo
+	mu   sync.Mutex
+	jobs *collection[domain.ProfileJob]
+}
+
+func NewProfileJobStorage() *ProfileJobStorage {
+	return &ProfileJobStorage{
+		jobs: newCollection[domain.ProfileJob](),
+	}
+}
+
+func (p *ProfileJobStorage) Enqueue(ctx context.Context, job domain.ProfileJob) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, err := p.jobs.find(func(other domain.ProfileJob) bool {
+		return other.Status == domain.ProfileJobPending && other.Livemode == job.Livemode && other.Card == job.Card
+	})
+	if err != nil || len(pending) > 0 {
+		return false, err
+	}
+
+	if err = p.jobs.create(ctx, &job); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (p *ProfileJobStorage) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (domain.ProfileJob, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	due, err := p.jobs.find(func(job domain.ProfileJob) bool {
+		switch job.Status {
+		case domain.ProfileJobPending, domain.ProfileJobRetry, domain.ProfileJobRunning:
+			return !job.NextAttemptAt.After(mongoTime(now))
+		}
+		return false
+	})
+	if err != nil || len(due) == 0 {
+		return domain.ProfileJob{}, false, err
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	job := due[0]
+	job.Status = domain.ProfileJobRunning
+	job.NextAttemptAt = now.Add(lease)
+	if err = p.jobs.update(ctx, &job); err != nil {
+		return domain.ProfileJob{}, false, err
+	}
+
+	return job, true, nil
+}
+
+func (p *ProfileJobStorage) Save(ctx context.Context, job *domain.ProfileJob) error {
+	return p.jobs.update(ctx, job)
+}
+
+func (p *ProfileJobStorage) Find(ctx context.Context, status string, limit int) ([]domain.ProfileJob, error) {
+	jobs, err := p.jobs.find(func(job domain.ProfileJob) bool {
+		return status == "" || job.Status == status
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return bytes.Compare(jobs[i].ID[:], jobs[j].ID[:]) > 0
+	})
+
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	return jobs, nil
+}
+
+func (p *ProfileJobStorage) CountByStatus(ctx context.Context) (map[string]int, error) {
+	jobs, err := p.jobs.find(func(domain.ProfileJob) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, job := range jobs {
+		counts[job.Status]++
+	}
+
+	return counts, nil
+}
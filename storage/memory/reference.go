@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+var ErrUnknownReference = errors.New("unknown reference")
+
+// ReferenceStorage hands out opaque references for transaction ids.
+type ReferenceStorage struct {
+	mu           sync.RWMutex
+	transactions map[string]string // reference -> transaction id
+	references   map[string]string // transaction id -> reference
+}
+
+func NewReferenceStorage() *ReferenceStorage {
+	return &ReferenceStorage{
+		transactions: make(map[string]string),
+		references:   make(map[string]string),
+	}
+}
+
+// Set returns the transaction's reference, making one the first time it's asked.
+func (r *ReferenceStorage) Set(ctx context.Context, transactionId string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if reference, ok := r.references[transactionId]; ok {
+		return reference
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	reference := "ref_" + hex.EncodeToString(b)
+
+	r.transactions[reference] = transactionId
+	r.references[transactionId] = reference
+
+	return reference
+}
+
+func (r *ReferenceStorage) Get(ctx context.Context, reference string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transactionId, ok := r.transactions[reference]
+	if !ok {
+		return "", ErrUnknownReference
+	}
+
+	return transactionId, nil
+}
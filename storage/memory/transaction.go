@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fraud-detect-system/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type TransactionStorage struct {
+	transactions *collection[domain.Transaction]
+}
+
+func NewTransactionStorage() *TransactionStorage {
+	return &TransactionStorage{
+		transactions: newCollection[domain.Transaction](),
+	}
+}
+
+func (t *TransactionStorage) Add(ctx context.Context, newTransaction domain.Transaction) (domain.Transaction, error) {
+	if err := t.transactions.create(ctx, &newTransaction); err != nil {
+		return domain.Transaction{}, err
+	}
+
+	return newTransaction, nil
+}
+
+func (t *TransactionStorage) GetById(ctx context.Context, merchant string, id string) (domain.Transaction, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.Transaction{}, err
+	}
+
+	return t.transactions.first(func(tx domain.Transaction) bool {
+		return tx.ID == objectId && tx.Merchant == merchant
+	})
+}
+
+func (t *TransactionStorage) GetAllWhereAccountIs(ctx context.Context, fingerprint string) ([]domain.Transaction, error) {
+	return t.transactions.find(func(tx domain.Transaction) bool {
+		return tx.Card.Fingerprint == fingerprint
+	})
+}
+
+func (t *TransactionStorage) GetAllValidTransactionWhereAccountIs(ctx context.Context, fingerprint string) ([]domain.Transaction, error) {
+	return t.transactions.find(func(tx domain.Transaction) bool {
+		return tx.Card.Fingerprint == fingerprint && !tx.IsFraud
+	})
+}
+
+func (t *TransactionStorage) Save(ctx context.Context, transaction *domain.Transaction) error {
+	return t.transactions.update(ctx, transaction)
+}
+
+func (t *TransactionStorage) Find(ctx context.Context, query domain.TransactionQuery) (domain.TransactionPage, error) {
+	if err := query.Normalize(); err != nil {
+		return domain.TransactionPage{}, err
+	}
+
+	cursor, err := query.Cursor()
+	if err != nil {
+		return domain.TransactionPage{}, err
+	}
+
+	if query.Linked != nil && query.Linked.Empty() {
+		return domain.NewTransactionPage(query, nil), nil
+	}
+
+	transactions, err := t.transactions.find(func(tx domain.Transaction) bool {
+		return matches(query, tx) && (cursor == nil || compare(query, tx, cursor.Value(), cursor.Id) > 0)
+	})
+	if err != nil {
+		return domain.TransactionPage{}, err
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		b := transactions[j]
+		return compare(query, transactions[i], domain.SortValue(query.Sort, b), b.ID) < 0
+	})
+
+	if len(transactions) > query.Limit+1 {
+		transactions = transactions[:query.Limit+1]
+	}
+
+	return domain.NewTransactionPage(query, transactions), nil
+}
+
+func matches(query domain.TransactionQuery, tx domain.Transaction) bool {
+	inRange := func(v float64, min, max *float64) bool {
+		return (min == nil || v >= *min) && (max == nil || v <= *max)
+	}
+
+	switch {
+	case tx.Merchant != query.Merchant:
+		return false
+	case !query.Id.IsZero() && tx.ID != query.Id:
+		return false
+	case !query.From.IsZero() && tx.CreatedAt.Before(mongoTime(query.From)):
+		return false
+	case !query.To.IsZero() && !tx.CreatedAt.Before(mongoTime(query.To)):
+		return false
+	case !inRange(tx.Amt, query.MinAmount, query.MaxAmount), !inRange(tx.RiskScore, query.MinRisk, query.MaxRisk):
+		return false
+	case query.Decision != "" && tx.Decision != query.Decision:
+		return false
+	case query.IsFraud != nil && tx.IsFraud != *query.IsFraud:
+		return false
+	case query.CardFingerprint != "" && tx.Card.Fingerprint != query.CardFingerprint:
+		return false
+	case query.CardBin != "" && tx.Card.Bin != query.CardBin:
+		return false
+	case query.CardLast4 != "" && tx.Card.Last4 != query.CardLast4:
+		return false
+	case query.Email != "" && tx.Email != query.Email:
+		return false
+	case query.Ip != "" && tx.Ip != query.Ip:
+		return false
+	case query.State != "" && tx.StateOfTransaction != query.State:
+		return false
+	case len(query.Text) > 0 && !matchesText(query.Text, tx):
+		return false
+	case query.Linked != nil && !query.Linked.Matches(tx):
+		return false
+	}
+
+	return true
+}
+
+// matchesText is Mongo's $text over the text index with no language: true when any
+// word of the search is a word of one of the indexed fields, ignoring case.
+func matchesText(search []string, tx domain.Transaction) bool {
+	indexed := make(map[string]bool)
+	for _, field := range []string{tx.Email, tx.Ip, tx.StateOfTransaction, tx.UserAgent} {
+		for _, w := range words(field) {
+			indexed[w] = true
+		}
+	}
+
+	for _, s := range search {
+		for _, w := range words(s) {
+			if indexed[w] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// compare orders tx against the position (value, id) the way the query sorts: negative
+// when tx comes first.
+func compare(query domain.TransactionQuery, tx domain.Transaction, value interface{}, id primitive.ObjectID) int {
+	c := 0
+	switch v := domain.SortValue(query.Sort, tx).(type) {
+	case time.Time:
+		c = v.Compare(mongoTime(value.(time.Time)))
+	case float64:
+		c = cmp.Compare(v, value.(float64))
+	}
+
+	if c == 0 {
+		c = bytes.Compare(tx.ID[:], id[:])
+	}
+
+	if query.Desc {
+		return -c
+	}
+
+	return c
+}
@@ -2,8 +2,8 @@ package storage
 
 import (
 	"context"
-	"fmt"
 	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
 	"github.com/kamva/mgm/v3"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,16 +14,21 @@ import (
 type MerchantStorage struct {
 	collName   string
 	collection *mgm.Collection
-	context    context.Context
 }
 
-func (m MerchantStorage) Save(merchant *domain.Merchant) error {
-	return m.collection.UpdateWithCtx(m.context, merchant)
+func (m MerchantStorage) Save(ctx context.Context, merchant *domain.Merchant) (err error) {
+	ctx, span := startSpan(ctx, m.collName, "Save")
+	defer tracing.End(span, &err)
+
+	return m.collection.UpdateWithCtx(ctx, merchant)
 }
 
-func (m MerchantStorage) GetByEmail(email string) (domain.Merchant, error) {
+func (m MerchantStorage) GetByEmail(ctx context.Context, email string) (_ domain.Merchant, err error) {
+	ctx, span := startSpan(ctx, m.collName, "GetByEmail")
+	defer tracing.End(span, &err)
+
 	var merchant domain.Merchant
-	err := m.collection.FindOne(m.context, bson.M{"email": email}).Decode(&merchant)
+	err = m.collection.FindOne(ctx, bson.M{"email": email}).Decode(&merchant)
 
 	if err != nil {
 		return domain.Merchant{}, err
@@ -32,17 +37,22 @@ func (m MerchantStorage) GetByEmail(email string) (domain.Merchant, error) {
 	return merchant, nil
 }
 
-func (m MerchantStorage) Add(newMerchant *domain.Merchant) (domain.Merchant, error) {
-	err := m.collection.CreateWithCtx(m.context, newMerchant)
+func (m MerchantStorage) Add(ctx context.Context, newMerchant *domain.Merchant) (_ domain.Merchant, err error) {
+	ctx, span := startSpan(ctx, m.collName, "Add")
+	defer tracing.End(span, &err)
+
+	err = m.collection.CreateWithCtx(ctx, newMerchant)
 	if err != nil {
-		fmt.Println("line 19 error storage.go")
 		return domain.Merchant{}, err
 	}
 
 	return *newMerchant, err
 }
 
-func (m MerchantStorage) GetById(id string) (domain.Merchant, error) {
+func (m MerchantStorage) GetById(ctx context.Context, id string) (_ domain.Merchant, err error) {
+	ctx, span := startSpan(ctx, m.collName, "GetById")
+	defer tracing.End(span, &err)
+
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return domain.Merchant{}, err
@@ -50,7 +60,7 @@ func (m MerchantStorage) GetById(id string) (domain.Merchant, error) {
 
 	var result domain.Merchant
 
-	err = m.collection.FindByID(objectId, &result)
+	err = m.collection.FindByIDWithCtx(ctx, objectId, &result)
 	if err != nil {
 		return domain.Merchant{}, err
 	}
@@ -58,12 +68,6 @@ func (m MerchantStorage) GetById(id string) (domain.Merchant, error) {
 	return result, err
 }
 
-func (m MerchantStorage) GetByIdAndSecret(id, secret string) (domain.Merchant, error) {
-	// TODO: IMPLEMENT THIS
-
-	return domain.Merchant{}, nil
-}
-
 func NewMerchantStorage(ctx context.Context, collName string) *MerchantStorage {
 	collection := mgm.CollectionByName(collName)
 
@@ -75,9 +79,14 @@ func NewMerchantStorage(ctx context.Context, collName string) *MerchantStorage {
 		panic(err)
 	}
 
+	// merchants who signed up before email verification existed are trusted as they are
+	_, err = collection.UpdateMany(ctx, bson.M{"email_verified": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"email_verified": true}})
+	if err != nil {
+		panic(err)
+	}
+
 	return &MerchantStorage{
 		collName:   collName,
 		collection: collection,
-		context:    ctx,
 	}
 }
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// CardProtector is what MigrateCards needs of the card vault.
+type CardProtector interface {
+	Protect(number string) (domain.Card, error)
+	Fingerprint(number string) string
+}
+
+// the indexes on the plain card number, the unique one would reject every migrated
+// account after the first
+var plainCardIndexes = []string{"credit_card_1", "credit_card_1_created_at_1"}
+
+// MigrateCards replaces the plain card number documents in collName kept under
+// credit_card from before the card vault with the card cards makes of it. Only with
+// keepNumber is the encrypted number kept, the way accounts keep it and transactions
+// don't. An account a payment created under the same card since the card vault is
+// merged with the one from before instead. It can be run again and returns how many
+// documents it changed.
+func MigrateCards(ctx context.Context, collName string, cards CardProtector, keepNumber bool) (migrated int, err error) {
+	ctx, span := startSpan(ctx, collName, "MigrateCards")
+	defer tracing.End(span, &err)
+
+	collection := mgm.CollectionByName(collName)
+
+	for _, name := range plainCardIndexes {
+		if _, err = collection.Indexes().DropOne(ctx, name); err != nil && !indexNotFound(err) {
+			return 0, err
+		}
+	}
+
+	cur, err := collection.Find(ctx, bson.M{"credit_card": bson.M{operator.Exists: true}},
+		options.Find().SetProjection(bson.M{"credit_card": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Number string             `bson:"credit_card"`
+		}
+		if err = cur.Decode(&doc); err != nil {
+			return migrated, err
+		}
+
+		card, err := cards.Protect(doc.Number)
+		if err != nil {
+			// numbers that were never valid still lose the number
+			card = domain.Card{Fingerprint: cards.Fingerprint(doc.Number)}
+			if len(doc.Number) >= 4 {
+				card.Last4 = doc.Number[len(doc.Number)-4:]
+			}
+		}
+		if !keepNumber {
+			card = card.WithoutNumber()
+		}
+
+		_, err = collection.UpdateByID(ctx, doc.ID, bson.M{
+			operator.Set:   bson.M{"card": card},
+			operator.Unset: bson.M{"credit_card": ""},
+		})
+		if mongo.IsDuplicateKeyError(err) && keepNumber {
+			err = mergeAccount(ctx, collection, doc.ID, card.Fingerprint)
+		}
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, cur.Err()
+}
+
+// mergeAccount folds the account id from before the card vault into the one stored
+// under fingerprint since. The profile trained on more transactions is kept, and is
+// left unprofiled so the profile jobs retrain it on both histories first.
+func mergeAccount(ctx context.Context, collection *mgm.Collection, id primitive.ObjectID, fingerprint string) error {
+	var old, current domain.Account
+	if err := collection.FindByIDWithCtx(ctx, id, &old); err != nil {
+		return err
+	}
+	if err := collection.FirstWithCtx(ctx, bson.M{"card.fingerprint": fingerprint}, &current); err != nil {
+		return err
+	}
+
+	if current.CardHolder == "" {
+		current.CardHolder = old.CardHolder
+	}
+	if old.Profile.Transactions > current.Profile.Transactions {
+		current.HMM = old.HMM
+		current.Profile = old.Profile
+	}
+	current.Profile.ProfiledAt = time.Time{}
+
+	_, err := collection.UpdateByID(ctx, current.ID, bson.M{operator.Set: bson.M{
+		"card_holder":  current.CardHolder,
+		"markov_._hmm": current.HMM,
+		"profile":      current.Profile,
+	}})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func indexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	// IndexNotFound, or NamespaceNotFound when the collection doesn't exist yet
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26)
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type NotificationStorage struct {
+	collName   string
+	collection *mgm.Collection
+}
+
+func (n *NotificationStorage) Add(ctx context.Context, notification domain.Notification) (_ domain.Notification, err error) {
+	ctx, span := startSpan(ctx, n.collName, "Add")
+	defer tracing.End(span, &err)
+
+	err = n.collection.CreateWithCtx(ctx, &notification)
+	if err != nil {
+		return domain.Notification{}, err
+	}
+
+	return notification, nil
+}
+
+func (n *NotificationStorage) GetAllWhereMerchantIs(ctx context.Context, merchant string, unreadOnly bool, before string, limit int) (_ []domain.Notification, err error) {
+	ctx, span := startSpan(ctx, n.collName, "GetAllWhereMerchantIs")
+	defer tracing.End(span, &err)
+
+	filter := bson.M{"merchant": merchant}
+	if unreadOnly {
+		filter["read"] = false
+	}
+	if before != "" {
+		id, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{operator.Lt: id}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+
+	var notifications []domain.Notification
+	err = n.collection.SimpleFindWithCtx(ctx, &notifications, filter, opts)
+	if err != nil {
+		return []domain.Notification{}, err
+	}
+
+	return notifications, nil
+}
+
+func (n *NotificationStorage) CountUnread(ctx context.Context, merchant string) (_ int, err error) {
+	ctx, span := startSpan(ctx, n.collName, "CountUnread")
+	defer tracing.End(span, &err)
+
+	count, err := n.collection.CountDocuments(ctx, bson.M{"merchant": merchant, "read": false})
+	return int(count), err
+}
+
+func (n *NotificationStorage) MarkRead(ctx context.Context, merchant string, ids []string) (err error) {
+	ctx, span := startSpan(ctx, n.collName, "MarkRead")
+	defer tracing.End(span, &err)
+
+	filter := bson.M{"merchant": merchant, "read": false}
+	if len(ids) > 0 {
+		objectIds := make([]primitive.ObjectID, 0, len(ids))
+		for _, id := range ids {
+			objectId, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return err
+			}
+			objectIds = append(objectIds, objectId)
+		}
+		filter["_id"] = bson.M{operator.In: objectIds}
+	}
+
+	now := time.Now().UTC()
+	_, err = n.collection.UpdateMany(ctx, filter, bson.M{operator.Set: bson.M{"read": true, "read_at": now, "updated_at": now}})
+	return err
+}
+
+func NewNotificationStorage(ctx context.Context, collName string) *NotificationStorage {
+	collection := mgm.CollectionByName(collName)
+
+	// the whole inbox, and the unread part of it, newest first
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "read", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &NotificationStorage{
+		collName:   collName,
+		collection: collection,
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// sent mail is kept this long for inspection, dead mail until someone deletes it
+const outboxRetention = 7 * 24 * time.Hour
+
+type OutboxStorage struct {
+	collName   string
+	collection *mgm.Collection
+	context    context.Context
+}
+
+func (o *OutboxStorage) Add(mail domain.OutboxMail) (domain.OutboxMail, error) {
+	err := o.collection.CreateWithCtx(o.context, &mail)
+	if err != nil {
+		return domain.OutboxMail{}, err
+	}
+
+	return mail, nil
+}
+
+func (o *OutboxStorage) Save(mail *domain.OutboxMail) error {
+	return o.collection.UpdateWithCtx(o.context, mail)
+}
+
+func (o *OutboxStorage) ClaimDue(now time.Time, lease time.Duration) (domain.OutboxMail, bool, error) {
+	filter := bson.M{
+		"status":          domain.OutboxPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var mail domain.OutboxMail
+	err := o.collection.FindOneAndUpdate(o.context, filter, update, opts).Decode(&mail)
+	if err == mongo.ErrNoDocuments {
+		return domain.OutboxMail{}, false, nil
+	}
+	if err != nil {
+		return domain.OutboxMail{}, false, err
+	}
+
+	return mail, true, nil
+}
+
+func NewOutboxStorage(ctx context.Context, collName string) *OutboxStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &OutboxStorage{
+		collName:   collName,
+		collection: collection,
+		context:    ctx,
+	}
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// finished jobs are kept this long for inspection
+const profileJobRetention = 7 * 24 * time.Hour
+
+type ProfileJobStorage struct {
+	collName   string
+	collection *mgm.Collection
+}
+
+func (p *ProfileJobStorage) Enqueue(ctx context.Context, job domain.ProfileJob) (_ bool, err error) {
+	ctx, span := startSpan(ctx, p.collName, "Enqueue")
+	defer tracing.End(span, &err)
+
+	err = p.collection.CreateWithCtx(ctx, &job)
+	if mongo.IsDuplicateKeyError(err) {
+		// the account's pending job will do
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (p *ProfileJobStorage) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (_ domain.ProfileJob, _ bool, err error) {
+	ctx, span := startSpan(ctx, p.collName, "ClaimDue")
+	defer tracing.End(span, &err)
+
+	filter := bson.M{
+		"status":          bson.M{operator.In: bson.A{domain.ProfileJobPending, domain.ProfileJobRetry, domain.ProfileJobRunning}},
+		"next_attempt_at": bson.M{operator.Lte: now},
+	}
+	update := bson.M{operator.Set: bson.M{"status": domain.ProfileJobRunning, "next_attempt_at": now.Add(lease), "updated_at": now}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job domain.ProfileJob
+	err = p.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return domain.ProfileJob{}, false, nil
+	}
+	if err != nil {
+		return domain.ProfileJob{}, false, err
+	}
+
+	return job, true, nil
+}
+
+func (p *ProfileJobStorage) Save(ctx context.Context, job *domain.ProfileJob) (err error) {
+	ctx, span := startSpan(ctx, p.collName, "Save")
+	defer tracing.End(span, &err)
+
+	return p.collection.UpdateWithCtx(ctx, job)
+}
+
+func (p *ProfileJobStorage) Find(ctx context.Context, status string, limit int) (_ []domain.ProfileJob, err error) {
+	ctx, span := startSpan(ctx, p.collName, "Find")
+	defer tracing.End(span, &err)
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+
+	var jobs []domain.ProfileJob
+	err = p.collection.SimpleFindWithCtx(ctx, &jobs, filter, opts)
+	if err != nil {
+		return []domain.ProfileJob{}, err
+	}
+
+	return jobs, nil
+}
+
+func (p *ProfileJobStorage) CountByStatus(ctx context.Context) (_ map[string]int, err error) {
+	ctx, span := startSpan(ctx, p.collName, "CountByStatus")
+	defer tracing.End(span, &err)
+
+	cursor, err := p.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: operator.Group, Value: bson.M{"_id": "$status", "count": bson.M{operator.Sum: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, g := range groups {
+		counts[g.Status] = g.Count
+	}
+
+	return counts, nil
+}
+
+func NewProfileJobStorage(ctx context.Context, collName string) *ProfileJobStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// one pending job per account
+		{
+			Keys:    bson.D{{Key: "livemode", Value: 1}, {Key: "card", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": domain.ProfileJobPending}),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(profileJobRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &ProfileJobStorage{
+		collName:   collName,
+		collection: collection,
+	}
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"fraud-detect-system/storage/storagetest"
+	"fraud-detect-system/vault"
+	"github.com/kamva/mgm/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var connect sync.Once
+
+// collection skips the test unless MONGO_TEST_URL is set, otherwise it returns the name
+// of a fresh collection in the fraudis_test database that is dropped afterwards.
+func collection(t *testing.T) string {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	connect.Do(func() {
+		if err := mgm.SetDefaultConfig(nil, "fraudis_test", options.Client().ApplyURI(url)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	name := "test_" + primitive.NewObjectID().Hex()
+	t.Cleanup(func() {
+		mgm.CollectionByName(name).Drop(context.Background())
+	})
+
+	return name
+}
+
+func TestTransactionStorage(t *testing.T) {
+	storagetest.TestTransactionRepository(t, func(t *testing.T) ports.TransactionRepository {
+		return NewTransactionStorage(context.Background(), collection(t))
+	})
+}
+
+func TestAccountStorage(t *testing.T) {
+	storagetest.TestAccountRepository(t, func(t *testing.T) ports.AccountRepository {
+		return NewAccountStorage(context.Background(), collection(t))
+	})
+}
+
+func TestMerchantStorage(t *testing.T) {
+	storagetest.TestMerchantRepository(t, func(t *testing.T) ports.MerchantRepository {
+		return NewMerchantStorage(context.Background(), collection(t))
+	})
+}
+
+func TestMerchantStorageTrustsMerchantsFromBeforeVerification(t *testing.T) {
+	collName := collection(t)
+	ctx := context.Background()
+
+	old, unverified := primitive.NewObjectID(), primitive.NewObjectID()
+	_, err := mgm.CollectionByName(collName).InsertMany(ctx, []interface{}{
+		bson.M{"_id": old, "email": "old@shop.example"},
+		bson.M{"_id": unverified, "email": "new@shop.example", "email_verified": false},
+	})
+	require.NoError(t, err)
+
+	merchants := NewMerchantStorage(ctx, collName)
+
+	merchant, err := merchants.GetById(ctx, old.Hex())
+	require.NoError(t, err)
+	assert.True(t, merchant.EmailVerified)
+
+	merchant, err = merchants.GetById(ctx, unverified.Hex())
+	require.NoError(t, err)
+	assert.False(t, merchant.EmailVerified)
+}
+
+func TestAnalyticsStorage(t *testing.T) {
+	storagetest.TestAnalyticsRepository(t, func(t *testing.T) (ports.TransactionRepository, ports.AnalyticsRepository) {
+		collName := collection(t)
+		return NewTransactionStorage(context.Background(), collName), NewAnalyticsStorage(collName)
+	})
+}
+
+func TestNotificationStorage(t *testing.T) {
+	storagetest.TestNotificationRepository(t, func(t *testing.T) ports.NotificationRepository {
+		return NewNotificationStorage(context.Background(), collection(t))
+	})
+}
+
+func TestIdempotencyStorage(t *testing.T) {
+	storagetest.TestIdempotencyRepository(t, func(t *testing.T) ports.IdempotencyRepository {
+		return NewIdempotencyStorage(context.Background(), collection(t))
+	})
+}
+
+func TestProfileJobStorage(t *testing.T) {
+	storagetest.TestProfileJobRepository(t, func(t *testing.T) ports.ProfileJobRepository {
+		return NewProfileJobStorage(context.Background(), collection(t))
+	})
+}
+
+func TestApiKeyStorage(t *testing.T) {
+	storagetest.TestApiKeyRepository(t, func(t *testing.T) ports.ApiKeyRepository {
+		return NewApiKeyStorage(context.Background(), collection(t), collection(t))
+	})
+}
+
+func TestLoginCounterStorage(t *testing.T) {
+	storagetest.TestLoginCounterRepository(t, func(t *testing.T) ports.LoginCounterRepository {
+		return NewLoginCounterStorage(context.Background(), collection(t))
+	})
+}
+
+func TestMigrateMerchantSecrets(t *testing.T) {
+	keysCollName, merchantsCollName := collection(t), collection(t)
+	ctx := context.Background()
+	merchants := mgm.CollectionByName(merchantsCollName)
+
+	// merchants as stored before api keys, one with a secret and one that never had one
+	withSecret, withoutSecret := primitive.NewObjectID(), primitive.NewObjectID()
+	_, err := merchants.InsertMany(ctx, []interface{}{
+		bson.M{"_id": withSecret, "email": "ada@shop.example", "secret": "0123abcd", "confirm_password": "hunter22"},
+		bson.M{"_id": withoutSecret, "email": "bob@shop.example", "secret": ""},
+	})
+	require.NoError(t, err)
+
+	keys := NewApiKeyStorage(ctx, keysCollName, merchantsCollName)
+
+	key, err := keys.GetByHash(domain.HashSecret("0123abcd"))
+	require.NoError(t, err)
+	assert.Equal(t, withSecret.Hex(), key.Merchant)
+	assert.Equal(t, domain.ApiKeyModeLive, key.Mode)
+
+	none, err := keys.GetAllWhereMerchantIs(withoutSecret.Hex())
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	left, err := merchants.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"secret": bson.M{"$exists": true}},
+		{"confirm_password": bson.M{"$exists": true}},
+	}})
+	require.NoError(t, err)
+	assert.Zero(t, left)
+
+	// running it again, as every start does, changes nothing
+	NewApiKeyStorage(ctx, keysCollName, merchantsCollName)
+	all, err := keys.GetAllWhereMerchantIs(withSecret.Hex())
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestMigrateCards(t *testing.T) {
+	collName := collection(t)
+	ctx := context.Background()
+	coll := mgm.CollectionByName(collName)
+
+	// accounts as stored before the card vault, unique index and all
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "credit_card", Value: 1}}, Options: options.Index().SetUnique(true)})
+	require.NoError(t, err)
+	_, err = coll.InsertMany(ctx, []interface{}{
+		bson.M{"credit_card": "4111111111111111", "card_holder": "Ada"},
+		bson.M{"credit_card": "5500000000000004", "card_holder": "Bob",
+			"profile": bson.M{"trained": true, "transactions": 30, "profiled_at": time.Now()}},
+	})
+	require.NoError(t, err)
+
+	cards, err := vault.New("fingerprint key, exactly 32 long", "encryption key,  exactly 32 long")
+	require.NoError(t, err)
+
+	// Bob's card paid after the deploy and before the migration
+	accounts := NewAccountStorage(ctx, collName)
+	bobsCard, err := cards.Protect("5500000000000004")
+	require.NoError(t, err)
+	_, err = accounts.Create(ctx, domain.Account{Card: bobsCard, Profile: domain.Profile{Transactions: 1}})
+	require.NoError(t, err)
+
+	migrated, err := MigrateCards(ctx, collName, cards, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	account, err := accounts.Get(ctx, cards.Fingerprint("4111111111111111"))
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", account.CardHolder)
+	assert.Equal(t, "1111", account.Card.Last4)
+
+	number, err := cards.Reveal(account.Card)
+	require.NoError(t, err)
+	assert.Equal(t, "4111111111111111", number)
+
+	bob, err := accounts.Get(ctx, bobsCard.Fingerprint)
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", bob.CardHolder)
+	assert.True(t, bob.Profile.Trained)
+	assert.Equal(t, 30, bob.Profile.Transactions)
+	assert.True(t, bob.Profile.ProfiledAt.IsZero(), "retrained on both histories first")
+
+	total, err := coll.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+
+	plain, err := coll.CountDocuments(ctx, bson.M{"credit_card": bson.M{"$exists": true}})
+	require.NoError(t, err)
+	assert.Zero(t, plain)
+
+	migrated, err = MigrateCards(ctx, collName, cards, true)
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
+
+func TestStorageSpansJoinTheCallersTrace(t *testing.T) {
+	collName := collection(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, caller := otel.Tracer("test").Start(context.Background(), "caller")
+	accounts := NewAccountStorage(ctx, collName)
+	_, err := accounts.Get(ctx, "unknown fingerprint")
+	require.Error(t, err)
+	caller.End()
+
+	var get sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == collName+".Get" {
+			get = span
+		}
+	}
+	require.NotNil(t, get)
+	assert.Equal(t, caller.SpanContext().SpanID(), get.Parent().SpanID())
+	assert.Equal(t, trace.SpanKindClient, get.SpanKind())
+	assert.Contains(t, get.Attributes(), attribute.String("db.collection.name", collName))
+	assert.Equal(t, codes.Error, get.Status().Code, "errors are recorded on the span")
+}
@@ -0,0 +1,899 @@
+// Package storagetest is the contract every implementation of the repository ports
+// has to meet. The in-memory storage runs it on every test run, the Mongo storage
+// when MONGO_TEST_URL points at a server, so both are held to the same behaviour.
+package storagetest
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"fraud-detect-system/domain/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// The factories return an empty repository each time they are called.
+type (
+	NewTransactionRepository  func(t *testing.T) ports.TransactionRepository
+	NewAccountRepository      func(t *testing.T) ports.AccountRepository
+	NewMerchantRepository     func(t *testing.T) ports.MerchantRepository
+	NewReferenceRepository    func(t *testing.T) ports.ReferenceRepository
+	NewNotificationRepository func(t *testing.T) ports.NotificationRepository
+	NewIdempotencyRepository  func(t *testing.T) ports.IdempotencyRepository
+	NewProfileJobRepository   func(t *testing.T) ports.ProfileJobRepository
+	NewApiKeyRepository       func(t *testing.T) ports.ApiKeyRepository
+	NewLoginCounterRepository func(t *testing.T) ports.LoginCounterRepository
+
+	// NewAnalyticsRepository returns an analytics repository over the transactions
+	// added to the returned transaction repository.
+	NewAnalyticsRepository func(t *testing.T) (ports.TransactionRepository, ports.AnalyticsRepository)
+)
+
+var ctx = context.Background()
+
+// a Tuesday, far enough back to never be "now"
+var day = time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+
+// addAt adds tx and backdates it. Add always stamps created_at with the current time,
+// the same as a real transaction coming in.
+func addAt(t *testing.T, repo ports.TransactionRepository, tx domain.Transaction, createdAt time.Time) domain.Transaction {
+	t.Helper()
+
+	tx, err := repo.Add(ctx, tx)
+	require.NoError(t, err)
+
+	tx.CreatedAt = createdAt
+	require.NoError(t, repo.Save(ctx, &tx))
+
+	return tx
+}
+
+// card stands in for a card the vault protected. The contract only cares that the
+// same number always gets the same fingerprint.
+func card(number string) domain.Card {
+	return domain.Card{Fingerprint: "fp_" + number, Bin: number[:6], Last4: number[len(number)-4:]}
+}
+
+func ids(transactions []domain.Transaction) []string {
+	out := make([]string, 0, len(transactions))
+	for _, tx := range transactions {
+		out = append(out, tx.ID.Hex())
+	}
+
+	return out
+}
+
+func TestTransactionRepository(t *testing.T, newRepo NewTransactionRepository) {
+	t.Run("AddAndGetById", func(t *testing.T) {
+		repo := newRepo(t)
+
+		added, err := repo.Add(ctx, domain.Transaction{Merchant: "m1", Amt: 120.5, Card: card("4111111111111111"), Ip: "10.0.0.1"})
+		require.NoError(t, err)
+		require.False(t, added.ID.IsZero())
+		assert.WithinDuration(t, time.Now(), added.CreatedAt, time.Minute)
+
+		got, err := repo.GetById(ctx, "m1", added.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, added.ID, got.ID)
+		assert.Equal(t, 120.5, got.Amt)
+		assert.Equal(t, "10.0.0.1", got.Ip)
+		assert.Equal(t, card("4111111111111111"), got.Card)
+		assert.WithinDuration(t, added.CreatedAt, got.CreatedAt, time.Millisecond)
+
+		// another merchant's transaction is as good as missing
+		_, err = repo.GetById(ctx, "m2", added.ID.Hex())
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		_, err = repo.GetById(ctx, "m1", primitive.NewObjectID().Hex())
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		_, err = repo.GetById(ctx, "m1", "not-an-id")
+		assert.Error(t, err)
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		repo := newRepo(t)
+
+		tx, err := repo.Add(ctx, domain.Transaction{Merchant: "m1", Amt: 10})
+		require.NoError(t, err)
+
+		tx.IsFraud = true
+		tx.Decision = domain.DecisionDecline
+		require.NoError(t, repo.Save(ctx, &tx))
+
+		got, err := repo.GetById(ctx, "m1", tx.ID.Hex())
+		require.NoError(t, err)
+		assert.True(t, got.IsFraud)
+		assert.Equal(t, domain.DecisionDecline, got.Decision)
+	})
+
+	t.Run("ByAccount", func(t *testing.T) {
+		repo := newRepo(t)
+
+		valid, _ := repo.Add(ctx, domain.Transaction{Merchant: "m1", Card: card("4111111111111111")})
+		fraud, _ := repo.Add(ctx, domain.Transaction{Merchant: "m2", Card: card("4111111111111111"), IsFraud: true})
+		repo.Add(ctx, domain.Transaction{Merchant: "m1", Card: card("5500000000000004")})
+
+		all, err := repo.GetAllWhereAccountIs(ctx, "fp_4111111111111111")
+		require.NoError(t, err)
+		assert.Equal(t, []string{valid.ID.Hex(), fraud.ID.Hex()}, ids(all))
+
+		validOnly, err := repo.GetAllValidTransactionWhereAccountIs(ctx, "fp_4111111111111111")
+		require.NoError(t, err)
+		assert.Equal(t, []string{valid.ID.Hex()}, ids(validOnly))
+
+		none, err := repo.GetAllWhereAccountIs(ctx, "3700")
+		require.NoError(t, err)
+		assert.Empty(t, none)
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		testFind(t, newRepo)
+	})
+}
+
+func float(f float64) *float64 { return &f }
+
+func boolean(b bool) *bool { return &b }
+
+// findAll follows the cursor to the last page.
+func findAll(t *testing.T, repo ports.TransactionRepository, query domain.TransactionQuery) []string {
+	t.Helper()
+
+	var all []string
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 100, "pagination doesn't end")
+
+		page, err := repo.Find(ctx, query)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Transactions), query.Limit)
+
+		all = append(all, ids(page.Transactions)...)
+		if !page.HasMore {
+			assert.Empty(t, page.NextCursor)
+			return all
+		}
+
+		query.After = page.NextCursor
+	}
+}
+
+func testFind(t *testing.T, newRepo NewTransactionRepository) {
+	t.Run("PagesInOrder", func(t *testing.T) {
+		repo := newRepo(t)
+
+		// amounts repeat so pages have to break ties by id
+		var added []domain.Transaction
+		for i := 0; i < 7; i++ {
+			added = append(added, addAt(t, repo, domain.Transaction{Merchant: "m1", Amt: float64(10 * (i % 3))}, day.Add(time.Duration(i)*time.Hour)))
+		}
+		addAt(t, repo, domain.Transaction{Merchant: "m2"}, day)
+
+		newestFirst := findAll(t, repo, domain.TransactionQuery{Merchant: "m1", Desc: true, Limit: 3})
+		var want []string
+		for i := len(added) - 1; i >= 0; i-- {
+			want = append(want, added[i].ID.Hex())
+		}
+		assert.Equal(t, want, newestFirst)
+
+		byAmount := findAll(t, repo, domain.TransactionQuery{Merchant: "m1", Sort: domain.SortAmount, Limit: 2})
+		assert.Equal(t, []string{
+			added[0].ID.Hex(), added[3].ID.Hex(), added[6].ID.Hex(),
+			added[1].ID.Hex(), added[4].ID.Hex(),
+			added[2].ID.Hex(), added[5].ID.Hex(),
+		}, byAmount)
+
+		page, err := repo.Find(ctx, domain.TransactionQuery{Merchant: "m1"})
+		require.NoError(t, err)
+		assert.Len(t, page.Transactions, 7)
+		assert.False(t, page.HasMore)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		repo := newRepo(t)
+
+		small := addAt(t, repo, domain.Transaction{Merchant: "m1", Amt: 5, RiskScore: .01, Decision: domain.DecisionApprove,
+			Card: card("4111111111111111"), User: domain.User{Email: "ada@example.com"}, Ip: "10.0.0.1", StateOfTransaction: "Lagos", UserAgent: "Mozilla/5.0 (iPhone)"}, day)
+		large := addAt(t, repo, domain.Transaction{Merchant: "m1", Amt: 900, RiskScore: .3, Decision: domain.DecisionDecline, IsFraud: true,
+			Card: card("5500000000000004"), User: domain.User{Email: "bob@example.com"}, Ip: "10.0.0.2", StateOfTransaction: "Cross River", UserAgent: "curl/8.4.0"}, day.Add(2*time.Hour))
+		mid := addAt(t, repo, domain.Transaction{Merchant: "m1", Amt: 120, RiskScore: .15, Decision: domain.DecisionReview,
+			Card: card("4000000000001111"), User: domain.User{Email: "ada@example.com"}, Ip: "10.0.0.1", StateOfTransaction: "Lagos", UserAgent: "Mozilla/5.0 (Windows)"}, day.Add(26*time.Hour))
+		// shares nothing with the others
+		other := addAt(t, repo, domain.Transaction{Merchant: "m1", Amt: 40, Decision: domain.DecisionApprove,
+			Card: card("3400000000000009"), User: domain.User{Email: "eve@example.net"}, Ip: "10.0.0.9"}, day.Add(30*time.Hour))
+
+		for name, c := range map[string]struct {
+			query domain.TransactionQuery
+			want  []domain.Transaction
+		}{
+			"date range":     {domain.TransactionQuery{From: day, To: day.Add(2 * time.Hour)}, []domain.Transaction{small}},
+			"from":           {domain.TransactionQuery{From: day.Add(time.Hour)}, []domain.Transaction{large, mid, other}},
+			"amount range":   {domain.TransactionQuery{MinAmount: float(100), MaxAmount: float(900)}, []domain.Transaction{large, mid}},
+			"max amount":     {domain.TransactionQuery{MaxAmount: float(120)}, []domain.Transaction{small, mid, other}},
+			"risk range":     {domain.TransactionQuery{MinRisk: float(.1), MaxRisk: float(.2)}, []domain.Transaction{mid}},
+			"decision":       {domain.TransactionQuery{Decision: domain.DecisionDecline}, []domain.Transaction{large}},
+			"not fraud":      {domain.TransactionQuery{IsFraud: boolean(false)}, []domain.Transaction{small, mid, other}},
+			"card last 4":    {domain.TransactionQuery{CardLast4: "1111"}, []domain.Transaction{small, mid}},
+			"card":           {domain.TransactionQuery{CardFingerprint: "fp_5500000000000004"}, []domain.Transaction{large}},
+			"card bin":       {domain.TransactionQuery{CardBin: "400000"}, []domain.Transaction{mid}},
+			"email":          {domain.TransactionQuery{Email: "ada@example.com"}, []domain.Transaction{small, mid}},
+			"ip":             {domain.TransactionQuery{Ip: "10.0.0.2"}, []domain.Transaction{large}},
+			"combined":       {domain.TransactionQuery{Email: "ada@example.com", MinAmount: float(10)}, []domain.Transaction{mid}},
+			"id":             {domain.TransactionQuery{Id: large.ID}, []domain.Transaction{large}},
+			"state":          {domain.TransactionQuery{State: "Cross River"}, []domain.Transaction{large}},
+			"state is exact": {domain.TransactionQuery{State: "Lag"}, nil},
+			"text":           {domain.TransactionQuery{Text: []string{"mozilla"}}, []domain.Transaction{small, mid}},
+			"text any word":  {domain.TransactionQuery{Text: []string{"WINDOWS", "curl"}}, []domain.Transaction{large, mid}},
+			"text in email":  {domain.TransactionQuery{Text: []string{"bob"}}, []domain.Transaction{large}},
+			"text is whole":  {domain.TransactionQuery{Text: []string{"moz"}}, nil},
+			"text literal":   {domain.TransactionQuery{Text: []string{`"-lagos"`}}, []domain.Transaction{small, mid}},
+			"linked":         {domain.TransactionQuery{Linked: &domain.Linked{Ips: []string{"10.0.0.2"}, Emails: []string{"ada@example.com"}}}, []domain.Transaction{small, large, mid}},
+			"linked card":    {domain.TransactionQuery{Linked: &domain.Linked{Cards: []string{"fp_3400000000000009"}}}, []domain.Transaction{other}},
+			"linked empty":   {domain.TransactionQuery{Linked: &domain.Linked{}}, nil},
+			"nothing":        {domain.TransactionQuery{Email: "eve@example.com"}, nil},
+			"other merchant": {domain.TransactionQuery{Merchant: "m2"}, nil},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if c.query.Merchant == "" {
+					c.query.Merchant = "m1"
+				}
+
+				page, err := repo.Find(ctx, c.query)
+				require.NoError(t, err)
+				assert.Equal(t, ids(c.want), ids(page.Transactions))
+			})
+		}
+	})
+
+	t.Run("RejectsBadQueries", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.Find(ctx, domain.TransactionQuery{Merchant: "m1", Sort: "email"})
+		assert.ErrorIs(t, err, domain.ErrInvalidSort)
+
+		_, err = repo.Find(ctx, domain.TransactionQuery{Merchant: "m1", After: "garbage"})
+		assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+
+		for i := 0; i < 2; i++ {
+			repo.Add(ctx, domain.Transaction{Merchant: "m1", Amt: float64(i)})
+		}
+
+		page, err := repo.Find(ctx, domain.TransactionQuery{Merchant: "m1", Limit: 1})
+		require.NoError(t, err)
+		require.True(t, page.HasMore)
+
+		// a cursor only continues the listing it came from
+		_, err = repo.Find(ctx, domain.TransactionQuery{Merchant: "m1", Sort: domain.SortAmount, After: page.NextCursor})
+		assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+	})
+}
+
+func TestAccountRepository(t *testing.T, newRepo NewAccountRepository) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+
+		created, err := repo.Create(ctx, domain.Account{Card: card("4111111111111111"), CardHolder: "Ada"})
+		require.NoError(t, err)
+		require.False(t, created.ID.IsZero())
+
+		got, err := repo.Get(ctx, "fp_4111111111111111")
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, got.ID)
+		assert.Equal(t, "Ada", got.CardHolder)
+
+		_, err = repo.Get(ctx, "fp_5500000000000004")
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("CardsAreUnique", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.Create(ctx, domain.Account{Card: card("4111111111111111")})
+		require.NoError(t, err)
+
+		_, err = repo.Create(ctx, domain.Account{Card: card("4111111111111111")})
+		assert.True(t, mongo.IsDuplicateKeyError(err), "expected a duplicate key error, got %v", err)
+	})
+
+	t.Run("SaveKeepsTheModel", func(t *testing.T) {
+		repo := newRepo(t)
+
+		account, err := repo.Create(ctx, domain.Account{Card: card("4111111111111111")})
+		require.NoError(t, err)
+
+		account.N, account.M = 3, 3
+		account.Pi = []float64{.2, .3, .5}
+		account.A = [][]float64{{.1, .2, .7}, {.3, .3, .4}, {.5, .25, .25}}
+		account.SpendingHabits = map[int]float64{0: 10, 1: 50, 2: 200}
+		require.NoError(t, repo.Save(ctx, &account))
+
+		got, err := repo.Get(ctx, "fp_4111111111111111")
+		require.NoError(t, err)
+		assert.Equal(t, account.Pi, got.Pi)
+		assert.Equal(t, account.A, got.A)
+		assert.Equal(t, account.SpendingHabits, got.SpendingHabits)
+	})
+
+	t.Run("GetProfiledBefore", func(t *testing.T) {
+		repo := newRepo(t)
+
+		profiledAt := func(number string, at time.Time) {
+			account, err := repo.Create(ctx, domain.Account{Card: card(number)})
+			require.NoError(t, err)
+
+			if !at.IsZero() {
+				account.Profile = domain.Profile{Trained: true, Transactions: 20, ProfiledAt: at}
+				require.NoError(t, repo.Save(ctx, &account))
+			}
+		}
+		profiledAt("4111111111111111", day.Add(2*time.Hour))
+		profiledAt("5500000000000004", time.Time{})
+		profiledAt("4242424242424242", day)
+		profiledAt("378282246310005", day.Add(48*time.Hour))
+
+		fingerprints := func(accounts []domain.Account) []string {
+			out := make([]string, 0, len(accounts))
+			for _, a := range accounts {
+				out = append(out, a.Card.Fingerprint)
+			}
+			return out
+		}
+
+		stale, err := repo.GetProfiledBefore(ctx, day.Add(24*time.Hour), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fp_5500000000000004", "fp_4242424242424242", "fp_4111111111111111"}, fingerprints(stale))
+
+		stale, err = repo.GetProfiledBefore(ctx, day.Add(24*time.Hour), 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fp_5500000000000004", "fp_4242424242424242"}, fingerprints(stale))
+		assert.Equal(t, 20, stale[1].Profile.Transactions)
+	})
+}
+
+func TestMerchantRepository(t *testing.T, newRepo NewMerchantRepository) {
+	t.Run("AddHashesThePassword", func(t *testing.T) {
+		repo := newRepo(t)
+
+		added, err := repo.Add(ctx, &domain.Merchant{Name: "Shop", Email: "shop@example.com", Password: "hunter22"})
+		require.NoError(t, err)
+		require.False(t, added.ID.IsZero())
+		assert.Empty(t, added.Password)
+
+		got, err := repo.GetById(ctx, added.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, "Shop", got.Name)
+		assert.NotEqual(t, "hunter22", got.Password)
+		assert.NoError(t, got.PasswordMatches("hunter22"))
+
+		byEmail, err := repo.GetByEmail(ctx, "shop@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, added.ID, byEmail.ID)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.GetById(ctx, primitive.NewObjectID().Hex())
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		_, err = repo.GetById(ctx, "not-an-id")
+		assert.Error(t, err)
+
+		_, err = repo.GetByEmail(ctx, "nobody@example.com")
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("EmailsAreUnique", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.Add(ctx, &domain.Merchant{Email: "shop@example.com", Password: "hunter22"})
+		require.NoError(t, err)
+
+		_, err = repo.Add(ctx, &domain.Merchant{Email: "shop@example.com", Password: "hunter22"})
+		assert.True(t, mongo.IsDuplicateKeyError(err), "expected a duplicate key error, got %v", err)
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		repo := newRepo(t)
+
+		added, err := repo.Add(ctx, &domain.Merchant{Email: "shop@example.com", Password: "hunter22"})
+		require.NoError(t, err)
+
+		merchant, err := repo.GetById(ctx, added.ID.Hex())
+		require.NoError(t, err)
+
+		merchant.LastLoggedIn = day
+		merchant.TwoFactorSecret = "JBSWY3DPEHPK3PXP"
+		require.NoError(t, repo.Save(ctx, &merchant))
+
+		got, err := repo.GetById(ctx, added.ID.Hex())
+		require.NoError(t, err)
+		assert.True(t, day.Equal(got.LastLoggedIn))
+		assert.Equal(t, "JBSWY3DPEHPK3PXP", got.TwoFactorSecret)
+		assert.NoError(t, got.PasswordMatches("hunter22"))
+	})
+}
+
+func TestReferenceRepository(t *testing.T, newRepo NewReferenceRepository) {
+	repo := newRepo(t)
+
+	txId := primitive.NewObjectID().Hex()
+
+	reference := repo.Set(ctx, txId)
+	require.NotEmpty(t, reference)
+	assert.NotEqual(t, txId, reference)
+	assert.Equal(t, reference, repo.Set(ctx, txId), "a transaction keeps its reference")
+	assert.NotEqual(t, reference, repo.Set(ctx, primitive.NewObjectID().Hex()))
+
+	got, err := repo.Get(ctx, reference)
+	require.NoError(t, err)
+	assert.Equal(t, txId, got)
+
+	_, err = repo.Get(ctx, "ref_unknown")
+	assert.Error(t, err)
+}
+
+func TestAnalyticsRepository(t *testing.T, newRepos NewAnalyticsRepository) {
+	lagos, err := time.LoadLocation("Africa/Lagos") // UTC+1, no DST
+	require.NoError(t, err)
+
+	transactions, analytics := newRepos(t)
+
+	// day is a Tuesday
+	addAt(t, transactions, domain.Transaction{Merchant: "m1", Amt: 100, RiskScore: .05}, day.Add(9*time.Hour))
+	addAt(t, transactions, domain.Transaction{Merchant: "m1", Amt: 300, RiskScore: .35, IsFraud: true}, day.Add(9*time.Hour+30*time.Minute))
+	addAt(t, transactions, domain.Transaction{Merchant: "m1", Amt: 50, RiskScore: .1}, day.Add(23*time.Hour+30*time.Minute))
+	addAt(t, transactions, domain.Transaction{Merchant: "m1", Amt: 20}, day.AddDate(0, 0, -2))
+	addAt(t, transactions, domain.Transaction{Merchant: "m1", Amt: 999}, day.AddDate(0, 0, -30))
+	addAt(t, transactions, domain.Transaction{Merchant: "m2", Amt: 70}, day)
+
+	aggregate := func(t *testing.T, q domain.AnalyticsQuery) domain.Analytics {
+		t.Helper()
+		q.Merchant = "m1"
+
+		buckets, err := analytics.Aggregate(ctx, q)
+		require.NoError(t, err)
+
+		return domain.NewAnalytics(q, buckets)
+	}
+
+	t.Run("Days", func(t *testing.T) {
+		a := aggregate(t, domain.AnalyticsQuery{From: day.AddDate(0, 0, -2), To: day.AddDate(0, 0, 1), Granularity: domain.GranularityDay})
+
+		require.Len(t, a.Series, 3)
+		assert.True(t, day.AddDate(0, 0, -2).Equal(a.Series[0].Start))
+		assert.Equal(t, []int{1, 0, 3}, []int{a.Series[0].Count, a.Series[1].Count, a.Series[2].Count})
+
+		today := a.Series[2]
+		assert.Equal(t, 1, today.Blocked)
+		assert.Equal(t, 450.0, today.Volume)
+		assert.Equal(t, 300.0, today.VolumeBlocked)
+		assert.InDelta(t, 33.33, today.BlockRate, .01)
+		assert.InDelta(t, .1667, today.AvgRisk, .0001)
+
+		assert.Equal(t, 4, a.Summary.Count)
+		assert.Equal(t, 1, a.Summary.Blocked)
+		assert.Equal(t, 470.0, a.Summary.Volume)
+		assert.InDelta(t, 25, a.Summary.BlockRate, .01)
+	})
+
+	t.Run("Timezone", func(t *testing.T) {
+		// 23:30 UTC is already the next day in Lagos
+		from := time.Date(2024, time.March, 5, 0, 0, 0, 0, lagos)
+		a := aggregate(t, domain.AnalyticsQuery{From: from, To: from.AddDate(0, 0, 2), Granularity: domain.GranularityDay, Location: lagos})
+
+		require.Len(t, a.Series, 2)
+		assert.True(t, from.Equal(a.Series[0].Start))
+		assert.Equal(t, 2, a.Series[0].Count)
+		assert.Equal(t, 1, a.Series[1].Count)
+	})
+
+	t.Run("Hours", func(t *testing.T) {
+		a := aggregate(t, domain.AnalyticsQuery{From: day.Add(8 * time.Hour), To: day.Add(11 * time.Hour), Granularity: domain.GranularityHour})
+
+		require.Len(t, a.Series, 3)
+		assert.Equal(t, []int{0, 2, 0}, []int{a.Series[0].Count, a.Series[1].Count, a.Series[2].Count})
+	})
+
+	t.Run("WeeksStartOnMonday", func(t *testing.T) {
+		monday := day.AddDate(0, 0, -1)
+		a := aggregate(t, domain.AnalyticsQuery{From: day.AddDate(0, 0, -7), To: day.AddDate(0, 0, 1), Granularity: domain.GranularityWeek})
+
+		require.Len(t, a.Series, 2)
+		assert.True(t, monday.AddDate(0, 0, -7).Equal(a.Series[0].Start))
+		assert.True(t, monday.Equal(a.Series[1].Start))
+		assert.Equal(t, 1, a.Series[0].Count) // sunday's
+		assert.Equal(t, 3, a.Series[1].Count)
+	})
+
+	t.Run("RejectsBadQueries", func(t *testing.T) {
+		for q, want := range map[domain.AnalyticsQuery]error{
+			{Merchant: "m1", From: day, To: day.Add(time.Hour), Granularity: "month"}:                   domain.ErrInvalidGranularity,
+			{Merchant: "m1", From: day, To: day, Granularity: domain.GranularityDay}:                    domain.ErrInvalidRange,
+			{Merchant: "m1", From: day.AddDate(-1, 0, 0), To: day, Granularity: domain.GranularityHour}: domain.ErrTooManyBuckets,
+		} {
+			_, err := analytics.Aggregate(ctx, q)
+			assert.ErrorIs(t, err, want)
+		}
+	})
+}
+
+func TestNotificationRepository(t *testing.T, newRepo NewNotificationRepository) {
+	notificationIds := func(notifications []domain.Notification) []string {
+		out := make([]string, 0, len(notifications))
+		for _, n := range notifications {
+			out = append(out, n.ID.Hex())
+		}
+		return out
+	}
+
+	repo := newRepo(t)
+
+	var added []domain.Notification
+	for i := 0; i < 4; i++ {
+		n, err := repo.Add(ctx, domain.Notification{Merchant: "m1", Type: domain.NotificationHighRisk, Title: "High risk transaction"})
+		require.NoError(t, err)
+		require.False(t, n.ID.IsZero())
+		added = append(added, n)
+	}
+	_, err := repo.Add(ctx, domain.Notification{Merchant: "m2", Type: domain.NotificationReview})
+	require.NoError(t, err)
+
+	t.Run("NewestFirst", func(t *testing.T) {
+		got, err := repo.GetAllWhereMerchantIs(ctx, "m1", false, "", 3)
+		require.NoError(t, err)
+		assert.Equal(t, []string{added[3].ID.Hex(), added[2].ID.Hex(), added[1].ID.Hex()}, notificationIds(got))
+		assert.False(t, got[0].Read)
+
+		older, err := repo.GetAllWhereMerchantIs(ctx, "m1", false, got[2].ID.Hex(), 3)
+		require.NoError(t, err)
+		assert.Equal(t, []string{added[0].ID.Hex()}, notificationIds(older))
+
+		_, err = repo.GetAllWhereMerchantIs(ctx, "m1", false, "not-an-id", 3)
+		assert.Error(t, err)
+	})
+
+	t.Run("MarkRead", func(t *testing.T) {
+		count, err := repo.CountUnread(ctx, "m1")
+		require.NoError(t, err)
+		assert.Equal(t, 4, count)
+
+		require.NoError(t, repo.MarkRead(ctx, "m1", []string{added[1].ID.Hex(), added[2].ID.Hex()}))
+
+		// another merchant's ids are left alone
+		require.NoError(t, repo.MarkRead(ctx, "m2", []string{added[0].ID.Hex()}))
+
+		count, err = repo.CountUnread(ctx, "m1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		unread, err := repo.GetAllWhereMerchantIs(ctx, "m1", true, "", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{added[3].ID.Hex(), added[0].ID.Hex()}, notificationIds(unread))
+
+		all, err := repo.GetAllWhereMerchantIs(ctx, "m1", false, "", 10)
+		require.NoError(t, err)
+		require.Len(t, all, 4)
+		assert.True(t, all[1].Read)
+		assert.False(t, all[1].ReadAt.IsZero())
+
+		require.NoError(t, repo.MarkRead(ctx, "m1", nil))
+		count, err = repo.CountUnread(ctx, "m1")
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+
+		count, err = repo.CountUnread(ctx, "m2")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestIdempotencyRepository(t *testing.T, newRepo NewIdempotencyRepository) {
+	request := func(merchant string, livemode bool, key string, expiresIn time.Duration) domain.IdempotentRequest {
+		return domain.IdempotentRequest{Merchant: merchant, Livemode: livemode, Key: key, RequestHash: "h1", ExpiresAt: time.Now().Add(expiresIn)}
+	}
+
+	t.Run("OneRequestPerKey", func(t *testing.T) {
+		repo := newRepo(t)
+
+		first, started, err := repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		require.True(t, started)
+		require.False(t, first.ID.IsZero())
+
+		running, started, err := repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		assert.False(t, started)
+		assert.Equal(t, first.ID, running.ID)
+		assert.False(t, running.Done)
+
+		// keys are per merchant and mode
+		for _, other := range []domain.IdempotentRequest{request("m2", true, "k1", time.Minute), request("m1", false, "k1", time.Minute)} {
+			_, started, err = repo.Begin(ctx, other)
+			require.NoError(t, err)
+			assert.True(t, started)
+		}
+
+		first.Status, first.ContentType, first.Body = 200, "application/json", []byte(`{"ok":true}`)
+		first.ExpiresAt = time.Now().Add(time.Hour)
+		require.NoError(t, repo.Complete(ctx, &first))
+
+		done, started, err := repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		assert.False(t, started)
+		assert.True(t, done.Done)
+		assert.Equal(t, 200, done.Status)
+		assert.Equal(t, "h1", done.RequestHash)
+		assert.Equal(t, []byte(`{"ok":true}`), done.Body)
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		repo := newRepo(t)
+
+		first, started, err := repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		require.True(t, started)
+
+		require.NoError(t, repo.Release(ctx, first))
+
+		again, started, err := repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		assert.True(t, started)
+		assert.NotEqual(t, first.ID, again.ID)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, started, err := repo.Begin(ctx, request("m1", true, "k1", -time.Second))
+		require.NoError(t, err)
+		require.True(t, started)
+
+		_, started, err = repo.Begin(ctx, request("m1", true, "k1", time.Minute))
+		require.NoError(t, err)
+		assert.True(t, started)
+	})
+}
+
+func TestProfileJobRepository(t *testing.T, newRepo NewProfileJobRepository) {
+	job := func(card string, livemode bool, due time.Time) domain.ProfileJob {
+		return domain.ProfileJob{Card: card, Livemode: livemode, Reason: domain.ProfileReasonTransactions, Status: domain.ProfileJobPending, NextAttemptAt: due}
+	}
+
+	t.Run("OnePendingJobPerAccount", func(t *testing.T) {
+		repo := newRepo(t)
+
+		for _, j := range []domain.ProfileJob{job("fp1", true, day), job("fp1", false, day), job("fp2", true, day)} {
+			added, err := repo.Enqueue(ctx, j)
+			require.NoError(t, err)
+			assert.True(t, added)
+		}
+
+		added, err := repo.Enqueue(ctx, job("fp1", true, day))
+		require.NoError(t, err)
+		assert.False(t, added)
+
+		// once it runs the account can be queued again
+		claimed, ok, err := repo.ClaimDue(ctx, day, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		added, err = repo.Enqueue(ctx, job(claimed.Card, claimed.Livemode, day))
+		require.NoError(t, err)
+		assert.True(t, added)
+
+		counts, err := repo.CountByStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{domain.ProfileJobPending: 3, domain.ProfileJobRunning: 1}, counts)
+	})
+
+	t.Run("ClaimDue", func(t *testing.T) {
+		repo := newRepo(t)
+
+		for _, j := range []domain.ProfileJob{job("later", true, day.Add(time.Hour)), job("first", true, day.Add(-time.Hour)), job("second", true, day)} {
+			_, err := repo.Enqueue(ctx, j)
+			require.NoError(t, err)
+		}
+
+		first, ok, err := repo.ClaimDue(ctx, day, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "first", first.Card)
+		assert.Equal(t, domain.ProfileJobRunning, first.Status)
+		assert.Equal(t, day.Add(time.Minute), first.NextAttemptAt.UTC())
+
+		second, ok, err := repo.ClaimDue(ctx, day, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "second", second.Card)
+
+		_, ok, err = repo.ClaimDue(ctx, day, time.Minute)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		first.Status, first.FinishedAt = domain.ProfileJobDone, day
+		require.NoError(t, repo.Save(ctx, &first))
+
+		// the second job's worker died, its lease runs out before the later job is due
+		reclaimed, ok, err := repo.ClaimDue(ctx, day.Add(2*time.Minute), time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, second.ID, reclaimed.ID)
+
+		done, err := repo.Find(ctx, domain.ProfileJobDone, 10)
+		require.NoError(t, err)
+		require.Len(t, done, 1)
+		assert.Equal(t, first.ID, done[0].ID)
+
+		all, err := repo.Find(ctx, "", 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"second", "first"}, []string{all[0].Card, all[1].Card})
+	})
+}
+
+func TestApiKeyRepository(t *testing.T, newRepo NewApiKeyRepository) {
+	t.Run("AddAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+
+		added, err := repo.Add(domain.ApiKey{Merchant: "m1", Name: "Live key", Mode: domain.ApiKeyModeLive, KeyHash: "h1"})
+		require.NoError(t, err)
+		require.False(t, added.ID.IsZero())
+
+		byHash, err := repo.GetByHash("h1")
+		require.NoError(t, err)
+		assert.Equal(t, added.ID, byHash.ID)
+		assert.Equal(t, "Live key", byHash.Name)
+
+		byId, err := repo.GetById("m1", added.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, "h1", byId.KeyHash)
+
+		// another merchant's key is as good as missing
+		_, err = repo.GetById("m2", added.ID.Hex())
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		_, err = repo.GetByHash("h2")
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("HashesAreUnique", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.Add(domain.ApiKey{Merchant: "m1", KeyHash: "h1"})
+		require.NoError(t, err)
+
+		_, err = repo.Add(domain.ApiKey{Merchant: "m2", KeyHash: "h1"})
+		assert.True(t, mongo.IsDuplicateKeyError(err), "expected a duplicate key error, got %v", err)
+	})
+
+	t.Run("GetAllWhereMerchantIs", func(t *testing.T) {
+		repo := newRepo(t)
+
+		first, err := repo.Add(domain.ApiKey{Merchant: "m1", KeyHash: "h1"})
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+		second, err := repo.Add(domain.ApiKey{Merchant: "m1", KeyHash: "h2"})
+		require.NoError(t, err)
+		_, err = repo.Add(domain.ApiKey{Merchant: "m2", KeyHash: "h3"})
+		require.NoError(t, err)
+
+		keys, err := repo.GetAllWhereMerchantIs("m1")
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, second.ID, keys[0].ID, "newest first")
+		assert.Equal(t, first.ID, keys[1].ID)
+	})
+
+	t.Run("SaveAndTouchLastUsed", func(t *testing.T) {
+		repo := newRepo(t)
+
+		key, err := repo.Add(domain.ApiKey{Merchant: "m1", KeyHash: "h1"})
+		require.NoError(t, err)
+
+		key.RevokedAt = day
+		require.NoError(t, repo.Save(&key))
+		require.NoError(t, repo.TouchLastUsed(key.ID.Hex(), day.Add(time.Hour)))
+
+		got, err := repo.GetByHash("h1")
+		require.NoError(t, err)
+		assert.True(t, day.Equal(got.RevokedAt))
+		assert.True(t, day.Add(time.Hour).Equal(got.LastUsedAt))
+	})
+}
+
+func TestLoginCounterRepository(t *testing.T, newRepo NewLoginCounterRepository) {
+	t.Run("CountsWithinTheWindow", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, found, err := repo.Get(ctx, "email:a@b.c", day)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		for i := 1; i <= 3; i++ {
+			counter, err := repo.AddFailure(ctx, "email:a@b.c", day.Add(time.Duration(i)*time.Minute), time.Hour)
+			require.NoError(t, err)
+			assert.Equal(t, i, counter.Failures)
+		}
+
+		counter, found, err := repo.Get(ctx, "email:a@b.c", day.Add(time.Hour))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "email:a@b.c", counter.Key)
+		assert.Equal(t, 3, counter.Failures)
+		assert.Equal(t, day.Add(3*time.Minute), counter.LastFailure.UTC())
+		assert.Equal(t, day.Add(time.Hour+3*time.Minute), counter.ExpiresAt.UTC())
+
+		// keys are counted apart
+		other, err := repo.AddFailure(ctx, "ip:10.0.0.1", day, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, other.Failures)
+	})
+
+	t.Run("StartsOverOnceExpired", func(t *testing.T) {
+		repo := newRepo(t)
+
+		for i := 0; i < 2; i++ {
+			_, err := repo.AddFailure(ctx, "email:a@b.c", day, time.Hour)
+			require.NoError(t, err)
+		}
+
+		// the ttl monitor may not have removed it yet, it is gone all the same
+		_, found, err := repo.Get(ctx, "email:a@b.c", day.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		counter, err := repo.AddFailure(ctx, "email:a@b.c", day.Add(2*time.Hour), time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, counter.Failures)
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.AddFailure(ctx, "email:a@b.c", day, time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, repo.Lock(ctx, "email:a@b.c", day.Add(time.Hour)))
+
+		// the lock outlives the failure window and starts the count over
+		counter, found, err := repo.Get(ctx, "email:a@b.c", day.Add(30*time.Minute))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Zero(t, counter.Failures)
+		assert.Equal(t, day.Add(time.Hour), counter.LockedUntil.UTC())
+		assert.Equal(t, day.Add(time.Hour), counter.ExpiresAt.UTC())
+
+		// failing while locked doesn't cut the lock short
+		counter, err = repo.AddFailure(ctx, "email:a@b.c", day.Add(30*time.Minute), time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 1, counter.Failures)
+		assert.Equal(t, day.Add(time.Hour), counter.ExpiresAt.UTC())
+
+		_, found, err = repo.Get(ctx, "email:a@b.c", day.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := newRepo(t)
+
+		for _, key := range []string{"email:a@b.c", "ip:10.0.0.1"} {
+			_, err := repo.AddFailure(ctx, key, day, time.Hour)
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, repo.Delete(ctx, "email:a@b.c"))
+		require.NoError(t, repo.Delete(ctx, "email:unknown@b.c"))
+
+		_, found, err := repo.Get(ctx, "email:a@b.c", day)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, found, err = repo.Get(ctx, "ip:10.0.0.1", day)
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("storage")
+
+// startSpan opens a client span named after the repository method, e.g.
+// "transactions.GetAllWhereAccountIs".
+func startSpan(ctx context.Context, collName string, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, collName+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.collection.name", collName),
+			attribute.String("db.operation.name", method),
+		),
+	)
+}
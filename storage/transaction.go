@@ -3,163 +3,274 @@ package storage
 import (
 	"context"
 	"fraud-detect-system/domain"
+	"fraud-detect-system/tracing"
 	"github.com/kamva/mgm/v3"
 	"github.com/kamva/mgm/v3/operator"
 	"go.mongodb.org/mongo-driver/bson"
-	"time"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 )
 
 type TransactionStorage struct {
 	collName   string
 	collection *mgm.Collection
-	context    context.Context
 }
 
-func (t *TransactionStorage) GetNewTransactions(merchantId string, lastLoggedIn time.Time) int {
-	query := bson.M{
-		"merchant":   merchantId,
-		"created_at": bson.M{"$gte": lastLoggedIn},
-	}
+func (t *TransactionStorage) Add(ctx context.Context, newTransaction domain.Transaction) (_ domain.Transaction, err error) {
+	ctx, span := startSpan(ctx, t.collName, "Add")
+	defer tracing.End(span, &err)
 
-	totalNewNotifications, err := t.collection.CountDocuments(t.context, query)
+	err = t.collection.CreateWithCtx(ctx, &newTransaction)
 	if err != nil {
-		return 0
+		return domain.Transaction{}, err
 	}
 
-	return int(totalNewNotifications)
+	return newTransaction, err
 }
 
-func (t *TransactionStorage) GetRelatedPayments(merchantId string, prefix string) ([]domain.Transaction, error) {
-	datePrefix := prefix + "T00:00:00Z"
+func (t *TransactionStorage) GetById(ctx context.Context, merchant string, id string) (_ domain.Transaction, err error) {
+	ctx, span := startSpan(ctx, t.collName, "GetById")
+	defer tracing.End(span, &err)
 
-	startDate, _ := time.Parse(time.RFC3339, datePrefix)
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.Transaction{}, err
+	}
 
-	hr, _, _ := startDate.Clock()
+	var transaction domain.Transaction
+	err = t.collection.FirstWithCtx(ctx, bson.M{"_id": objectId, "merchant": merchant}, &transaction)
+	if err != nil {
+		return domain.Transaction{}, err
+	}
 
-	remainingHour := time.Duration(24 - hr)
-	duration := remainingHour * time.Hour
+	return transaction, nil
+}
 
-	endDate := startDate.Add(duration)
+func (t *TransactionStorage) GetAllWhereAccountIs(ctx context.Context, fingerprint string) (_ []domain.Transaction, err error) {
+	ctx, span := startSpan(ctx, t.collName, "GetAllWhereAccountIs")
+	defer tracing.End(span, &err)
 
-	query := bson.M{
-		"$and": []bson.M{
-			{
-				"$or": []bson.M{
-					{"_id": bson.M{operator.Regex: "^" + prefix}},
-					{"ip_address": bson.M{operator.Regex: "^" + prefix}},
-					{"state_of_transaction": bson.M{operator.Regex: "^" + prefix}},
-					{"created_at": bson.M{operator.Gte: startDate, operator.Lte: endDate}},
-				},
-			},
-			{"merchant": merchantId},
-		},
+	var transactions []domain.Transaction
+	err = t.collection.SimpleFindWithCtx(ctx, &transactions, bson.M{"card.fingerprint": fingerprint})
+	if err != nil {
+		return []domain.Transaction{}, err
 	}
 
-	var result []domain.Transaction
+	return transactions, nil
+}
+
+func (t *TransactionStorage) GetAllValidTransactionWhereAccountIs(ctx context.Context, fingerprint string) (_ []domain.Transaction, err error) {
+	ctx, span := startSpan(ctx, t.collName, "GetAllValidTransactionWhereAccountIs")
+	defer tracing.End(span, &err)
 
-	err := t.collection.SimpleFind(&result, query)
+	var transactions []domain.Transaction
+	err = t.collection.SimpleFindWithCtx(ctx, &transactions, bson.M{"card.fingerprint": fingerprint, "is_fraud": false})
 	if err != nil {
 		return []domain.Transaction{}, err
 	}
 
-	return result, nil
+	return transactions, nil
 }
 
-func (t *TransactionStorage) FetchTransactions(date time.Time, isFraud bool, merchant string) ([]domain.Transaction, error) {
-	// Set the start and end time for the given date
-	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
-	endOfDay := startOfDay.Add(24 * time.Hour)
-
-	// Define the filter to query the transactions for the given date and isFraud flag
-	filter := bson.M{
-		"is_fraud": isFraud,
-		"created_at": bson.M{
-			"$gte": startOfDay,
-			"$lt":  endOfDay,
-		},
-		"merchant": merchant,
+func (t *TransactionStorage) Save(ctx context.Context, transaction *domain.Transaction) (err error) {
+	ctx, span := startSpan(ctx, t.collName, "Save")
+	defer tracing.End(span, &err)
+
+	return t.collection.UpdateWithCtx(ctx, transaction)
+}
+
+// Find pages with a range on (sort field, _id) rather than skip, so a page costs the
+// same however deep it is. The indexes lead with merchant and end with the sort
+// field and _id for that reason.
+func (t *TransactionStorage) Find(ctx context.Context, query domain.TransactionQuery) (_ domain.TransactionPage, err error) {
+	ctx, span := startSpan(ctx, t.collName, "Find")
+	defer tracing.End(span, &err)
+
+	if err = query.Normalize(); err != nil {
+		return domain.TransactionPage{}, err
 	}
 
-	// Execute the find query to get the transactions for the given date and isFraud flag
-	cur, err := t.collection.Find(context.Background(), filter)
+	cursor, err := query.Cursor()
 	if err != nil {
-		return nil, err
+		return domain.TransactionPage{}, err
 	}
-	defer cur.Close(context.Background())
 
-	// Read the transactions
-	transactions := make([]domain.Transaction, 0)
-	for cur.Next(context.Background()) {
-		var transaction domain.Transaction
-		err := cur.Decode(&transaction)
-		if err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, transaction)
+	if query.Linked != nil && query.Linked.Empty() {
+		return domain.NewTransactionPage(query, nil), nil
 	}
 
-	return transactions, nil
-}
+	filter := transactionFilter(query)
 
-func (t *TransactionStorage) Add(newTransaction domain.Transaction) (domain.Transaction, error) {
-	err := t.collection.CreateWithCtx(t.context, &newTransaction)
-	if err != nil {
-		return domain.Transaction{}, err
+	order, after := 1, operator.Gt
+	if query.Desc {
+		order, after = -1, operator.Lt
 	}
 
-	return newTransaction, err
-}
+	if cursor != nil {
+		filter["$or"] = []bson.M{
+			{query.Sort: bson.M{after: cursor.Value()}},
+			{query.Sort: cursor.Value(), "_id": bson.M{after: cursor.Id}},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: query.Sort, Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(query.Limit + 1))
 
-func (t *TransactionStorage) GetAllWhereAccountIs(creditCard string) ([]domain.Transaction, error) {
 	var transactions []domain.Transaction
-	err := t.collection.SimpleFindWithCtx(t.context, &transactions, bson.M{"credit_card": creditCard})
+	err = t.collection.SimpleFindWithCtx(ctx, &transactions, filter, opts)
 	if err != nil {
-		return []domain.Transaction{}, err
+		return domain.TransactionPage{}, err
 	}
 
-	return transactions, nil
+	return domain.NewTransactionPage(query, transactions), nil
 }
 
-func (t *TransactionStorage) GetAllValidTransactionWhereAccountIs(creditCard string) ([]domain.Transaction, error) {
-	var transactions []domain.Transaction
-	err := t.collection.SimpleFindWithCtx(t.context, &transactions, bson.M{"credit_card": creditCard, "is_fraud": false})
-	if err != nil {
-		return []domain.Transaction{}, err
+func transactionFilter(query domain.TransactionQuery) bson.M {
+	filter := bson.M{"merchant": query.Merchant}
+
+	if !query.Id.IsZero() {
+		filter["_id"] = query.Id
 	}
 
-	return transactions, nil
+	created := bson.M{}
+	if !query.From.IsZero() {
+		created[operator.Gte] = query.From
+	}
+	if !query.To.IsZero() {
+		created[operator.Lt] = query.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	if r := between(query.MinAmount, query.MaxAmount); r != nil {
+		filter["amt"] = r
+	}
+	if r := between(query.MinRisk, query.MaxRisk); r != nil {
+		filter["risk_score"] = r
+	}
+
+	if query.Decision != "" {
+		filter["decision"] = query.Decision
+	}
+	if query.IsFraud != nil {
+		filter["is_fraud"] = *query.IsFraud
+	}
+
+	if query.CardFingerprint != "" {
+		filter["card.fingerprint"] = query.CardFingerprint
+	}
+	if query.CardBin != "" {
+		filter["card.bin"] = query.CardBin
+	}
+	if query.CardLast4 != "" {
+		filter["card.last4"] = query.CardLast4
+	}
+	if query.Email != "" {
+		filter["email"] = query.Email
+	}
+	if query.Ip != "" {
+		filter["ip_address"] = query.Ip
+	}
+	if query.State != "" {
+		filter["state_of_transaction"] = query.State
+	}
+
+	if search := textSearch(query.Text); search != "" {
+		filter["$text"] = bson.M{"$search": search}
+	}
+
+	if l := query.Linked; l != nil {
+		var shared []bson.M
+		if len(l.Ips) > 0 {
+			shared = append(shared, bson.M{"ip_address": bson.M{operator.In: l.Ips}})
+		}
+		if len(l.Emails) > 0 {
+			shared = append(shared, bson.M{"email": bson.M{operator.In: l.Emails}})
+		}
+		if len(l.Cards) > 0 {
+			shared = append(shared, bson.M{"card.fingerprint": bson.M{operator.In: l.Cards}})
+		}
+
+		// Find keeps the top level $or for the cursor
+		filter["$and"] = []bson.M{{"$or": shared}}
+	}
+
+	return filter
 }
 
-func (t *TransactionStorage) GetAllWhereMerchantIs(merchant string) ([]domain.Transaction, error) {
-	var transactions []domain.Transaction
-	err := t.collection.SimpleFindWithCtx(t.context, &transactions, bson.M{"merchant": merchant})
-	if err != nil {
-		return []domain.Transaction{}, err
+// textSearch makes words into a $text search for any of them. Quotes and a leading
+// "-" mean a phrase or a negation to Mongo, so they're dropped to keep every word a
+// plain one.
+func textSearch(words []string) string {
+	var plain []string
+	for _, w := range words {
+		w = strings.TrimLeft(strings.ReplaceAll(w, `"`, " "), "- ")
+		if w = strings.TrimSpace(w); w != "" {
+			plain = append(plain, w)
+		}
 	}
 
-	return transactions, nil
+	return strings.Join(plain, " ")
 }
 
-func (t *TransactionStorage) GetAll() ([]domain.Transaction, error) {
-	var transactions []domain.Transaction
-	err := t.collection.SimpleFindWithCtx(t.context, &transactions, bson.M{})
-	if err != nil {
-		return []domain.Transaction{}, err
+// between is the inclusive range [min, max], nil when neither end is set.
+func between(min, max *float64) bson.M {
+	if min == nil && max == nil {
+		return nil
 	}
 
-	return transactions, nil
+	r := bson.M{}
+	if min != nil {
+		r[operator.Gte] = *min
+	}
+	if max != nil {
+		r[operator.Lte] = *max
+	}
+
+	return r
 }
 
-func (t *TransactionStorage) Save(transaction *domain.Transaction) error {
-	return t.collection.UpdateWithCtx(t.context, transaction)
+// transactionIndexes back the scoring lookups by card and every Find: one index per
+// sort, the exact-match filters a merchant is likely to search by, and a text index
+// over the fields words are looked for in. Text search has no stemming or stop words,
+// so any word in an email or user agent can be found.
+var transactionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "card.fingerprint", Value: 1}, {Key: "created_at", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "amt", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "risk_score", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "email", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "ip_address", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "decision", Value: 1}, {Key: "created_at", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "state_of_transaction", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "card.fingerprint", Value: 1}}},
+	{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "card.last4", Value: 1}}},
+	{
+		Keys: bson.D{
+			{Key: "merchant", Value: 1},
+			{Key: "email", Value: "text"},
+			{Key: "ip_address", Value: "text"},
+			{Key: "state_of_transaction", Value: "text"},
+			{Key: "user_agent", Value: "text"},
+		},
+		Options: options.Index().SetName("merchant_text").SetDefaultLanguage("none"),
+	},
 }
 
 func NewTransactionStorage(ctx context.Context, collName string) *TransactionStorage {
 	collection := mgm.CollectionByName(collName)
 
+	_, err := collection.Indexes().CreateMany(ctx, transactionIndexes)
+	if err != nil {
+		panic(err)
+	}
+
 	return &TransactionStorage{
 		collName:   collName,
 		collection: collection,
-		context:    ctx,
 	}
 }
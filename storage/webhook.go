@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fraud-detect-system/domain"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type WebhookEndpointStorage struct {
+	collName   string
+	collection *mgm.Collection
+	context    context.Context
+}
+
+func (w *WebhookEndpointStorage) Add(endpoint domain.WebhookEndpoint) (domain.WebhookEndpoint, error) {
+	err := w.collection.CreateWithCtx(w.context, &endpoint)
+	if err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+func (w *WebhookEndpointStorage) GetById(merchant string, id string) (domain.WebhookEndpoint, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	var endpoint domain.WebhookEndpoint
+	err = w.collection.FirstWithCtx(w.context, bson.M{"_id": objectId, "merchant": merchant}, &endpoint)
+	if err != nil {
+		return domain.WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+func (w *WebhookEndpointStorage) GetAllWhereMerchantIs(merchant string) ([]domain.WebhookEndpoint, error) {
+	var endpoints []domain.WebhookEndpoint
+	err := w.collection.SimpleFindWithCtx(w.context, &endpoints, bson.M{"merchant": merchant})
+	if err != nil {
+		return []domain.WebhookEndpoint{}, err
+	}
+
+	return endpoints, nil
+}
+
+func (w *WebhookEndpointStorage) Save(endpoint *domain.WebhookEndpoint) error {
+	return w.collection.UpdateWithCtx(w.context, endpoint)
+}
+
+func (w *WebhookEndpointStorage) Delete(endpoint *domain.WebhookEndpoint) error {
+	return w.collection.DeleteWithCtx(w.context, endpoint)
+}
+
+func NewWebhookEndpointStorage(ctx context.Context, collName string) *WebhookEndpointStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "merchant", Value: 1}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &WebhookEndpointStorage{
+		collName:   collName,
+		collection: collection,
+		context:    ctx,
+	}
+}
+
+type WebhookDeliveryStorage struct {
+	collName   string
+	collection *mgm.Collection
+	context    context.Context
+}
+
+func (w *WebhookDeliveryStorage) Add(delivery domain.WebhookDelivery) (domain.WebhookDelivery, error) {
+	err := w.collection.CreateWithCtx(w.context, &delivery)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+func (w *WebhookDeliveryStorage) GetById(merchant string, id string) (domain.WebhookDelivery, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	var delivery domain.WebhookDelivery
+	err = w.collection.FirstWithCtx(w.context, bson.M{"_id": objectId, "merchant": merchant}, &delivery)
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+func (w *WebhookDeliveryStorage) GetAllWhereEndpointIs(merchant string, endpoint string) ([]domain.WebhookDelivery, error) {
+	var deliveries []domain.WebhookDelivery
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+
+	err := w.collection.SimpleFindWithCtx(w.context, &deliveries, bson.M{"merchant": merchant, "endpoint": endpoint}, opts)
+	if err != nil {
+		return []domain.WebhookDelivery{}, err
+	}
+
+	return deliveries, nil
+}
+
+func (w *WebhookDeliveryStorage) Save(delivery *domain.WebhookDelivery) error {
+	return w.collection.UpdateWithCtx(w.context, delivery)
+}
+
+func (w *WebhookDeliveryStorage) ClaimDue(now time.Time, lease time.Duration) (domain.WebhookDelivery, bool, error) {
+	filter := bson.M{
+		"status":          domain.DeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var delivery domain.WebhookDelivery
+	err := w.collection.FindOneAndUpdate(w.context, filter, update, opts).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return domain.WebhookDelivery{}, false, nil
+	}
+	if err != nil {
+		return domain.WebhookDelivery{}, false, err
+	}
+
+	return delivery, true, nil
+}
+
+func NewWebhookDeliveryStorage(ctx context.Context, collName string) *WebhookDeliveryStorage {
+	collection := mgm.CollectionByName(collName)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "merchant", Value: 1}, {Key: "endpoint", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &WebhookDeliveryStorage{
+		collName:   collName,
+		collection: collection,
+		context:    ctx,
+	}
+}
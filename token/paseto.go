@@ -7,64 +7,104 @@ import (
 )
 
 var pasetoMaker = paseto.NewV2()
-var symmetricKey = []byte("YELLOW SUBMARINE, BLACK WIZARDRY")
 
-const AccessTokenDuration = 20000 * time.Minute
-const RefreshTokenDuration = 168 * time.Hour
+const EmailVerificationTokenDuration = 48 * time.Hour
+const PasswordResetTokenDuration = 30 * time.Minute
+const MfaPendingTokenDuration = 5 * time.Minute
+
+const (
+	TypeAccess            = "access"
+	TypeRefresh           = "refresh"
+	TypeEmailVerification = "verify_email"
+	TypePasswordReset     = "reset_password"
+	TypeMfaPending        = "mfa_pending"
+)
 
 var ErrInvalidToken = errors.New("invalid token")
 
-func CreateAccessToken(email string, merchantId string) (string, *Payload, error) {
-	payload := NewPayload(email, merchantId, AccessTokenDuration, "access")
+// Maker issues and checks every token the api hands out, all encrypted with one key.
+type Maker struct {
+	symmetricKey []byte
 
-	token, err := pasetoMaker.Encrypt(symmetricKey, payload, nil)
-	if err != nil {
-		return "", &Payload{}, err
+	accessTokenDuration  time.Duration
+	refreshTokenDuration time.Duration
+}
+
+func NewMaker(symmetricKey string, accessTokenDuration time.Duration, refreshTokenDuration time.Duration) (*Maker, error) {
+	if len(symmetricKey) != 32 {
+		return nil, errors.New("token: symmetric key must be exactly 32 bytes")
 	}
 
-	return token, payload, nil
+	return &Maker{
+		symmetricKey: []byte(symmetricKey),
+
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+	}, nil
 }
 
-func CreateRefreshToken(email string, merchantId string) (string, *Payload, error) {
-	payload := NewPayload(email, merchantId, RefreshTokenDuration, "refresh")
+func (m *Maker) CreateAccessToken(email string, merchantId string) (string, *Payload, error) {
+	return m.createToken(email, merchantId, m.accessTokenDuration, TypeAccess)
+}
 
-	token, err := pasetoMaker.Encrypt(symmetricKey, payload, nil)
-	if err != nil {
-		return "", &Payload{}, err
-	}
+func (m *Maker) CreateRefreshToken(email string, merchantId string) (string, *Payload, error) {
+	return m.createToken(email, merchantId, m.refreshTokenDuration, TypeRefresh)
+}
 
-	return token, payload, nil
+func (m *Maker) CreateEmailVerificationToken(email string, merchantId string) (string, *Payload, error) {
+	return m.createToken(email, merchantId, EmailVerificationTokenDuration, TypeEmailVerification)
 }
 
-func VerifyAccessToken(token string) (*Payload, error) {
-	payload := &Payload{}
+func (m *Maker) CreatePasswordResetToken(email string, merchantId string) (string, *Payload, error) {
+	return m.createToken(email, merchantId, PasswordResetTokenDuration, TypePasswordReset)
+}
 
-	err := pasetoMaker.Decrypt(token, symmetricKey, payload, nil)
-	if err != nil {
-		return nil, ErrInvalidToken
-	}
+// CreateMfaPendingToken is handed out after a correct password when the merchant
+// still has to pass the second factor. It can only be exchanged at the 2FA step.
+func (m *Maker) CreateMfaPendingToken(email string, merchantId string) (string, *Payload, error) {
+	return m.createToken(email, merchantId, MfaPendingTokenDuration, TypeMfaPending)
+}
 
-	if payload.Type != "access" {
-		return nil, ErrInvalidToken
-	}
+func (m *Maker) VerifyAccessToken(token string) (*Payload, error) {
+	return m.verifyToken(token, TypeAccess)
+}
 
-	err = payload.Valid()
+func (m *Maker) VerifyRefreshToken(token string) (*Payload, error) {
+	return m.verifyToken(token, TypeRefresh)
+}
+
+func (m *Maker) VerifyEmailVerificationToken(token string) (*Payload, error) {
+	return m.verifyToken(token, TypeEmailVerification)
+}
+
+func (m *Maker) VerifyPasswordResetToken(token string) (*Payload, error) {
+	return m.verifyToken(token, TypePasswordReset)
+}
+
+func (m *Maker) VerifyMfaPendingToken(token string) (*Payload, error) {
+	return m.verifyToken(token, TypeMfaPending)
+}
+
+func (m *Maker) createToken(email string, merchantId string, duration time.Duration, tokenType string) (string, *Payload, error) {
+	payload := NewPayload(email, merchantId, duration, tokenType)
+
+	token, err := pasetoMaker.Encrypt(m.symmetricKey, payload, nil)
 	if err != nil {
-		return nil, err
+		return "", &Payload{}, err
 	}
 
-	return payload, nil
+	return token, payload, nil
 }
 
-func VerifyRefreshToken(token string) (*Payload, error) {
+func (m *Maker) verifyToken(token string, tokenType string) (*Payload, error) {
 	payload := &Payload{}
 
-	err := pasetoMaker.Decrypt(token, symmetricKey, payload, nil)
+	err := pasetoMaker.Decrypt(token, m.symmetricKey, payload, nil)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
 
-	if payload.Type != "refresh" {
+	if payload.Type != tokenType {
 		return nil, ErrInvalidToken
 	}
 
@@ -3,6 +3,7 @@ package token
 import (
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"sync"
 	"time"
 )
 
@@ -20,15 +21,60 @@ func SessionKey(id string) string {
 	return fmt.Sprintf("session:%s", id)
 }
 
-func NewSession(c *fiber.Ctx, p *Payload, refreshToken string) *Session {
+func NewSession(c *fiber.Ctx, p *Payload, refreshToken string, clientIp string) *Session {
 	return &Session{
 		Email:        p.Email,
 		RefreshToken: refreshToken,
-		//ClientIp:     c.IP(),
-		ClientIp:  c.GetReqHeaders()["X-Forwarded-For"],
-		IsBlocked: false,
-		UserAgent: fiber.AcquireAgent().Name,
-		CreatedAt: time.Now(),
-		ExpiredAt: p.ExpiredAt,
+		ClientIp:     clientIp,
+		IsBlocked:    false,
+		UserAgent:    fiber.AcquireAgent().Name,
+		CreatedAt:    time.Now(),
+		ExpiredAt:    p.ExpiredAt,
 	}
 }
+
+// SessionStore keeps refresh token sessions in memory, keyed by SessionKey.
+type SessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s *SessionStore) Get(key string) (Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[key]
+	return session, ok
+}
+
+func (s *SessionStore) Set(key string, session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[key] = session
+}
+
+// BlockAll blocks every session belonging to email and returns how many were blocked.
+func (s *SessionStore) BlockAll(email string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocked := 0
+	for key, session := range s.sessions {
+		if session.Email != email || session.IsBlocked {
+			continue
+		}
+
+		session.IsBlocked = true
+		s.sessions[key] = session
+		blocked++
+	}
+
+	return blocked
+}
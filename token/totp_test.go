@@ -0,0 +1,43 @@
+package token
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHOTP_RFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, want := range vectors {
+		assert.Equal(t, want, hotp(key, uint64(unix/30), 8), "time %d", unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111109, 0)
+
+	step, ok := ValidateTOTP(secret, "081804", at)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1111111109/30), step)
+
+	_, ok = ValidateTOTP(secret, "081804", at.Add(TOTPPeriod))
+	assert.True(t, ok, "one step of skew is allowed")
+
+	_, ok = ValidateTOTP(secret, "081804", at.Add(3*TOTPPeriod))
+	assert.False(t, ok)
+
+	_, ok = ValidateTOTP(secret, "12345", at)
+	assert.False(t, ok)
+}
@@ -0,0 +1,153 @@
+// Package vault keeps card numbers out of storage. A number is replaced by a keyed
+// fingerprint to look it up by, its bin and last 4 digits to show, and, where the
+// number has to be kept at all, an encrypted copy only this package can open.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fraud-detect-system/domain"
+	"strings"
+)
+
+var (
+	ErrInvalidCard      = errors.New("card number must be 12 to 19 digits")
+	ErrFailsLuhn        = errors.New("card number fails the luhn check")
+	ErrCannotDecrypt    = errors.New("vault: cannot decrypt card number")
+	errInvalidKeyLength = errors.New("vault: keys must be exactly 32 bytes")
+)
+
+// Vault fingerprints card numbers with one key and encrypts them with another, so
+// the lookup key can be shared with the data warehouse without the numbers.
+type Vault struct {
+	fingerprintKey []byte
+	aead           cipher.AEAD
+}
+
+func New(fingerprintKey string, encryptionKey string) (*Vault, error) {
+	if len(fingerprintKey) != 32 || len(encryptionKey) != 32 {
+		return nil, errInvalidKeyLength
+	}
+
+	block, err := aes.NewCipher([]byte(encryptionKey))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{
+		fingerprintKey: []byte(fingerprintKey),
+		aead:           aead,
+	}, nil
+}
+
+// Normalize strips the spaces and dashes a card number is often written with.
+func Normalize(number string) (string, error) {
+	number = strings.NewReplacer(" ", "", "-", "").Replace(number)
+
+	if len(number) < 12 || len(number) > 19 {
+		return "", ErrInvalidCard
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidCard
+		}
+	}
+
+	return number, nil
+}
+
+// Validate checks number is one a card could have: 12 to 19 digits, the last of them
+// the luhn check digit. Protect doesn't, numbers stored before it was checked still
+// have to be fingerprinted.
+func Validate(number string) error {
+	number, err := Normalize(number)
+	if err != nil {
+		return err
+	}
+
+	sum := 0
+	for i := range number {
+		digit := int(number[len(number)-1-i] - '0')
+		// every second digit from the right is doubled
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+
+	if sum%10 != 0 {
+		return ErrFailsLuhn
+	}
+
+	return nil
+}
+
+// Fingerprint is the HMAC-SHA256 of the normalized number, hex encoded. Without the
+// key it can't be brute forced back to a number the way a plain hash can.
+func (v *Vault) Fingerprint(number string) string {
+	if normalized, err := Normalize(number); err == nil {
+		number = normalized
+	}
+
+	mac := hmac.New(sha256.New, v.fingerprintKey)
+	mac.Write([]byte(number))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Protect makes number into a card, encrypted copy included. Records that only refer
+// to the card should store card.WithoutNumber().
+func (v *Vault) Protect(number string) (domain.Card, error) {
+	number, err := Normalize(number)
+	if err != nil {
+		return domain.Card{}, err
+	}
+
+	card := domain.Card{
+		Fingerprint: v.Fingerprint(number),
+		Bin:         number[:6],
+		Last4:       number[len(number)-4:],
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return domain.Card{}, err
+	}
+
+	// the fingerprint is authenticated too, so a blob can't be moved to another card
+	sealed := v.aead.Seal(nonce, nonce, []byte(number), []byte(card.Fingerprint))
+	card.Encrypted = base64.StdEncoding.EncodeToString(sealed)
+
+	return card, nil
+}
+
+// Reveal decrypts the number kept with card.
+func (v *Vault) Reveal(card domain.Card) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(card.Encrypted)
+	if err != nil || len(sealed) < v.aead.NonceSize() {
+		return "", ErrCannotDecrypt
+	}
+
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+
+	number, err := v.aead.Open(nil, nonce, ciphertext, []byte(card.Fingerprint))
+	if err != nil {
+		return "", ErrCannotDecrypt
+	}
+
+	return string(number), nil
+}
@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestVault(t *testing.T) *Vault {
+	v, err := New("0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210")
+	require.NoError(t, err)
+	return v
+}
+
+func TestProtect(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Protect("4111 1111 1111 1111")
+	require.NoError(t, err)
+
+	assert.Equal(t, "411111", card.Bin)
+	assert.Equal(t, "1111", card.Last4)
+	assert.Equal(t, v.Fingerprint("4111-1111-1111-1111"), card.Fingerprint)
+	assert.NotContains(t, card.Encrypted, "4111111111111111")
+
+	number, err := v.Reveal(card)
+	require.NoError(t, err)
+	assert.Equal(t, "4111111111111111", number)
+
+	// the same card is encrypted differently every time but fingerprints the same
+	again, err := v.Protect("4111111111111111")
+	require.NoError(t, err)
+	assert.Equal(t, card.Fingerprint, again.Fingerprint)
+	assert.NotEqual(t, card.Encrypted, again.Encrypted)
+
+	assert.Empty(t, card.WithoutNumber().Encrypted)
+}
+
+func TestFingerprintDependsOnKey(t *testing.T) {
+	other, err := New("another key of exactly 32 bytes!", "fedcba9876543210fedcba9876543210")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, newTestVault(t).Fingerprint("4111111111111111"), other.Fingerprint("4111111111111111"))
+}
+
+func TestRevealRejectsTamperedCards(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Protect("4111111111111111")
+	require.NoError(t, err)
+
+	other, err := v.Protect("5500000000000004")
+	require.NoError(t, err)
+
+	card.Encrypted = other.Encrypted
+	_, err = v.Reveal(card)
+	assert.ErrorIs(t, err, ErrCannotDecrypt)
+}
+
+func TestProtectRejectsNonNumbers(t *testing.T) {
+	v := newTestVault(t)
+
+	for _, number := range []string{"", "4111", "4111-1111-1111-111x", "41111111111111111111"} {
+		_, err := v.Protect(number)
+		assert.ErrorIs(t, err, ErrInvalidCard, number)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, number := range []string{"4111 1111 1111 1111", "5500-0000-0000-0004", "378282246310005", "6011000990139424"} {
+		assert.NoError(t, Validate(number), number)
+	}
+
+	assert.ErrorIs(t, Validate("4111111111111112"), ErrFailsLuhn)
+	assert.ErrorIs(t, Validate("5500000000000040"), ErrFailsLuhn)
+	assert.ErrorIs(t, Validate("4111"), ErrInvalidCard)
+}